@@ -38,11 +38,21 @@ func main() {
 	// Init buffer for metrics
 	metricsBuffer := buffering.NewInMemBuffer()
 
+	// Init spool for metrics that failed to export (optional)
+	var metricsSpool agent.MetricsSpooler
+	if cfg.Spool.Dir != "" {
+		fileSpool, spoolErr := buffering.NewFileSpool(ctx, cfg.Spool)
+		if spoolErr != nil {
+			logger.New(ctx).Fatalf("Cannot init file spool: %s", spoolErr.Error())
+		}
+		metricsSpool = fileSpool
+	}
+
 	// Init agent app
-	app := agent.NewAgent(cfg, metricsBuffer)
+	app := agent.NewAgent(cfg, metricsBuffer, metricsSpool)
 
 	// Init collectors
-	runtimeCollector := collectors.NewRuntimeCollector("runtime")
+	runtimeCollector := collectors.NewRuntimeCollector("runtime", cfg.RuntimeCollector)
 	pollCountCollector := collectors.NewPollCountCollector("poll-count")
 	randomCollector, err := collectors.NewRandomCollector("random", cfg.RandomExporter)
 	if err != nil {
@@ -63,6 +73,13 @@ func main() {
 	// Init exporters
 	httpExporter := exporters.NewHTTPExporter("http", requestResponseFactory, cfg.HTTPExporter)
 	app.AddExporter(httpExporter)
+	if cfg.GRPCExporter.Address != "" {
+		grpcExporter, grpcErr := exporters.NewGRPCExporter(ctx, "grpc", hasher, cfg.GRPCExporter)
+		if grpcErr != nil {
+			logger.New(ctx).Fatalf("Cannot init gRPC exporter: %s", grpcErr.Error())
+		}
+		app.AddExporter(grpcExporter)
+	}
 
 	// Start agent
 	go app.StartCollecting(ctx)
@@ -83,8 +100,11 @@ func main() {
 		logger.New(ctx).Fatalf("Agent force-stopped (shutdown timeout)")
 	})
 
-	// Call cancel function and stop the agent
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
+
+	// Stop collecting/exporting, then wait for running workers with shutdown context (ctx is cancelled by then)
 	cancel()
-	app.Stop(ctx)
+	app.Stop(shutdownCtx)
 	logger.New(ctx).Infof("Agent stopped")
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -9,12 +10,16 @@ import (
 	"syscall"
 	"time"
 
+	"google.golang.org/grpc"
+
 	"eridiumdev/yandex-praktikum-go-devops/config"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/middleware"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/routing"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/templating"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/backup"
+	metricsGrpcDelivery "eridiumdev/yandex-praktikum-go-devops/internal/metrics/delivery/grpc"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/delivery/grpc/pb"
 	metricsHttpDelivery "eridiumdev/yandex-praktikum-go-devops/internal/metrics/delivery/http"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/hash"
 	metricsRendering "eridiumdev/yandex-praktikum-go-devops/internal/metrics/rendering"
@@ -22,6 +27,7 @@ import (
 	_metricsService "eridiumdev/yandex-praktikum-go-devops/internal/metrics/service"
 	monitoringHttpDelivery "eridiumdev/yandex-praktikum-go-devops/internal/monitoring/delivery/http"
 	"eridiumdev/yandex-praktikum-go-devops/internal/server"
+	"eridiumdev/yandex-praktikum-go-devops/web"
 )
 
 func main() {
@@ -43,7 +49,10 @@ func main() {
 
 	// Init repo and backuper, as well as monitored (pingable) components
 	var repo _metricsService.MetricsRepository
+	// Backuper is only used with in-mem repo, other repos are durable by themselves
 	var backuper _metricsService.MetricsBackuper
+	// Closers are closed on shutdown, after the final backup is taken
+	closers := make([]io.Closer, 0)
 	pingable := make([]monitoringHttpDelivery.Pingable, 0)
 
 	if cfg.Database.DSN != "" {
@@ -51,30 +60,78 @@ func main() {
 		if pgErr != nil {
 			logger.New(ctx).Fatalf("Cannot init postgres repo: %s", pgErr.Error())
 		}
-		// Add postgres repo to monitored components
-		pingable = append(pingable, postgresRepo)
-		// Assign postgresRepo to repo (this way postgresRepo can be used as both MetricsRepository and Pingable)
-		repo = postgresRepo
-		// Backuper is not needed if database is used
-		backuper = nil
+		// Decorators are applied in order: cache (so that cached series can be read while database is unavailable),
+		// then write-behind journal (so that journaled updates are written through the cache on replay)
+		var backingRepo metricsRepository.BackingRepository = postgresRepo
+		if cfg.Database.Cache.Enabled {
+			var invalidator metricsRepository.CacheInvalidator
+			if cfg.Database.Cache.Notify {
+				invalidator = postgresRepo
+			}
+			cachedRepo, cacheErr := metricsRepository.NewCachedRepo(ctx, postgresRepo, cfg.Database.Cache, invalidator)
+			if cacheErr != nil {
+				logger.New(ctx).Fatalf("Cannot init cached repo: %s", cacheErr.Error())
+			}
+			backingRepo = cachedRepo
+		}
+		if cfg.Database.JournalCapacity > 0 {
+			// Keep accepting updates while database is unavailable, degraded state is reported on ping
+			writeBehindRepo := metricsRepository.NewWriteBehindRepo(ctx, backingRepo, cfg.Database)
+			closers = append(closers, writeBehindRepo)
+			backingRepo = writeBehindRepo
+		}
+		// Add repo to monitored components
+		pingable = append(pingable, backingRepo)
+		// Assign backingRepo to repo (this way it can be used as both MetricsRepository and Pingable)
+		repo = backingRepo
+	} else if cfg.Redis.Address != "" {
+		redisRepo, redisErr := metricsRepository.NewRedisRepo(ctx, cfg.Redis)
+		if redisErr != nil {
+			logger.New(ctx).Fatalf("Cannot init redis repo: %s", redisErr.Error())
+		}
+		pingable = append(pingable, redisRepo)
+		repo = redisRepo
+		closers = append(closers, redisRepo)
+	} else if cfg.SQLite.Path != "" {
+		sqliteRepo, sqliteErr := metricsRepository.NewSQLiteRepo(ctx, cfg.SQLite)
+		if sqliteErr != nil {
+			logger.New(ctx).Fatalf("Cannot init sqlite repo: %s", sqliteErr.Error())
+		}
+		pingable = append(pingable, sqliteRepo)
+		repo = sqliteRepo
+		closers = append(closers, sqliteRepo)
+	} else if cfg.WAL.Dir != "" {
+		walRepo, walErr := metricsRepository.NewWALRepo(ctx, cfg.WAL)
+		if walErr != nil {
+			logger.New(ctx).Fatalf("Cannot init WAL repo: %s", walErr.Error())
+		}
+		repo = walRepo
+		closers = append(closers, walRepo)
 	} else {
 		// If database is not enabled, use in-mem repo + file backuper
-		repo = metricsRepository.NewInMemRepo()
-		backuper, err = backup.NewFileBackuper(ctx, cfg.FileBackuperPath)
-		if err != nil {
-			logger.New(ctx).Fatalf("Cannot init file backuper: %s", err.Error())
+		repo = metricsRepository.NewInMemRepoWithHistory(cfg.History.InMemCapacity)
+		fileBackuper, fbErr := backup.NewFileBackuper(ctx, cfg.FileBackuperPath, cfg.Backup.Generations)
+		if fbErr != nil {
+			logger.New(ctx).Fatalf("Cannot init file backuper: %s", fbErr.Error())
 		}
+		backuper = fileBackuper
+		closers = append(closers, fileBackuper)
 	}
 
 	// Init services
-	metricsService, err := _metricsService.NewMetricsService(ctx, repo, backuper, cfg.Backup)
+	metricsService, err := _metricsService.NewMetricsService(ctx, repo, backuper, cfg.Backup, cfg.Retention)
 	if err != nil {
 		logger.New(ctx).Fatalf("Cannot init metrics service: %s", err.Error())
 	}
+	metricsService.EnableStreaming(ctx, cfg.Stream)
 
 	// Init rendering engines
-	templateParser := templating.NewHTMLTemplateParser(cfg.TemplatesDir)
+	templateParser, err := templating.NewHTMLTemplateParser(ctx, web.Templates(), cfg.TemplatesDir, cfg.TemplatesReload)
+	if err != nil {
+		logger.New(ctx).Fatalf("Cannot parse HTML templates: %s", err.Error())
+	}
 	metricsRenderer := metricsRendering.NewHTMLEngine(templateParser)
+	metricsExpositionRenderer := metricsRendering.NewPrometheusEngine()
 
 	// Init router
 	router := routing.NewChiRouter(middleware.URLTrimmer)
@@ -85,21 +142,58 @@ func main() {
 
 	// Init handlers
 	metricsHandler := metricsHttpDelivery.NewMetricsHandler(
-		metricsService, metricsRenderer, metricsRequestResponseFactory, metricsHasher)
+		metricsService, metricsRenderer, metricsExpositionRenderer, metricsRequestResponseFactory, metricsHasher, router)
+	metricsHandler.AddRenderer(metricsRendering.FormatJSON, metricsRendering.NewJSONEngine())
+	metricsHandler.AddRenderer(metricsRendering.FormatCSV, metricsRendering.NewCSVEngine())
+	metricsHandler.AddRenderer(metricsRendering.FormatText, metricsRendering.NewTextEngine())
+	metricsHandler.AddRenderer(metricsRendering.FormatPrometheus, metricsExpositionRenderer)
 	router.AddRoute(http.MethodGet, "/", metricsHandler.List, middleware.BasicSet...)
+	router.AddRoute(http.MethodGet, "/metrics", metricsHandler.Exposition, middleware.BasicSet...)
 	router.AddRoute(http.MethodPost, "/value", metricsHandler.Get, middleware.ExtendedSet...)
 	router.AddRoute(http.MethodPost, "/update", metricsHandler.Update, middleware.ExtendedSet...)
 	router.AddRoute(http.MethodPost, "/updates", metricsHandler.UpdateBatch, middleware.ExtendedSet...)
+	router.AddRoute(http.MethodGet, "/value/{type}/{name}", metricsHandler.GetFromURL, middleware.BasicSet...)
+	router.AddRoute(http.MethodPost, "/update/{type}/{name}/{value}", metricsHandler.UpdateFromURL, middleware.BasicSet...)
+	router.AddRoute(http.MethodGet, "/api/v1/metrics", metricsHandler.Query, middleware.BasicSet...)
+	router.AddRoute(http.MethodGet, "/api/v1/metrics/{name}/history", metricsHandler.History, middleware.BasicSet...)
+	router.AddRoute(http.MethodGet, "/api/v1/stream", metricsHandler.Stream, middleware.BasicSet...)
+
+	metricsHandler.SetDashboardRenderer(metricsRenderer)
+	router.AddRoute(http.MethodGet, metricsHttpDelivery.DashboardPath, metricsHandler.Dashboard, middleware.BasicSet...)
+	router.AddRoute(http.MethodGet, metricsHttpDelivery.DashboardPath+"/{name}", metricsHandler.MetricPage,
+		middleware.BasicSet...)
+
+	adminSet := append(append([]func(next http.Handler) http.Handler{}, middleware.BasicSet...),
+		middleware.RequireToken(cfg.AdminToken))
+	router.AddRoute(http.MethodDelete, "/api/v1/metrics", metricsHandler.Delete, adminSet...)
+	router.AddRoute(http.MethodPost, "/api/v1/metrics/reset", metricsHandler.Reset, adminSet...)
 
 	monitoringHandler := monitoringHttpDelivery.NewMonitoringHandler(pingable...)
 	router.AddRoute(http.MethodGet, "/ping", monitoringHandler.Ping, middleware.BasicSet...)
 
 	// Init HTTP server app
 	app := server.NewServer(router.GetHandler(), cfg)
+	// Streams never finish by themselves, so they are closed for the server to stop gracefully
+	app.Server.RegisterOnShutdown(metricsService.CloseStreams)
+
+	// Init gRPC server app (optional)
+	var grpcApp *server.GRPCServer
+	if cfg.GRPCAddress != "" {
+		hashInterceptor := metricsGrpcDelivery.NewHashInterceptor(metricsHasher)
+		grpcApp = server.NewGRPCServer(cfg,
+			grpc.ChainUnaryInterceptor(hashInterceptor.UnaryServer()),
+			grpc.ChainStreamInterceptor(hashInterceptor.StreamServer()),
+		)
+		pb.RegisterMetricsServer(grpcApp.Server, metricsGrpcDelivery.NewMetricsServer(metricsService))
+	}
 
 	// Start server
 	logger.New(ctx).Infof("Starting HTTP app on %s", cfg.Address)
 	go app.Start(ctx)
+	if grpcApp != nil {
+		logger.New(ctx).Infof("Starting gRPC app on %s", cfg.GRPCAddress)
+		go grpcApp.Start(ctx)
+	}
 
 	// Handle OS signals for graceful shutdown
 	quit := make(chan os.Signal, 1)
@@ -109,20 +203,27 @@ func main() {
 
 	// Allow some time for server and components to clean up
 	time.AfterFunc(cfg.ShutdownTimeout, func() {
-		cleanup(cancel, time.Second)
 		logger.New(ctx).Fatalf("Server force-stopped (shutdown timeout)")
 	})
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelShutdown()
 
-	// Stop the server
-	app.Stop(ctx)
+	// Stop the server, so that no more updates come in
+	app.Stop(shutdownCtx)
+	if grpcApp != nil {
+		grpcApp.Stop(shutdownCtx)
+	}
 	logger.New(ctx).Infof("Server stopped")
 
-	// Clean-up other components, e.g. backuper
-	cleanup(cancel, time.Second)
-}
-
-func cleanup(cancel context.CancelFunc, wait time.Duration) {
+	// Stop background jobs and wait for them to finish, then take the final backup before storage is closed
 	cancel()
-	time.Sleep(wait)
-	logger.New(context.Background()).Infof("Clean-up finished")
+	if err = metricsService.Shutdown(shutdownCtx); err != nil {
+		logger.New(ctx).Errorf("Error when shutting down metrics service: %s", err.Error())
+	}
+	for _, closer := range closers {
+		if err = closer.Close(); err != nil {
+			logger.New(ctx).Errorf("Error when closing storage: %s", err.Error())
+		}
+	}
+	logger.New(ctx).Infof("Clean-up finished")
 }
@@ -2,6 +2,8 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
@@ -14,10 +16,35 @@ type AgentConfig struct {
 	ExportInterval  time.Duration `env:"REPORT_INTERVAL"`
 	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"3s"`
 
-	RandomExporter RandomExporterConfig `envPrefix:"RANDOM_EXPORTER_"`
-	HTTPExporter   HTTPExporterConfig
+	RandomExporter   RandomExporterConfig   `envPrefix:"RANDOM_EXPORTER_"`
+	RuntimeCollector RuntimeCollectorConfig `envPrefix:"RUNTIME_COLLECTOR_"`
+	HTTPExporter     HTTPExporterConfig
+	GRPCExporter     GRPCExporterConfig `envPrefix:"GRPC_EXPORTER_"`
+	Spool            SpoolConfig        `envPrefix:"SPOOL_"`
 
 	HashKey string `env:"KEY"`
+	// Labels are attached to every collected metric, labels set by collectors take precedence
+	Labels Labels `env:"LABELS"`
+}
+
+// Labels are static metric labels in 'key1=value1,key2=value2' form
+type Labels map[string]string
+
+func (l *Labels) UnmarshalText(text []byte) error {
+	labels := make(Labels)
+	for _, pair := range strings.Split(string(text), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("invalid label '%s', must be in 'key=value' form", pair)
+		}
+		labels[key] = strings.TrimSpace(value)
+	}
+	*l = labels
+	return nil
 }
 
 type RandomExporterConfig struct {
@@ -25,9 +52,41 @@ type RandomExporterConfig struct {
 	Max int `env:"MAX" envDefault:"9999"`
 }
 
+type RuntimeCollectorConfig struct {
+	// GCPauseBuckets are upper bounds (in ns) of GC pause histogram buckets
+	GCPauseBuckets []float64 `env:"GC_PAUSE_BUCKETS" envDefault:"1e4,5e4,1e5,5e5,1e6,5e6,1e7,5e7,1e8"`
+}
+
 type HTTPExporterConfig struct {
 	Address string        `env:"ADDRESS"`
 	Timeout time.Duration `env:"TIMEOUT" envDefault:"3s"`
+	Retry   RetryConfig   `envPrefix:"HTTP_EXPORTER_RETRY_"`
+}
+
+type RetryConfig struct {
+	MaxAttempts int           `env:"MAX_ATTEMPTS" envDefault:"3"`
+	BaseDelay   time.Duration `env:"BASE_DELAY" envDefault:"100ms"`
+	MaxDelay    time.Duration `env:"MAX_DELAY" envDefault:"2s"`
+	// Jitter is a fraction of delay (0..1) by which each delay is randomly spread
+	Jitter            float64  `env:"JITTER" envDefault:"0.2"`
+	RetryableStatuses []int    `env:"RETRYABLE_STATUSES" envDefault:"429,500,502,503,504"`
+	RetryableErrors   []string `env:"RETRYABLE_ERRORS" envDefault:"timeout,connection-refused,connection-reset"`
+}
+
+type GRPCExporterConfig struct {
+	// Address of gRPC server, gRPC exporter is disabled if empty
+	Address string        `env:"ADDRESS"`
+	Timeout time.Duration `env:"TIMEOUT" envDefault:"3s"`
+	// UseStream makes exporter send metrics with client-streaming RPC instead of a single batch
+	UseStream bool `env:"USE_STREAM"`
+}
+
+type SpoolConfig struct {
+	// Dir is where snapshots that failed to export are spooled, spooling is disabled if empty
+	Dir         string        `env:"DIR"`
+	SegmentSize int64         `env:"SEGMENT_SIZE" envDefault:"1048576"`
+	MaxSize     int64         `env:"MAX_SIZE" envDefault:"67108864"`
+	MaxAge      time.Duration `env:"MAX_AGE" envDefault:"24h"`
 }
 
 func LoadAgentConfig() (*AgentConfig, error) {
@@ -37,7 +96,12 @@ func LoadAgentConfig() (*AgentConfig, error) {
 	flag.DurationVar(&cfg.CollectInterval, "p", 2*time.Second, "metrics collect/poll interval")
 	flag.DurationVar(&cfg.ExportInterval, "r", 10*time.Second, "metrics export/report interval")
 	flag.StringVar(&cfg.HTTPExporter.Address, "a", "localhost:8080", "HTTP exporter target address")
+	flag.StringVar(&cfg.GRPCExporter.Address, "g", "", "gRPC exporter target address, disabled if empty")
 	flag.StringVar(&cfg.HashKey, "k", "", "Hash key for signing metrics data")
+	flag.StringVar(&cfg.Spool.Dir, "spool-dir", "", "Directory for spooling metrics that failed to export")
+	flag.Func("labels", "Static labels attached to metrics, in 'key1=value1,key2=value2' form", func(value string) error {
+		return cfg.Labels.UnmarshalText([]byte(value))
+	})
 
 	parseLoggerConfigFlags(&cfg.Logger)
 
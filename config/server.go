@@ -2,6 +2,9 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
@@ -10,23 +13,167 @@ import (
 type ServerConfig struct {
 	Logger           LoggerConfig
 	Address          string        `env:"ADDRESS"`
+	GRPCAddress      string        `env:"GRPC_ADDRESS"`
 	FileBackuperPath string        `env:"STORE_FILE"`
 	ShutdownTimeout  time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"3s"`
 	Backup           BackupConfig
-	Database         DatabaseConfig `envPrefix:"DATABASE_"`
-	HashKey          string         `env:"KEY"`
-	TemplatesDir     string         `env:"RENDERING_TEMPLATES_DIR" envDefault:"web/templates"`
+	Database         DatabaseConfig  `envPrefix:"DATABASE_"`
+	Redis            RedisConfig     `envPrefix:"REDIS_"`
+	SQLite           SQLiteConfig    `envPrefix:"SQLITE_"`
+	WAL              WALConfig       `envPrefix:"WAL_"`
+	History          HistoryConfig   `envPrefix:"HISTORY_"`
+	Retention        RetentionConfig `envPrefix:"RETENTION_"`
+	Stream           StreamConfig    `envPrefix:"STREAM_"`
+	HashKey          string          `env:"KEY"`
+	// AdminToken authorizes destructive API calls (deleting metrics, resetting counters), they are disabled if empty
+	AdminToken string `env:"ADMIN_TOKEN"`
+	// TemplatesDir overrides templates embedded into the binary, files replace embedded ones with the same path
+	TemplatesDir string `env:"RENDERING_TEMPLATES_DIR"`
+	// TemplatesReload parses templates on every render, so that changes are picked up without restart
+	TemplatesReload bool `env:"RENDERING_TEMPLATES_RELOAD"`
 }
 
 type BackupConfig struct {
+	// Interval between periodic backups, 0 means backup is taken synchronously after every update
 	Interval  time.Duration `env:"STORE_INTERVAL"`
 	DoRestore bool          `env:"RESTORE"`
+	// Generations is how many backup files are kept, restore falls back to older ones if the latest is corrupt
+	Generations int `env:"STORE_GENERATIONS" envDefault:"3"`
 }
 
 type DatabaseConfig struct {
 	DSN            string        `env:"DSN"`
 	MigrationsDir  string        `env:"MIGRATIONS_DIR" envDefault:"migrations"`
 	ConnectTimeout time.Duration `env:"CONNECT_TIMEOUT" envDefault:"3s"`
+	// JournalCapacity is how many metrics are kept in memory while database is unavailable,
+	// they are written once it is back, 0 disables the journal
+	JournalCapacity int `env:"JOURNAL_CAPACITY" envDefault:"100000"`
+	// Reconnect delays grow exponentially from base to max delay
+	ReconnectBaseDelay time.Duration `env:"RECONNECT_BASE_DELAY" envDefault:"500ms"`
+	ReconnectMaxDelay  time.Duration `env:"RECONNECT_MAX_DELAY" envDefault:"30s"`
+	Cache              CacheConfig   `envPrefix:"CACHE_"`
+}
+
+type CacheConfig struct {
+	// Enabled puts an in-memory cache of current values in front of the database, it is updated on every write
+	Enabled bool `env:"ENABLED"`
+	// Warm loads all metrics on startup, otherwise they are loaded on first read
+	Warm bool `env:"WARM" envDefault:"true"`
+	// Notify invalidates caches of other replicas through Postgres LISTEN/NOTIFY, so that they stay coherent
+	// Without it, a replica does not see writes of other replicas until restart
+	Notify  bool   `env:"NOTIFY"`
+	Channel string `env:"CHANNEL" envDefault:"metrics_changed"`
+}
+
+type RedisConfig struct {
+	// Address of redis server, redis repo is used if set (and database DSN is not)
+	Address  string `env:"ADDRESS"`
+	Password string `env:"PASSWORD"`
+	DB       int    `env:"DB"`
+	// Prefix of all keys, so that several installations can share redis
+	Prefix  string        `env:"PREFIX" envDefault:"metrics"`
+	Timeout time.Duration `env:"TIMEOUT" envDefault:"3s"`
+}
+
+type SQLiteConfig struct {
+	// Path of database file, SQLite repo is used if set (and database DSN is not)
+	Path          string `env:"PATH"`
+	MigrationsDir string `env:"MIGRATIONS_DIR" envDefault:"migrations/sqlite"`
+	// Timeout is used for pings and as busy timeout when database is locked by another writer
+	Timeout time.Duration `env:"TIMEOUT" envDefault:"3s"`
+}
+
+type WALConfig struct {
+	// Dir is where write-ahead log and its snapshots are kept, WAL repo is used if set (and database DSN is not)
+	// WAL repo does not keep history of metrics
+	Dir string `env:"DIR"`
+	// CheckpointInterval is how often snapshot is taken and log is compacted, 0 means only on shutdown
+	CheckpointInterval time.Duration `env:"CHECKPOINT_INTERVAL" envDefault:"5m"`
+}
+
+type HistoryConfig struct {
+	// InMemCapacity is max amount of samples kept per metric by in-mem repo, history is not kept if it is 0
+	InMemCapacity int `env:"INMEM_CAPACITY" envDefault:"1000"`
+}
+
+type RetentionConfig struct {
+	// CompactInterval is how often history is rolled up and expired data is dropped, 0 disables compaction
+	CompactInterval time.Duration `env:"COMPACT_INTERVAL" envDefault:"1m"`
+	// Rules are ';'-separated '<name glob>=raw:<ttl>,1m:<ttl>,1h:<ttl>' rules, first matching rule is applied
+	// TTL of 0 (or omitted) means data of that resolution is kept forever
+	Rules RetentionRules `env:"RULES" envDefault:"*=raw:24h,1m:168h,1h:8760h"`
+	// MetricTTL is how long a metric is kept without updates, 0 means metrics are kept forever
+	MetricTTL time.Duration `env:"METRIC_TTL"`
+}
+
+type StreamConfig struct {
+	// BufferSize is how many updates are queued per subscriber, subscribers that fall further behind are disconnected
+	BufferSize int `env:"BUFFER_SIZE" envDefault:"256"`
+	// MaxSubscribers limits concurrent stream subscribers, 0 means unlimited
+	MaxSubscribers int `env:"MAX_SUBSCRIBERS" envDefault:"1000"`
+	// Heartbeat is how often keepalives are sent to subscribers, so that idle connections are not dropped by proxies
+	Heartbeat time.Duration `env:"HEARTBEAT" envDefault:"15s"`
+}
+
+type RetentionRules []RetentionRule
+
+type RetentionRule struct {
+	Pattern string
+	Raw     time.Duration
+	Minute  time.Duration
+	Hour    time.Duration
+}
+
+// UnmarshalText parses retention rules, e.g. 'Alloc*=raw:1h,1m:24h;*=raw:24h,1m:168h,1h:8760h'
+func (r *RetentionRules) UnmarshalText(text []byte) error {
+	rules := make(RetentionRules, 0)
+	for _, ruleText := range strings.Split(string(text), ";") {
+		ruleText = strings.TrimSpace(ruleText)
+		if ruleText == "" {
+			continue
+		}
+		pattern, ttls, ok := strings.Cut(ruleText, "=")
+		if !ok {
+			return fmt.Errorf("retention rule '%s' has no '='", ruleText)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("retention rule '%s' has invalid pattern: %w", ruleText, err)
+		}
+		rule := RetentionRule{Pattern: strings.TrimSpace(pattern)}
+		for _, ttlText := range strings.Split(ttls, ",") {
+			resolution, ttlValue, ok := strings.Cut(strings.TrimSpace(ttlText), ":")
+			if !ok {
+				return fmt.Errorf("retention rule '%s' has invalid ttl '%s'", ruleText, ttlText)
+			}
+			ttl, err := time.ParseDuration(ttlValue)
+			if err != nil {
+				return fmt.Errorf("retention rule '%s' has invalid ttl '%s': %w", ruleText, ttlText, err)
+			}
+			switch resolution {
+			case "raw":
+				rule.Raw = ttl
+			case "1m":
+				rule.Minute = ttl
+			case "1h":
+				rule.Hour = ttl
+			default:
+				return fmt.Errorf("retention rule '%s' has unknown resolution '%s'", ruleText, resolution)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	*r = rules
+	return nil
+}
+
+// Match returns the first rule with pattern matching metric name, or an empty rule (keep forever) if none match
+func (r RetentionRules) Match(name string) RetentionRule {
+	for _, rule := range r {
+		if ok, _ := path.Match(rule.Pattern, name); ok {
+			return rule
+		}
+	}
+	return RetentionRule{Pattern: "*"}
 }
 
 func LoadServerConfig() (*ServerConfig, error) {
@@ -34,11 +181,19 @@ func LoadServerConfig() (*ServerConfig, error) {
 
 	// Parse flag-settable fields
 	flag.StringVar(&cfg.Address, "a", "localhost:8080", "HTTP server address")
+	flag.StringVar(&cfg.GRPCAddress, "g", "", "gRPC server address, gRPC server is disabled if empty")
 	flag.StringVar(&cfg.FileBackuperPath, "f", "/tmp/devops-metrics-db.json", "backup file path")
 	flag.BoolVar(&cfg.Backup.DoRestore, "r", true, "restore from backup file on server start")
 	flag.DurationVar(&cfg.Backup.Interval, "i", 300*time.Second, "backup/store interval")
 	flag.StringVar(&cfg.HashKey, "k", "", "Hash key for verifying incoming requests' hash-sums")
 	flag.StringVar(&cfg.Database.DSN, "d", "", "Database address, disables file backups if used")
+	flag.StringVar(&cfg.Redis.Address, "redis", "", "Redis address, disables file backups if used")
+	flag.StringVar(&cfg.SQLite.Path, "sqlite", "", "SQLite database file, disables file backups if used")
+	flag.StringVar(&cfg.WAL.Dir, "wal-dir", "", "Write-ahead log directory, disables file backups if used")
+	flag.StringVar(&cfg.AdminToken, "admin-token", "", "Token for deleting metrics and resetting counters")
+	flag.StringVar(&cfg.TemplatesDir, "templates-dir", "", "Directory of HTML templates overriding embedded ones")
+	flag.BoolVar(&cfg.TemplatesReload, "templates-reload", false, "Reload HTML templates on every render (development)")
+	flag.DurationVar(&cfg.Retention.MetricTTL, "metric-ttl", 0, "Metrics not updated within TTL are deleted, 0 disables")
 
 	parseLoggerConfigFlags(&cfg.Logger)
 
@@ -12,19 +12,25 @@ import (
 type Agent struct {
 	collectInterval time.Duration
 	exportInterval  time.Duration
+	// labels are static labels attached to every collected metric
+	labels domain.Labels
 
 	collectors []MetricsCollector
 	exporters  []MetricsExporter
 	bufferer   MetricsBufferer
+	spooler    MetricsSpooler
 }
 
-func NewAgent(cfg *config.AgentConfig, bufferer MetricsBufferer) *Agent {
+// NewAgent creates new agent, spooler is optional (nil) and disables spooling to long-term storage if not provided
+func NewAgent(cfg *config.AgentConfig, bufferer MetricsBufferer, spooler MetricsSpooler) *Agent {
 	return &Agent{
 		collectInterval: cfg.CollectInterval,
 		exportInterval:  cfg.ExportInterval,
+		labels:          domain.Labels(cfg.Labels).Copy(),
 		collectors:      []MetricsCollector{},
 		exporters:       []MetricsExporter{},
 		bufferer:        bufferer,
+		spooler:         spooler,
 	}
 }
 
@@ -34,6 +40,7 @@ func (a *Agent) AddCollector(col MetricsCollector) {
 
 func (a *Agent) AddExporter(exp MetricsExporter) {
 	a.exporters = append(a.exporters, exp)
+	a.bufferer.Register(exp.Name())
 }
 
 func (a *Agent) StartCollecting(ctx context.Context) {
@@ -63,14 +70,10 @@ func (a *Agent) StartExporting(ctx context.Context) {
 			exportCycles++
 			logger.New(ctx).Debugf("[agent] exporting cycle %d", exportCycles)
 
-			// Get current bufferer snapshot
-			bufferSnapshot := a.bufferer.Retrieve()
-			// Send metrics to exporters
+			// Send metrics to exporters, each exporter gets its own buffer snapshot
 			for _, exp := range a.exporters {
-				go a.exportMetrics(ctx, exp, bufferSnapshot)
+				go a.exportMetrics(ctx, exp)
 			}
-			// Flush the bufferer after exporting
-			a.bufferer.Flush()
 		case <-ctx.Done():
 			logger.New(ctx).Debugf("[agent] context cancelled, exporting stopped")
 			return
@@ -78,6 +81,8 @@ func (a *Agent) StartExporting(ctx context.Context) {
 	}
 }
 
+// Stop waits for running collectors/exporters until ctx is done, so it must not be the (cancelled) context
+// the agent was started with, then spools what has not been exported
 func (a *Agent) Stop(ctx context.Context) {
 	// Wait for collectors to finish their job
 	// (= try to reserve all available worker threads)
@@ -93,6 +98,21 @@ func (a *Agent) Stop(ctx context.Context) {
 			exp.Reserve(ctx)
 		}
 	}
+	// Spool whatever has not been exported yet, so that it survives agent restart
+	if a.spooler != nil {
+		for _, exp := range a.exporters {
+			snapshot := a.bufferer.Retrieve(exp.Name())
+			if len(snapshot) == 0 {
+				continue
+			}
+			err := a.spooler.Spool(exp.Name(), snapshot)
+			if err != nil {
+				logger.New(ctx).Errorf("[%s exporter] error when spooling metrics on stop: %s", exp.Name(), err.Error())
+				continue
+			}
+			logger.New(ctx).Infof("[%s exporter] %d metrics spooled on stop", exp.Name(), len(snapshot))
+		}
+	}
 }
 
 func (a *Agent) collectMetrics(ctx context.Context, col MetricsCollector) {
@@ -111,25 +131,76 @@ func (a *Agent) collectMetrics(ctx context.Context, col MetricsCollector) {
 	if err != nil {
 		logger.New(ctx).Errorf("[%s collector] error when collecting metrics: %s", col.Name(), err.Error())
 	}
+	if len(a.labels) > 0 {
+		for i := range snapshot {
+			snapshot[i].Labels = snapshot[i].Labels.Merge(a.labels)
+		}
+	}
 	a.bufferer.Buffer(snapshot)
 	logger.New(ctx).Debugf("[%s collector] finish collecting metrics", col.Name())
 }
 
-func (a *Agent) exportMetrics(ctx context.Context, exp MetricsExporter, metrics []domain.Metric) {
+func (a *Agent) exportMetrics(ctx context.Context, exp MetricsExporter) {
 	reserveCtx, cancel := context.WithTimeout(ctx, a.exportInterval)
 	defer cancel()
 
 	// Try to reserve the exporter
 	ok := exp.Reserve(reserveCtx)
 	if !ok {
+		// Metrics stay in the buffer until the exporter is available
 		logger.New(ctx).Errorf("[%s exporter] timeout when exporting metrics: exporter still busy", exp.Name())
+		return
 	}
 	defer exp.Release(ctx)
 
+	// Take the snapshot only after reserving, so that it is as fresh as possible
+	snapshot := a.bufferer.Retrieve(exp.Name())
+
+	// Export (including any retries) must not spill over into the next export cycle
+	exportCtx, exportCancel := context.WithTimeout(ctx, a.exportInterval)
+	defer exportCancel()
+
 	logger.New(ctx).Debugf("[%s exporter] start exporting metrics", exp.Name())
-	err := exp.Export(ctx, metrics)
+
+	// Spooled snapshots are older, so they go first
+	if a.spooler != nil {
+		err := a.spooler.Replay(exp.Name(), func(spooled []domain.Metric) error {
+			return exp.Export(exportCtx, spooled)
+		})
+		if err != nil {
+			logger.New(ctx).Errorf("[%s exporter] error when replaying spooled metrics: %s", exp.Name(), err.Error())
+			a.unacknowledged(ctx, exp, snapshot)
+			return
+		}
+	}
+
+	if len(snapshot) == 0 {
+		logger.New(ctx).Debugf("[%s exporter] nothing to export", exp.Name())
+		return
+	}
+	err := exp.Export(exportCtx, snapshot)
 	if err != nil {
 		logger.New(ctx).Errorf("[%s exporter] error when exporting metrics: %s", exp.Name(), err.Error())
+		a.unacknowledged(ctx, exp, snapshot)
+		return
 	}
 	logger.New(ctx).Debugf("[%s exporter] finish exporting metrics", exp.Name())
 }
+
+// unacknowledged keeps snapshot that failed to export, so that it is retried on one of the next cycles
+func (a *Agent) unacknowledged(ctx context.Context, exp MetricsExporter, snapshot []domain.Metric) {
+	if len(snapshot) == 0 {
+		return
+	}
+	if a.spooler != nil {
+		err := a.spooler.Spool(exp.Name(), snapshot)
+		if err == nil {
+			logger.New(ctx).Infof("[%s exporter] %d metrics spooled", exp.Name(), len(snapshot))
+			return
+		}
+		logger.New(ctx).Errorf("[%s exporter] error when spooling metrics: %s", exp.Name(), err.Error())
+	}
+	// Merge the snapshot back into the buffer
+	a.bufferer.Requeue(exp.Name(), snapshot)
+	logger.New(ctx).Infof("[%s exporter] %d metrics requeued", exp.Name(), len(snapshot))
+}
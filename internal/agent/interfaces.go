@@ -34,8 +34,23 @@ type MetricsExporter interface {
 }
 
 // MetricsBufferer can buffer metrics in temporary storage before exporting
+// Each consumer (exporter) gets its own view of the buffer and acknowledges its snapshots separately
 type MetricsBufferer interface {
+	// Register adds a new consumer, metrics are buffered for it starting from this point
+	Register(consumer string)
+	// Buffer merges metrics into the buffer of every registered consumer
 	Buffer([]domain.Metric)
-	Retrieve() []domain.Metric
-	Flush()
+	// Retrieve atomically swaps consumer's buffer with an empty one and returns the snapshot
+	Retrieve(consumer string) []domain.Metric
+	// Requeue merges a snapshot that was not acknowledged (e.g. failed to export) back into consumer's buffer
+	Requeue(consumer string, snapshot []domain.Metric)
+}
+
+// MetricsSpooler can persist snapshots that failed to export in long-term storage, to be replayed later
+type MetricsSpooler interface {
+	// Spool appends consumer's snapshot to the end of the spool
+	Spool(consumer string, snapshot []domain.Metric) error
+	// Replay passes consumer's spooled snapshots to fn in order, oldest first
+	// Snapshot is removed from the spool only when fn succeeds, replay stops on the first error
+	Replay(consumer string, fn func(snapshot []domain.Metric) error) error
 }
@@ -18,6 +18,11 @@ func (h *HTTPHandler) HTML(ctx context.Context, w http.ResponseWriter, body []by
 	h.write(ctx, w, http.StatusOK, body, "text/html; charset=utf-8")
 }
 
+// Raw writes body with arbitrary content type, e.g. for formats that have no dedicated helper
+func (h *HTTPHandler) Raw(ctx context.Context, w http.ResponseWriter, status int, body []byte, contentType string) {
+	h.write(ctx, w, status, body, contentType)
+}
+
 func (h *HTTPHandler) JSON(ctx context.Context, w http.ResponseWriter, status int, data interface{}) {
 	body, err := json.Marshal(data)
 	if err != nil {
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+)
+
+// RequireToken only lets through requests with 'Authorization: Bearer <token>' header
+// If token is empty, all requests are forbidden, so that endpoints are not exposed by accident
+func RequireToken(token string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				logger.New(r.Context()).Errorf("[auth] admin token is not configured, request is forbidden")
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			header := r.Header.Get("Authorization")
+			provided := strings.TrimPrefix(header, "Bearer ")
+			if provided == header || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				logger.New(r.Context()).Errorf("[auth] request has invalid token")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
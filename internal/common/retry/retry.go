@@ -0,0 +1,171 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	pkgErrors "github.com/pkg/errors"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+)
+
+// Kinds of network errors, which can be configured as retryable
+const (
+	ErrorKindTimeout           = "timeout"
+	ErrorKindConnectionRefused = "connection-refused"
+	ErrorKindConnectionReset   = "connection-reset"
+	ErrorKindDNS               = "dns"
+)
+
+var ErrNoTimeLeft = errors.New("no time left for another attempt")
+
+// Operation is a single attempt of some work, it reports whether its error is worth retrying
+type Operation func(ctx context.Context, attempt int) (retryable bool, err error)
+
+type Policy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	jitter      float64
+	statuses    map[int]bool
+	errorKinds  map[string]bool
+
+	generator *rand.Rand
+	mutex     *sync.Mutex
+}
+
+func NewPolicy(cfg config.RetryConfig) *Policy {
+	p := &Policy{
+		maxAttempts: cfg.MaxAttempts,
+		baseDelay:   cfg.BaseDelay,
+		maxDelay:    cfg.MaxDelay,
+		jitter:      cfg.Jitter,
+		statuses:    make(map[int]bool),
+		errorKinds:  make(map[string]bool),
+		generator:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		mutex:       &sync.Mutex{},
+	}
+	if p.maxAttempts < 1 {
+		p.maxAttempts = 1
+	}
+	if p.jitter < 0 {
+		p.jitter = 0
+	}
+	if p.jitter > 1 {
+		p.jitter = 1
+	}
+	for _, status := range cfg.RetryableStatuses {
+		p.statuses[status] = true
+	}
+	for _, kind := range cfg.RetryableErrors {
+		p.errorKinds[kind] = true
+	}
+	return p
+}
+
+// Do runs op until it succeeds, returns a non-retryable error or attempts run out
+// Delays between attempts grow exponentially, but never past the context deadline
+func (p *Policy) Do(ctx context.Context, name string, op Operation) error {
+	var err error
+	var retryable bool
+
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		retryable, err = op(ctx, attempt)
+		if err == nil {
+			if attempt > 1 {
+				logger.New(ctx).Infof("[%s] attempt %d/%d succeeded", name, attempt, p.maxAttempts)
+			}
+			return nil
+		}
+		if !retryable || attempt == p.maxAttempts {
+			logger.New(ctx).Errorf("[%s] attempt %d/%d failed: %s", name, attempt, p.maxAttempts, err.Error())
+			break
+		}
+
+		delay := p.Delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			logger.New(ctx).Errorf("[%s] attempt %d/%d failed: %s, %s",
+				name, attempt, p.maxAttempts, err.Error(), ErrNoTimeLeft.Error())
+			break
+		}
+		logger.New(ctx).Errorf("[%s] attempt %d/%d failed: %s, retrying in %s",
+			name, attempt, p.maxAttempts, err.Error(), delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return pkgErrors.Wrapf(err, "[%s] context cancelled after %d attempts", name, attempt)
+		}
+	}
+	return err
+}
+
+// Delay returns exponential backoff delay after given attempt, spread by jitter and capped by max delay
+// (delay is not capped if max delay is 0, but it never overflows)
+func (p *Policy) Delay(attempt int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < attempt && (p.maxDelay <= 0 || delay < p.maxDelay) && delay < math.MaxInt64/2; i++ {
+		delay *= 2
+	}
+	if p.maxDelay > 0 && delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	if p.jitter > 0 {
+		p.mutex.Lock()
+		spread := (p.generator.Float64()*2 - 1) * p.jitter
+		p.mutex.Unlock()
+		// Jitter may push delay over the cap (or int64 range), so it is clamped once again
+		jittered := float64(delay) * (1 + spread)
+		if jittered >= math.MaxInt64 {
+			delay = math.MaxInt64
+		} else {
+			delay = time.Duration(jittered)
+		}
+		if p.maxDelay > 0 && delay > p.maxDelay {
+			delay = p.maxDelay
+		}
+	}
+	return delay
+}
+
+// IsRetryableStatus checks if (HTTP) status code is configured as retryable
+func (p *Policy) IsRetryableStatus(status int) bool {
+	return p.statuses[status]
+}
+
+// IsRetryableError checks if err is a network error of a kind that is configured as retryable
+func (p *Policy) IsRetryableError(err error) bool {
+	kind := classifyError(err)
+	return kind != "" && p.errorKinds[kind]
+}
+
+func classifyError(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrorKindDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorKindConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.EOF):
+		return ErrorKindConnectionReset
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorKindTimeout
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,60 @@
+package retry
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDelay time.Duration
+		attempt  int
+		want     time.Duration
+	}{
+		{name: "first attempt", maxDelay: time.Second, attempt: 1, want: 100 * time.Millisecond},
+		{name: "doubled", maxDelay: time.Second, attempt: 3, want: 400 * time.Millisecond},
+		{name: "capped", maxDelay: time.Second, attempt: 10, want: time.Second},
+		{name: "no cap", maxDelay: 0, attempt: 10, want: 51200 * time.Millisecond},
+		{name: "no cap, no overflow", maxDelay: 0, attempt: 100, want: 100 * time.Millisecond << 36},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPolicy(config.RetryConfig{MaxAttempts: 100, BaseDelay: 100 * time.Millisecond, MaxDelay: tt.maxDelay})
+			assert.Equal(t, tt.want, p.Delay(tt.attempt))
+		})
+	}
+}
+
+func TestPolicyDelayWithJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxDelay time.Duration
+		attempt  int
+		min, max time.Duration
+	}{
+		{name: "spread", maxDelay: time.Second, attempt: 3, min: 200 * time.Millisecond, max: 600 * time.Millisecond},
+		{name: "capped after jitter", maxDelay: time.Second, attempt: 10, min: 500 * time.Millisecond, max: time.Second},
+		{name: "no cap, no overflow", maxDelay: 0, attempt: 100, min: 100 * time.Millisecond << 35, max: math.MaxInt64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPolicy(config.RetryConfig{
+				MaxAttempts: 100,
+				BaseDelay:   100 * time.Millisecond,
+				MaxDelay:    tt.maxDelay,
+				Jitter:      0.5,
+			})
+			for i := 0; i < 100; i++ {
+				delay := p.Delay(tt.attempt)
+				assert.GreaterOrEqual(t, delay, tt.min)
+				assert.LessOrEqual(t, delay, tt.max)
+			}
+		})
+	}
+}
@@ -6,44 +6,149 @@ import (
 	"html/template"
 	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
 )
 
+// Templates in these directories are shared by all pages (e.g. base layout, reusable fragments),
+// all other templates are pages
+const (
+	layoutsDir  = "layouts"
+	partialsDir = "partials"
+)
+
+// HTMLTemplateParser renders pages, every page is a separate template set with layouts and partials,
+// so that pages can define the same blocks (e.g. 'content') of the layout differently
+// Pages are looked up by path, e.g. 'dashboard.html'
 type HTMLTemplateParser struct {
-	tpl *template.Template
+	defaults    fs.FS
+	overrideDir string
+	reload      bool
+	pages       map[string]*template.Template
 }
 
-func NewHTMLTemplateParser(templatesDir string) *HTMLTemplateParser {
-	if _, err := os.Stat(templatesDir); os.IsNotExist(err) {
-		logger.New(context.TODO()).Fatalf("[html template parser] directory '%s' does not exist", templatesDir)
-	}
-
-	var tpl *template.Template
-	err := filepath.WalkDir(templatesDir, func(path string, d fs.DirEntry, err error) error {
-		if !d.IsDir() {
-			tpl, err = template.ParseFiles(path)
+// NewHTMLTemplateParser parses default templates (embedded into the binary), files in override directory (if set)
+// replace default files with the same path, or add new ones
+// If reload is set, templates are parsed again on every render, so that changes of override directory
+// are picked up without restart (meant for development)
+func NewHTMLTemplateParser(
+	ctx context.Context,
+	defaults fs.FS,
+	overrideDir string,
+	reload bool,
+) (*HTMLTemplateParser, error) {
+	if overrideDir != "" {
+		if _, err := os.Stat(overrideDir); err != nil {
+			logger.New(ctx).Errorf("[html template parser] override directory is not used: %s", err.Error())
+			overrideDir = ""
 		}
-		return err
-	})
+	}
+	p := &HTMLTemplateParser{
+		defaults:    defaults,
+		overrideDir: overrideDir,
+		reload:      reload,
+	}
+	pages, err := p.load()
 	if err != nil {
-		logger.New(context.TODO()).Fatalf("[html template parser] error when parsing templates: %s", err.Error())
+		return nil, err
 	}
+	p.pages = pages
+	logger.New(ctx).Infof("[html template parser] parsed %d pages, override directory = '%s', reload = %t",
+		len(pages), overrideDir, reload)
+	return p, nil
+}
 
-	return &HTMLTemplateParser{
-		tpl: tpl,
+func (p *HTMLTemplateParser) Parse(templateName string, data any) ([]byte, error) {
+	pages, err := p.current()
+	if err != nil {
+		return nil, err
+	}
+	tpl, ok := pages[templateName]
+	if !ok {
+		return nil, errors.Errorf("[html template parser] page '%s' is not found", templateName)
 	}
-}
 
-func (r *HTMLTemplateParser) Parse(templateName string, data any) ([]byte, error) {
 	var buffer bytes.Buffer
-
-	err := r.tpl.ExecuteTemplate(&buffer, templateName, data)
-	if err != nil {
+	if err = tpl.ExecuteTemplate(&buffer, templateName, data); err != nil {
 		return nil, errors.Wrapf(err, "[html template parser]")
 	}
 	return buffer.Bytes(), nil
 }
+
+// current returns parsed pages, they are parsed again if reload is enabled,
+// so that template errors after changes are shown on the next render
+func (p *HTMLTemplateParser) current() (map[string]*template.Template, error) {
+	if p.reload {
+		return p.load()
+	}
+	return p.pages, nil
+}
+
+// load parses all templates, each page is parsed into a copy of shared templates (layouts and partials)
+func (p *HTMLTemplateParser) load() (map[string]*template.Template, error) {
+	files := make(map[string][]byte)
+	if err := readTemplates(p.defaults, files); err != nil {
+		return nil, errors.Wrap(err, "[html template parser] error when reading default templates")
+	}
+	if p.overrideDir != "" {
+		if err := readTemplates(os.DirFS(p.overrideDir), files); err != nil {
+			return nil, errors.Wrapf(err, "[html template parser] error when reading templates of '%s'", p.overrideDir)
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	shared := template.New("")
+	pageNames := make([]string, 0)
+	for _, name := range names {
+		if !isShared(name) {
+			pageNames = append(pageNames, name)
+			continue
+		}
+		if _, err := shared.New(name).Parse(string(files[name])); err != nil {
+			return nil, errors.Wrapf(err, "[html template parser] error when parsing '%s'", name)
+		}
+	}
+
+	pages := make(map[string]*template.Template, len(pageNames))
+	for _, name := range pageNames {
+		page, err := shared.Clone()
+		if err != nil {
+			return nil, errors.Wrap(err, "[html template parser] error when copying shared templates")
+		}
+		if _, err = page.New(name).Parse(string(files[name])); err != nil {
+			return nil, errors.Wrapf(err, "[html template parser] error when parsing '%s'", name)
+		}
+		pages[name] = page
+	}
+	return pages, nil
+}
+
+// readTemplates reads all '.html' files of fsys into files by their path, replacing files with the same path
+func readTemplates(fsys fs.FS, files map[string][]byte) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) != ".html" {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		files[name] = content
+		return nil
+	})
+}
+
+func isShared(name string) bool {
+	dir, _, _ := strings.Cut(name, "/")
+	return dir == layoutsDir || dir == partialsDir
+}
@@ -0,0 +1,113 @@
+package templating
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var defaults = fstest.MapFS{
+	"layouts/base.html": {Data: []byte(
+		`{{ define "base" }}<title>{{ template "title" . }}</title>{{ template "content" . }}{{ end }}`)},
+	"partials/name.html": {Data: []byte(`{{ define "name" }}<b>{{ . }}</b>{{ end }}`)},
+	"first.html": {Data: []byte(
+		`{{ template "base" . }}{{ define "title" }}First{{ end }}{{ define "content" }}{{ template "name" .Name }}{{ end }}`)},
+	"second.html": {Data: []byte(
+		`{{ template "base" . }}{{ define "title" }}Second{{ end }}{{ define "content" }}{{ .Name }}{{ end }}`)},
+	"readme.txt": {Data: []byte(`{{ not a template`)},
+}
+
+type page struct {
+	Name string
+}
+
+func TestHTMLTemplateParser(t *testing.T) {
+	parser, err := NewHTMLTemplateParser(context.Background(), defaults, "", false)
+	require.NoError(t, err)
+
+	// Pages define blocks of the same layout independently
+	body, err := parser.Parse("first.html", page{Name: "<x>"})
+	require.NoError(t, err)
+	assert.Equal(t, "<title>First</title><b>&lt;x&gt;</b>", string(body))
+
+	body, err = parser.Parse("second.html", page{Name: "y"})
+	require.NoError(t, err)
+	assert.Equal(t, "<title>Second</title>y", string(body))
+
+	_, err = parser.Parse("missing.html", page{})
+	assert.Error(t, err)
+	_, err = parser.Parse("layouts/base.html", page{})
+	assert.Error(t, err)
+}
+
+func TestHTMLTemplateParserOverride(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "partials", "name.html"), `{{ define "name" }}<i>{{ . }}</i>{{ end }}`)
+	writeFile(t, filepath.Join(dir, "third.html"), `{{ template "base" . }}{{ define "title" }}Third{{ end }}`+
+		`{{ define "content" }}{{ template "name" .Name }}{{ end }}`)
+
+	parser, err := NewHTMLTemplateParser(context.Background(), defaults, dir, false)
+	require.NoError(t, err)
+
+	body, err := parser.Parse("first.html", page{Name: "x"})
+	require.NoError(t, err)
+	assert.Equal(t, "<title>First</title><i>x</i>", string(body))
+
+	body, err = parser.Parse("third.html", page{Name: "z"})
+	require.NoError(t, err)
+	assert.Equal(t, "<title>Third</title><i>z</i>", string(body))
+
+	// Changes are not picked up without reload
+	writeFile(t, filepath.Join(dir, "partials", "name.html"), `{{ define "name" }}<u>{{ . }}</u>{{ end }}`)
+	body, err = parser.Parse("first.html", page{Name: "x"})
+	require.NoError(t, err)
+	assert.Equal(t, "<title>First</title><i>x</i>", string(body))
+}
+
+func TestHTMLTemplateParserReload(t *testing.T) {
+	dir := t.TempDir()
+	parser, err := NewHTMLTemplateParser(context.Background(), defaults, dir, true)
+	require.NoError(t, err)
+
+	body, err := parser.Parse("first.html", page{Name: "x"})
+	require.NoError(t, err)
+	assert.Equal(t, "<title>First</title><b>x</b>", string(body))
+
+	writeFile(t, filepath.Join(dir, "partials", "name.html"), `{{ define "name" }}<i>{{ . }}</i>{{ end }}`)
+	body, err = parser.Parse("first.html", page{Name: "x"})
+	require.NoError(t, err)
+	assert.Equal(t, "<title>First</title><i>x</i>", string(body))
+
+	// Broken template is reported on render, it is rendered again once fixed
+	writeFile(t, filepath.Join(dir, "partials", "name.html"), `{{ define "name" }}<i>{{ . }</i>{{ end }}`)
+	_, err = parser.Parse("first.html", page{Name: "x"})
+	assert.Error(t, err)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "partials", "name.html")))
+	body, err = parser.Parse("first.html", page{Name: "x"})
+	require.NoError(t, err)
+	assert.Equal(t, "<title>First</title><b>x</b>", string(body))
+}
+
+func TestHTMLTemplateParserErrors(t *testing.T) {
+	// Missing override directory is not fatal, default templates are used
+	parser, err := NewHTMLTemplateParser(context.Background(), defaults, filepath.Join(t.TempDir(), "missing"), false)
+	require.NoError(t, err)
+	body, err := parser.Parse("second.html", page{Name: "y"})
+	require.NoError(t, err)
+	assert.Equal(t, "<title>Second</title>y", string(body))
+
+	broken := fstest.MapFS{"page.html": {Data: []byte(`{{ if }}`)}}
+	_, err = NewHTMLTemplateParser(context.Background(), broken, "", false)
+	assert.Error(t, err)
+}
+
+func writeFile(t *testing.T, name, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+}
@@ -1,10 +1,16 @@
 package backup
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"io"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -12,73 +18,252 @@ import (
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
 )
 
+// FormatVersion is the version of backup envelope written by fileBackuper
+const FormatVersion = 1
+
+var (
+	ErrClosed             = errors.New("[file backuper] backuper is closed")
+	ErrUnsupportedVersion = errors.New("[file backuper] unsupported backup format version")
+	ErrChecksumMismatch   = errors.New("[file backuper] backup checksum mismatch")
+	ErrCountMismatch      = errors.New("[file backuper] backup metrics count mismatch")
+)
+
+// envelope wraps backed up metrics with metadata, so that corrupt backups are detected on restore
+type envelope struct {
+	Version   int             `json:"version"`
+	Timestamp time.Time       `json:"timestamp"`
+	Checksum  string          `json:"checksum"`
+	Count     int             `json:"count"`
+	Metrics   json.RawMessage `json:"metrics"`
+}
+
+// fileBackuper keeps the last N generations of backups: the latest one at filename, older ones at filename.1, .2, ...
+// Backups are written to a temp file first and renamed over the latest one, which is linked as filename.1 beforehand,
+// so that there is a complete backup at filename at any moment, even if the process crashes in between
 type fileBackuper struct {
-	file    *os.File
-	encoder *json.Encoder
-	decoder *json.Decoder
+	ctx         context.Context
+	filename    string
+	generations int
+	closed      bool
+	mutex       *sync.Mutex
 }
 
-func NewFileBackuper(ctx context.Context, filename string) (*fileBackuper, error) {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0o664)
-	if err != nil {
-		return nil, err
+func NewFileBackuper(ctx context.Context, filename string, generations int) (*fileBackuper, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return nil, errors.Wrap(err, "[file backuper] error when creating backup directory")
+	}
+	if generations < 1 {
+		generations = 1
 	}
 	fb := &fileBackuper{
-		file:    file,
-		encoder: json.NewEncoder(file),
-		decoder: json.NewDecoder(file),
+		ctx:         ctx,
+		filename:    filename,
+		generations: generations,
+		mutex:       &sync.Mutex{},
 	}
-	go fb.waitAndClose(ctx)
+	logger.New(ctx).Infof("[file backuper] backing up to %s, keeping %d generations", filename, generations)
 	return fb, nil
 }
 
 func (b *fileBackuper) Backup(metrics []domain.Metric) error {
-	// Clean up file
-	err := b.file.Truncate(0)
-	if err != nil {
-		return errors.Wrap(err, "[file backuper] error when truncating file before doing backup")
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return ErrClosed
 	}
-	// Reset internal file offset
-	_, err = b.file.Seek(0, 0)
+
+	data, err := encodeBackup(metrics, time.Now())
 	if err != nil {
-		return errors.Wrap(err, "[file backuper] error when truncating file before doing backup")
+		return err
+	}
+
+	// Write backup to temp file and sync it to disk, live file is not touched yet
+	tmp := b.filename + ".tmp"
+	if err = writeAndSync(tmp, data); err != nil {
+		return err
+	}
+
+	// Shift older generations (the latest one stays in place) and atomically replace the latest one with temp file
+	if err = b.rotate(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, b.filename); err != nil {
+		return errors.Wrap(err, "[file backuper] error when moving backup into place")
 	}
+	return syncDir(filepath.Dir(b.filename))
+}
+
+// Restore reads metrics from the newest valid generation, older unversioned backups (plain JSON array) are supported
+func (b *fileBackuper) Restore() ([]domain.Metric, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var lastErr error
+	for generation := 0; generation < b.generations; generation++ {
+		path := b.generationPath(generation)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "[file backuper] error when reading %s", path)
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			// Empty file, nothing to restore from
+			continue
+		}
+
+		metrics, err := decodeBackup(data)
+		if err != nil {
+			logger.New(b.ctx).Errorf("[file backuper] backup %s is corrupt, falling back to older one: %s",
+				path, err.Error())
+			lastErr = errors.Wrapf(err, "[file backuper] error when restoring from %s", path)
+			continue
+		}
+		if generation > 0 {
+			logger.New(b.ctx).Infof("[file backuper] restored from older backup %s", path)
+		}
+		return metrics, nil
+	}
+	// Either there are no backups (nothing to restore from), or all of them are corrupt
+	return nil, lastErr
+}
+
+// Close prevents further backups, e.g. after the final backup is taken on shutdown
+func (b *fileBackuper) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	return nil
+}
+
+// generationPath returns path of backup generation, 0 is the latest one
+func (b *fileBackuper) generationPath(generation int) string {
+	if generation == 0 {
+		return b.filename
+	}
+	return fmt.Sprintf("%s.%d", b.filename, generation)
+}
 
-	// Write metrics to file
-	err = b.encoder.Encode(metrics)
+// rotate shifts each generation one step older, the oldest one is overwritten
+// The latest one is not moved, it is hard-linked (or copied, if links are not supported) as generation 1
+func (b *fileBackuper) rotate() error {
+	if b.generations < 2 {
+		return nil
+	}
+	for generation := b.generations - 1; generation > 1; generation-- {
+		err := os.Rename(b.generationPath(generation-1), b.generationPath(generation))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "[file backuper] error when rotating backups")
+		}
+	}
+
+	previous := b.generationPath(1)
+	if err := os.Remove(previous); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "[file backuper] error when rotating backups")
+	}
+	err := os.Link(b.filename, previous)
+	if os.IsNotExist(err) {
+		// No backups yet
+		return nil
+	}
 	if err != nil {
-		return errors.Wrap(err, "[file backuper] error when backing up metrics")
+		data, err := os.ReadFile(b.filename)
+		if err != nil {
+			return errors.Wrap(err, "[file backuper] error when copying latest backup")
+		}
+		return writeAndSync(previous, data)
 	}
 	return nil
 }
 
-func (b *fileBackuper) Restore() ([]domain.Metric, error) {
-	// Check if file is empty
-	stat, err := b.file.Stat()
+func encodeBackup(metrics []domain.Metric, timestamp time.Time) ([]byte, error) {
+	if metrics == nil {
+		metrics = make([]domain.Metric, 0)
+	}
+	payload, err := json.Marshal(metrics)
 	if err != nil {
-		return nil, errors.Wrap(err, "[file backuper] error when reading file stat")
+		return nil, errors.Wrap(err, "[file backuper] error when encoding metrics")
 	}
-	size := stat.Size()
-	if size == 0 {
-		// File is empty, nothing to restore from
-		return nil, nil
+	data, err := json.Marshal(envelope{
+		Version:   FormatVersion,
+		Timestamp: timestamp,
+		Checksum:  checksum(payload),
+		Count:     len(metrics),
+		Metrics:   payload,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "[file backuper] error when encoding backup")
 	}
+	return data, nil
+}
 
-	// Try to read JSON from file
+func decodeBackup(data []byte) ([]domain.Metric, error) {
 	metrics := make([]domain.Metric, 0)
-	err = b.decoder.Decode(&metrics)
-	if err != nil && !errors.Is(err, io.EOF) { // Ignore io.EOF (empty file)
-		return nil, errors.Wrap(err, "[file backuper] error when restoring metrics")
+
+	// Backups written before envelope was introduced are plain JSON arrays
+	if data = bytes.TrimSpace(data); data[0] == '[' {
+		if err := json.Unmarshal(data, &metrics); err != nil {
+			return nil, errors.Wrap(err, "[file backuper] error when decoding unversioned backup")
+		}
+		return metrics, nil
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, errors.Wrap(err, "[file backuper] error when decoding backup")
+	}
+	if env.Version != FormatVersion {
+		return nil, errors.Wrapf(ErrUnsupportedVersion, "version %d", env.Version)
+	}
+	if checksum(env.Metrics) != env.Checksum {
+		return nil, ErrChecksumMismatch
+	}
+	if err := json.Unmarshal(env.Metrics, &metrics); err != nil {
+		return nil, errors.Wrap(err, "[file backuper] error when decoding metrics")
+	}
+	if len(metrics) != env.Count {
+		return nil, ErrCountMismatch
 	}
 	return metrics, nil
 }
 
-func (b *fileBackuper) waitAndClose(ctx context.Context) {
-	<-ctx.Done()
-	logger.New(ctx).Debugf("[file backuper] context cancelled, closing file")
+func checksum(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func writeAndSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o664)
+	if err != nil {
+		return errors.Wrap(err, "[file backuper] error when creating temp file")
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return errors.Wrap(err, "[file backuper] error when writing backup")
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return errors.Wrap(err, "[file backuper] error when syncing backup")
+	}
+	if err = file.Close(); err != nil {
+		return errors.Wrap(err, "[file backuper] error when closing temp file")
+	}
+	return nil
+}
 
-	err := b.file.Close()
+// syncDir makes renames in directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
-		logger.New(ctx).Errorf("[file backuper] error when closing file: %s", err.Error())
+		return errors.Wrap(err, "[file backuper] error when opening backup directory")
 	}
+	defer d.Close()
+
+	if err = d.Sync(); err != nil {
+		return errors.Wrap(err, "[file backuper] error when syncing backup directory")
+	}
+	return nil
 }
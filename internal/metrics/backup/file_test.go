@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+func newTestBackuper(t *testing.T, generations int) (*fileBackuper, string) {
+	filename := filepath.Join(t.TempDir(), "backup.json")
+	b, err := NewFileBackuper(context.Background(), filename, generations)
+	require.NoError(t, err)
+	return b, filename
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	b, _ := newTestBackuper(t, 3)
+
+	restored, err := b.Restore()
+	require.NoError(t, err)
+	assert.Empty(t, restored)
+
+	metrics := []domain.Metric{
+		domain.NewCounter(domain.PollCount, 5),
+		domain.NewGauge(domain.Alloc, 10.5),
+	}
+	require.NoError(t, b.Backup(metrics))
+
+	restored, err = b.Restore()
+	require.NoError(t, err)
+	assert.Equal(t, metrics, restored)
+}
+
+func TestBackupRotation(t *testing.T) {
+	b, filename := newTestBackuper(t, 3)
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, b.Backup([]domain.Metric{domain.NewCounter(domain.PollCount, domain.Counter(i))}))
+	}
+
+	assert.FileExists(t, filename)
+	assert.FileExists(t, filename+".1")
+	assert.FileExists(t, filename+".2")
+	assert.NoFileExists(t, filename+".3")
+	assert.NoFileExists(t, filename+".tmp")
+
+	restored, err := b.Restore()
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{domain.NewCounter(domain.PollCount, 5)}, restored)
+}
+
+func TestRotationKeepsLatestBackup(t *testing.T) {
+	b, filename := newTestBackuper(t, 3)
+	metrics := []domain.Metric{domain.NewCounter(domain.PollCount, 1)}
+	require.NoError(t, b.Backup(metrics))
+
+	// Crash after rotation, before the new backup is moved into place
+	require.NoError(t, b.rotate())
+
+	assert.FileExists(t, filename)
+	assert.FileExists(t, filename+".1")
+	restored, err := b.Restore()
+	require.NoError(t, err)
+	assert.Equal(t, metrics, restored)
+
+	// Next backup replaces the latest one, the previous one is kept as generation 1
+	require.NoError(t, b.Backup([]domain.Metric{domain.NewCounter(domain.PollCount, 2)}))
+	data, err := os.ReadFile(filename + ".1")
+	require.NoError(t, err)
+	previous, err := decodeBackup(data)
+	require.NoError(t, err)
+	assert.Equal(t, metrics, previous)
+}
+
+func TestRestoreFallsBackToOlderGeneration(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, filename string)
+	}{
+		{
+			name: "torn write",
+			corrupt: func(t *testing.T, filename string) {
+				data, err := os.ReadFile(filename)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(filename, data[:len(data)/2], 0o664))
+			},
+		},
+		{
+			name: "checksum mismatch",
+			corrupt: func(t *testing.T, filename string) {
+				body := `{"version":1,"checksum":"-","count":1,"metrics":[{"Name":"PollCount","Type":"counter","Counter":9}]}`
+				require.NoError(t, os.WriteFile(filename, []byte(body), 0o664))
+			},
+		},
+		{
+			name: "unsupported version",
+			corrupt: func(t *testing.T, filename string) {
+				require.NoError(t, os.WriteFile(filename, []byte(`{"version":99}`), 0o664))
+			},
+		},
+		{
+			name: "crash between rotation and rename",
+			corrupt: func(t *testing.T, filename string) {
+				require.NoError(t, os.Remove(filename))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, filename := newTestBackuper(t, 3)
+			older := []domain.Metric{domain.NewCounter(domain.PollCount, 1)}
+			require.NoError(t, b.Backup(older))
+			require.NoError(t, b.Backup([]domain.Metric{domain.NewCounter(domain.PollCount, 2)}))
+
+			tt.corrupt(t, filename)
+
+			restored, err := b.Restore()
+			require.NoError(t, err)
+			assert.Equal(t, older, restored)
+		})
+	}
+}
+
+func TestRestoreFailsIfAllGenerationsCorrupt(t *testing.T) {
+	b, filename := newTestBackuper(t, 2)
+	require.NoError(t, os.WriteFile(filename, []byte(`{"version":`), 0o664))
+	require.NoError(t, os.WriteFile(filename+".1", []byte(`[{`), 0o664))
+
+	_, err := b.Restore()
+	assert.Error(t, err)
+}
+
+func TestRestoreUnversionedBackup(t *testing.T) {
+	b, filename := newTestBackuper(t, 3)
+	legacy := `[{"Name":"PollCount","Type":"counter","Counter":7,"Gauge":0},` +
+		`{"Name":"Alloc","Type":"gauge","Counter":0,"Gauge":1.5}]`
+	require.NoError(t, os.WriteFile(filename, []byte(legacy+"\n"), 0o664))
+
+	restored, err := b.Restore()
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 7),
+		domain.NewGauge(domain.Alloc, 1.5),
+	}, restored)
+}
+
+func TestBackupAfterClose(t *testing.T) {
+	b, _ := newTestBackuper(t, 1)
+	require.NoError(t, b.Close())
+	assert.ErrorIs(t, b.Backup(nil), ErrClosed)
+}
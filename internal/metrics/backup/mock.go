@@ -1,12 +1,24 @@
 package backup
 
 import (
+	"sync"
+
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
 )
 
-type Mock struct{}
+// Mock keeps the last backup in memory, along with the number of backups taken
+type Mock struct {
+	Backups int
+	Metrics []domain.Metric
+	mutex   sync.Mutex
+}
 
 func (b *Mock) Backup(metrics []domain.Metric) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.Backups++
+	b.Metrics = metrics
 	return nil
 }
 
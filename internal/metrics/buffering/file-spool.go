@@ -0,0 +1,380 @@
+package buffering
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+const (
+	spoolSegmentExt  = ".seg"
+	spoolCursorFile  = "cursor.json"
+	spoolSegmentName = "%020d" + spoolSegmentExt
+)
+
+var errCorruptRecord = errors.New("[file spool] corrupt record")
+
+// fileSpool is a segmented append-only log of metrics snapshots on disk
+// Each consumer has its own directory with segments and a cursor, pointing to the oldest unreplayed record
+type fileSpool struct {
+	dir         string
+	segmentSize int64
+	maxSize     int64
+	maxAge      time.Duration
+	queues      map[string]*spoolQueue
+	mutex       *sync.Mutex
+}
+
+type spoolQueue struct {
+	dir      string
+	segments []int64
+	cursor   spoolCursor
+	// replayMutex is held for the whole replay, so that the same record is not replayed twice
+	replayMutex *sync.Mutex
+}
+
+type spoolCursor struct {
+	Segment int64 `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+type spoolRecord struct {
+	Timestamp time.Time       `json:"ts"`
+	Metrics   []domain.Metric `json:"metrics"`
+}
+
+func NewFileSpool(ctx context.Context, cfg config.SpoolConfig) (*fileSpool, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "[file spool] error when creating spool directory")
+	}
+	logger.New(ctx).Infof("[file spool] spooling to %s", cfg.Dir)
+
+	return &fileSpool{
+		dir:         cfg.Dir,
+		segmentSize: cfg.SegmentSize,
+		maxSize:     cfg.MaxSize,
+		maxAge:      cfg.MaxAge,
+		queues:      make(map[string]*spoolQueue),
+		mutex:       &sync.Mutex{},
+	}, nil
+}
+
+func (s *fileSpool) Spool(consumer string, snapshot []domain.Metric) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	q, err := s.getQueue(consumer)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(spoolRecord{Timestamp: time.Now(), Metrics: snapshot})
+	if err != nil {
+		return errors.Wrap(err, "[file spool] error when encoding snapshot")
+	}
+	line = append(line, '\n')
+
+	// Roll over to a new segment if the active one is full
+	active := q.segments[len(q.segments)-1]
+	if size := q.segmentSize(active); size > 0 && size+int64(len(line)) > s.segmentSize {
+		active++
+		q.segments = append(q.segments, active)
+	}
+
+	file, err := os.OpenFile(q.segmentPath(active), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "[file spool] error when opening segment")
+	}
+	defer file.Close()
+
+	if _, err = file.Write(line); err != nil {
+		return errors.Wrap(err, "[file spool] error when writing snapshot")
+	}
+	if err = file.Sync(); err != nil {
+		return errors.Wrap(err, "[file spool] error when syncing segment")
+	}
+	return s.enforceCaps(q)
+}
+
+// Replay calls fn without holding the mutex, as it may take long (e.g. exporting with retries),
+// so that other consumers can spool meanwhile, replays of the same consumer are serialized
+func (s *fileSpool) Replay(consumer string, fn func(snapshot []domain.Metric) error) error {
+	s.mutex.Lock()
+	q, err := s.getQueue(consumer)
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	q.replayMutex.Lock()
+	defer q.replayMutex.Unlock()
+
+	for {
+		record, at, size, found, err := s.nextRecord(q)
+		if err != nil || !found {
+			return err
+		}
+		if err = fn(record.Metrics); err != nil {
+			return err
+		}
+		if err = s.advance(q, at, size); err != nil {
+			return err
+		}
+	}
+}
+
+// nextRecord finds the record to replay next (and the cursor pointing to it), skipping corrupt and expired ones,
+// found is false if everything is replayed
+func (s *fileSpool) nextRecord(q *spoolQueue) (spoolRecord, spoolCursor, int64, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for {
+		record, size, found, err := q.readAtCursor()
+		if errors.Is(err, errCorruptRecord) {
+			// Corrupt records cannot be replayed, skip them so that they do not block the spool
+			q.cursor.Offset += size
+			if err = q.saveCursor(); err != nil {
+				return record, q.cursor, 0, false, err
+			}
+			continue
+		}
+		if err != nil {
+			return record, q.cursor, 0, false, err
+		}
+		if !found {
+			if q.cursor.Segment == q.segments[len(q.segments)-1] {
+				// Active segment is fully replayed, nothing else to do
+				return record, q.cursor, 0, false, nil
+			}
+			// Segment is fully replayed, drop it and move on to the next one
+			if err = q.dropOldestSegment(); err != nil {
+				return record, q.cursor, 0, false, err
+			}
+			continue
+		}
+
+		// Expired records are skipped without replaying
+		if s.maxAge > 0 && time.Since(record.Timestamp) > s.maxAge {
+			q.cursor.Offset += size
+			if err = q.saveCursor(); err != nil {
+				return record, q.cursor, 0, false, err
+			}
+			continue
+		}
+		return record, q.cursor, size, true, nil
+	}
+}
+
+// advance moves cursor past the replayed record, unless the record was dropped meanwhile (e.g. by size cap)
+func (s *fileSpool) advance(q *spoolQueue, at spoolCursor, size int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if q.cursor != at {
+		return nil
+	}
+	q.cursor.Offset += size
+	return q.saveCursor()
+}
+
+func (s *fileSpool) getQueue(consumer string) (*spoolQueue, error) {
+	if q, ok := s.queues[consumer]; ok {
+		return q, nil
+	}
+	q, err := loadSpoolQueue(filepath.Join(s.dir, consumer))
+	if err != nil {
+		return nil, err
+	}
+	s.queues[consumer] = q
+	return q, nil
+}
+
+// enforceCaps drops oldest segments until spool fits into size/age caps
+// The active (newest) segment is never dropped
+func (s *fileSpool) enforceCaps(q *spoolQueue) error {
+	for len(q.segments) > 1 {
+		oldest := q.segments[0]
+
+		stat, err := os.Stat(q.segmentPath(oldest))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "[file spool] error when reading segment stat")
+		}
+		expired := err == nil && s.maxAge > 0 && time.Since(stat.ModTime()) > s.maxAge
+		oversized := s.maxSize > 0 && q.totalSize() > s.maxSize
+
+		if !expired && !oversized {
+			return nil
+		}
+		if err = q.dropOldestSegment(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadSpoolQueue(dir string) (*spoolQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "[file spool] error when creating consumer directory")
+	}
+	q := &spoolQueue{dir: dir, segments: make([]int64, 0), replayMutex: &sync.Mutex{}}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "[file spool] error when reading consumer directory")
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolSegmentExt) {
+			continue
+		}
+		id, parseErr := strconv.ParseInt(strings.TrimSuffix(entry.Name(), spoolSegmentExt), 10, 64)
+		if parseErr != nil {
+			continue
+		}
+		q.segments = append(q.segments, id)
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i] < q.segments[j] })
+	if len(q.segments) == 0 {
+		q.segments = append(q.segments, 1)
+	}
+
+	if err = q.loadCursor(); err != nil {
+		return nil, err
+	}
+	if err = q.truncateTornTail(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *spoolQueue) segmentPath(id int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf(spoolSegmentName, id))
+}
+
+func (q *spoolQueue) segmentSize(id int64) int64 {
+	stat, err := os.Stat(q.segmentPath(id))
+	if err != nil {
+		return 0
+	}
+	return stat.Size()
+}
+
+func (q *spoolQueue) totalSize() int64 {
+	var total int64
+	for _, id := range q.segments {
+		total += q.segmentSize(id)
+	}
+	return total
+}
+
+// readAtCursor reads the record the cursor points to, along with its size on disk
+func (q *spoolQueue) readAtCursor() (record spoolRecord, size int64, found bool, err error) {
+	file, err := os.Open(q.segmentPath(q.cursor.Segment))
+	if os.IsNotExist(err) {
+		return record, 0, false, nil
+	}
+	if err != nil {
+		return record, 0, false, errors.Wrap(err, "[file spool] error when opening segment")
+	}
+	defer file.Close()
+
+	if _, err = file.Seek(q.cursor.Offset, io.SeekStart); err != nil {
+		return record, 0, false, errors.Wrap(err, "[file spool] error when seeking segment")
+	}
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if errors.Is(err, io.EOF) {
+		// No complete records after the cursor
+		return record, 0, false, nil
+	}
+	if err != nil {
+		return record, 0, false, errors.Wrap(err, "[file spool] error when reading segment")
+	}
+	if err = json.Unmarshal(line, &record); err != nil {
+		return record, int64(len(line)), true, errCorruptRecord
+	}
+	return record, int64(len(line)), true, nil
+}
+
+func (q *spoolQueue) dropOldestSegment() error {
+	oldest := q.segments[0]
+	if err := os.Remove(q.segmentPath(oldest)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "[file spool] error when removing segment")
+	}
+	q.segments = q.segments[1:]
+
+	if q.cursor.Segment <= oldest {
+		q.cursor = spoolCursor{Segment: q.segments[0], Offset: 0}
+		return q.saveCursor()
+	}
+	return nil
+}
+
+func (q *spoolQueue) loadCursor() error {
+	data, err := os.ReadFile(filepath.Join(q.dir, spoolCursorFile))
+	if os.IsNotExist(err) {
+		q.cursor = spoolCursor{Segment: q.segments[0]}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "[file spool] error when reading cursor")
+	}
+	if err = json.Unmarshal(data, &q.cursor); err != nil {
+		return errors.Wrap(err, "[file spool] error when decoding cursor")
+	}
+	if q.cursor.Segment != q.segments[0] {
+		// Segment the cursor points to was dropped (e.g. crash right after dropping it)
+		q.cursor = spoolCursor{Segment: q.segments[0]}
+	}
+	return nil
+}
+
+func (q *spoolQueue) saveCursor() error {
+	data, err := json.Marshal(q.cursor)
+	if err != nil {
+		return errors.Wrap(err, "[file spool] error when encoding cursor")
+	}
+	// Write to temp file and rename, so that cursor is never half-written
+	tmp := filepath.Join(q.dir, spoolCursorFile+".tmp")
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return errors.Wrap(err, "[file spool] error when writing cursor")
+	}
+	if err = os.Rename(tmp, filepath.Join(q.dir, spoolCursorFile)); err != nil {
+		return errors.Wrap(err, "[file spool] error when writing cursor")
+	}
+	return nil
+}
+
+// truncateTornTail removes a partially written record at the end of the active segment (e.g. after a crash)
+func (q *spoolQueue) truncateTornTail() error {
+	path := q.segmentPath(q.segments[len(q.segments)-1])
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) || len(data) == 0 {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "[file spool] error when reading segment")
+	}
+	if data[len(data)-1] == '\n' {
+		return nil
+	}
+	validSize := int64(strings.LastIndexByte(string(data), '\n') + 1)
+	if err = os.Truncate(path, validSize); err != nil {
+		return errors.Wrap(err, "[file spool] error when truncating torn record")
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package buffering
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+func getTestSpoolConfig(t *testing.T) config.SpoolConfig {
+	return config.SpoolConfig{
+		Dir:         t.TempDir(),
+		SegmentSize: 1024,
+		MaxSize:     1024 * 1024,
+		MaxAge:      time.Hour,
+	}
+}
+
+func replayAll(t *testing.T, spool *fileSpool) [][]domain.Metric {
+	replayed := make([][]domain.Metric, 0)
+	err := spool.Replay(consumer, func(snapshot []domain.Metric) error {
+		replayed = append(replayed, snapshot)
+		return nil
+	})
+	require.NoError(t, err)
+	return replayed
+}
+
+func TestSpoolReplayInOrder(t *testing.T) {
+	spool, err := NewFileSpool(context.Background(), getTestSpoolConfig(t))
+	require.NoError(t, err)
+
+	for i := 1; i <= 50; i++ {
+		require.NoError(t, spool.Spool(consumer, []domain.Metric{domain.NewCounter(domain.PollCount, domain.Counter(i))}))
+	}
+
+	replayed := replayAll(t, spool)
+	require.Len(t, replayed, 50)
+	for i, snapshot := range replayed {
+		assert.Equal(t, domain.Counter(i+1), snapshot[0].Counter)
+	}
+	// Everything is replayed, nothing is left
+	assert.Empty(t, replayAll(t, spool))
+}
+
+func TestSpoolReplayStopsOnError(t *testing.T) {
+	spool, err := NewFileSpool(context.Background(), getTestSpoolConfig(t))
+	require.NoError(t, err)
+
+	require.NoError(t, spool.Spool(consumer, []domain.Metric{domain.NewCounter(domain.PollCount, 1)}))
+	require.NoError(t, spool.Spool(consumer, []domain.Metric{domain.NewCounter(domain.PollCount, 2)}))
+
+	exportErr := errors.New("server is down")
+	calls := 0
+	err = spool.Replay(consumer, func(snapshot []domain.Metric) error {
+		calls++
+		return exportErr
+	})
+	assert.ErrorIs(t, err, exportErr)
+	assert.Equal(t, 1, calls)
+
+	// Failed snapshot stays in the spool
+	replayed := replayAll(t, spool)
+	require.Len(t, replayed, 2)
+	assert.Equal(t, domain.Counter(1), replayed[0][0].Counter)
+}
+
+func TestSpoolIsNotBlockedByReplay(t *testing.T) {
+	spool, err := NewFileSpool(context.Background(), getTestSpoolConfig(t))
+	require.NoError(t, err)
+	require.NoError(t, spool.Spool(consumer, []domain.Metric{domain.NewCounter(domain.PollCount, 1)}))
+
+	// Snapshots are spooled (for this and other consumers) while replay waits for a slow server
+	replayed := make([][]domain.Metric, 0)
+	err = spool.Replay(consumer, func(snapshot []domain.Metric) error {
+		replayed = append(replayed, snapshot)
+		if len(replayed) > 1 {
+			return nil
+		}
+		done := make(chan error, 2)
+		go func() {
+			done <- spool.Spool("other", snapshot)
+			done <- spool.Spool(consumer, []domain.Metric{domain.NewCounter(domain.PollCount, 2)})
+		}()
+		for i := 0; i < 2; i++ {
+			select {
+			case spoolErr := <-done:
+				require.NoError(t, spoolErr)
+			case <-time.After(time.Second):
+				t.Fatal("spool is blocked by replay")
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	// Snapshot spooled during replay is replayed as well
+	assert.Len(t, replayed, 2)
+
+	other := make([][]domain.Metric, 0)
+	require.NoError(t, spool.Replay("other", func(snapshot []domain.Metric) error {
+		other = append(other, snapshot)
+		return nil
+	}))
+	assert.Len(t, other, 1)
+}
+
+func TestSpoolSurvivesRestart(t *testing.T) {
+	cfg := getTestSpoolConfig(t)
+
+	spool, err := NewFileSpool(context.Background(), cfg)
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, spool.Spool(consumer, []domain.Metric{domain.NewCounter(domain.PollCount, domain.Counter(i))}))
+	}
+	// Replay only the first snapshot
+	err = spool.Replay(consumer, func(snapshot []domain.Metric) error {
+		if snapshot[0].Counter > 1 {
+			return errors.New("server is down")
+		}
+		return nil
+	})
+	require.Error(t, err)
+
+	// Simulate a crash in the middle of writing a record
+	segment := filepath.Join(cfg.Dir, consumer, "00000000000000000001.seg")
+	file, err := os.OpenFile(segment, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"ts":"2022-`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	restarted, err := NewFileSpool(context.Background(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, restarted.Spool(consumer, []domain.Metric{domain.NewCounter(domain.PollCount, 4)}))
+
+	replayed := replayAll(t, restarted)
+	require.Len(t, replayed, 3)
+	for i, snapshot := range replayed {
+		assert.Equal(t, domain.Counter(i+2), snapshot[0].Counter)
+	}
+}
+
+func TestSpoolCaps(t *testing.T) {
+	cfg := getTestSpoolConfig(t)
+	cfg.SegmentSize = 256
+	cfg.MaxSize = 1024
+
+	spool, err := NewFileSpool(context.Background(), cfg)
+	require.NoError(t, err)
+	for i := 1; i <= 100; i++ {
+		require.NoError(t, spool.Spool(consumer, []domain.Metric{domain.NewCounter(domain.PollCount, domain.Counter(i))}))
+	}
+
+	q, err := spool.getQueue(consumer)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, q.totalSize(), cfg.MaxSize)
+
+	// Oldest snapshots are dropped, newest are kept
+	replayed := replayAll(t, spool)
+	require.NotEmpty(t, replayed)
+	assert.Less(t, len(replayed), 100)
+	assert.Equal(t, domain.Counter(100), replayed[len(replayed)-1][0].Counter)
+}
+
+func TestSpoolSkipsExpired(t *testing.T) {
+	cfg := getTestSpoolConfig(t)
+	cfg.MaxAge = time.Millisecond
+
+	spool, err := NewFileSpool(context.Background(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, spool.Spool(consumer, []domain.Metric{domain.NewCounter(domain.PollCount, 1)}))
+
+	time.Sleep(5 * time.Millisecond)
+	assert.Empty(t, replayAll(t, spool))
+}
@@ -7,14 +7,25 @@ import (
 )
 
 type inMemBuffer struct {
-	buffer map[string]*domain.Metric
-	mutex  *sync.RWMutex
+	// Each consumer (e.g. exporter) has its own buffer, so that a failed export
+	// of one consumer does not affect the others, buffers are keyed by series key (name + labels)
+	buffers map[string]map[string]*domain.Metric
+	mutex   *sync.RWMutex
 }
 
 func NewInMemBuffer() *inMemBuffer {
 	return &inMemBuffer{
-		buffer: make(map[string]*domain.Metric),
-		mutex:  &sync.RWMutex{},
+		buffers: make(map[string]map[string]*domain.Metric),
+		mutex:   &sync.RWMutex{},
+	}
+}
+
+func (b *inMemBuffer) Register(consumer string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.buffers[consumer]; !ok {
+		b.buffers[consumer] = make(map[string]*domain.Metric)
 	}
 }
 
@@ -22,38 +33,82 @@ func (b *inMemBuffer) Buffer(mtx []domain.Metric) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	for i, metric := range mtx {
-		if _, ok := b.buffer[metric.Name]; ok {
-			switch metric.Type {
-			case domain.TypeCounter:
-				// For counters, new value is added on top of previous value with AddCounter()
-				b.buffer[metric.Name].Counter += metric.Counter
-			case domain.TypeGauge:
-				// For gauges, previous value is overwritten with SetGauge()
-				b.buffer[metric.Name].Gauge = metric.Gauge
-			}
-		} else {
-			// Add metric to the buffer
-			b.buffer[metric.Name] = &mtx[i]
+	for _, buffer := range b.buffers {
+		for _, metric := range mtx {
+			mergeNewer(buffer, metric)
 		}
 	}
 }
 
-func (b *inMemBuffer) Retrieve() []domain.Metric {
-	b.mutex.RLock()
-	defer b.mutex.RUnlock()
+func (b *inMemBuffer) Retrieve(consumer string) []domain.Metric {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 
 	result := make([]domain.Metric, 0)
 
-	for _, metric := range b.buffer {
+	buffer, ok := b.buffers[consumer]
+	if !ok {
+		return result
+	}
+	// Swap consumer's buffer with an empty one, so that metrics
+	// buffered after this point go to the next snapshot
+	b.buffers[consumer] = make(map[string]*domain.Metric)
+
+	for _, metric := range buffer {
 		result = append(result, *metric)
 	}
 	return result
 }
 
-func (b *inMemBuffer) Flush() {
+func (b *inMemBuffer) Requeue(consumer string, snapshot []domain.Metric) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	b.buffer = make(map[string]*domain.Metric)
+	buffer, ok := b.buffers[consumer]
+	if !ok {
+		return
+	}
+	for _, metric := range snapshot {
+		mergeOlder(buffer, metric)
+	}
+}
+
+// mergeNewer merges metric, which is newer than what is in the buffer
+func mergeNewer(buffer map[string]*domain.Metric, metric domain.Metric) {
+	existing, ok := buffer[metric.Key()]
+	if !ok {
+		// Add a copy of metric to the buffer
+		buffer[metric.Key()] = &metric
+		return
+	}
+	switch metric.Type {
+	case domain.TypeCounter:
+		// For counters, new value is added on top of previous value
+		existing.Counter += metric.Counter
+	case domain.TypeGauge:
+		// For gauges, previous value is overwritten
+		existing.Gauge = metric.Gauge
+	case domain.TypeHistogram:
+		// For histograms, new observations are merged with previous ones
+		existing.Histogram = domain.MergeHistograms(existing.Histogram, metric.Histogram)
+	}
+}
+
+// mergeOlder merges metric, which is older than what is in the buffer (e.g. requeued after failed export)
+func mergeOlder(buffer map[string]*domain.Metric, metric domain.Metric) {
+	existing, ok := buffer[metric.Key()]
+	if !ok {
+		// Add a copy of metric to the buffer
+		buffer[metric.Key()] = &metric
+		return
+	}
+	switch metric.Type {
+	case domain.TypeCounter:
+		// For counters, deltas are summed up, so none of them are lost
+		existing.Counter += metric.Counter
+	case domain.TypeHistogram:
+		// For histograms, observations are merged (newer bounds win if buckets were reconfigured)
+		existing.Histogram = domain.MergeHistograms(metric.Histogram, existing.Histogram)
+	}
+	// For gauges, the value already in the buffer is newer, so it is kept
 }
@@ -5,10 +5,13 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
 )
 
+const consumer = "http"
+
 func TestBuffer(t *testing.T) {
 	var (
 		basicCounter    = domain.NewCounter(domain.PollCount, 10)
@@ -45,7 +48,7 @@ func TestBuffer(t *testing.T) {
 		{
 			name: "update counter",
 			have: map[string]*domain.Metric{
-				domain.PollCount: &basicCounter,
+				domain.PollCount: {Name: domain.PollCount, Type: domain.TypeCounter, Counter: 10},
 			},
 			add: []domain.Metric{
 				domain.NewCounter(domain.PollCount, 20),
@@ -57,7 +60,7 @@ func TestBuffer(t *testing.T) {
 		{
 			name: "update gauge",
 			have: map[string]*domain.Metric{
-				domain.Alloc: &basicGauge,
+				domain.Alloc: {Name: domain.Alloc, Type: domain.TypeGauge, Gauge: 10.333},
 			},
 			add: []domain.Metric{
 				domain.NewGauge(domain.Alloc, 20.555),
@@ -70,17 +73,69 @@ func TestBuffer(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			buf := inMemBuffer{
-				buffer: tt.have,
-				mutex:  &sync.RWMutex{},
+				buffers: map[string]map[string]*domain.Metric{consumer: tt.have},
+				mutex:   &sync.RWMutex{},
 			}
 			buf.Buffer(tt.add)
-			assert.EqualValues(t, tt.want, buf.buffer)
+			assert.EqualValues(t, tt.want, buf.buffers[consumer])
 		})
 	}
 }
 
+func TestBufferMultipleConsumers(t *testing.T) {
+	buffer := NewInMemBuffer()
+	buffer.Register("first")
+	buffer.Buffer([]domain.Metric{domain.NewCounter(domain.PollCount, 1)})
+	buffer.Register("second")
+	buffer.Buffer([]domain.Metric{domain.NewCounter(domain.PollCount, 2)})
+
+	assert.ElementsMatch(t, []domain.Metric{domain.NewCounter(domain.PollCount, 3)}, buffer.Retrieve("first"))
+	assert.ElementsMatch(t, []domain.Metric{domain.NewCounter(domain.PollCount, 2)}, buffer.Retrieve("second"))
+	assert.ElementsMatch(t, []domain.Metric{}, buffer.Retrieve("unknown"))
+}
+
+func TestBufferLabelledSeries(t *testing.T) {
+	buffer := NewInMemBuffer()
+	buffer.Register(consumer)
+
+	hostA := domain.NewCounter(domain.PollCount, 1)
+	hostA.Labels = domain.Labels{"host": "a"}
+	hostB := domain.NewCounter(domain.PollCount, 2)
+	hostB.Labels = domain.Labels{"host": "b"}
+	buffer.Buffer([]domain.Metric{hostA, hostB, hostA})
+
+	// Series with different labels are buffered separately
+	hostAUpd := hostA
+	hostAUpd.Counter = 2
+	assert.ElementsMatch(t, []domain.Metric{hostAUpd, hostB}, buffer.Retrieve(consumer))
+}
+
+func TestBufferHistograms(t *testing.T) {
+	buffer := NewInMemBuffer()
+	buffer.Register(consumer)
+
+	pauses := func(below, above uint64, sum float64) *domain.Histogram {
+		return &domain.Histogram{Bounds: []float64{1}, Counts: []uint64{below, above}, Sum: sum, Count: below + above}
+	}
+	first := domain.NewHistogram(domain.PauseNs, pauses(1, 0, 0.5))
+	second := domain.NewHistogram(domain.PauseNs, pauses(0, 2, 6))
+	buffer.Buffer([]domain.Metric{first, second})
+
+	snapshot := buffer.Retrieve(consumer)
+	require.Len(t, snapshot, 1)
+	assert.Equal(t, pauses(1, 2, 6.5), snapshot[0].Histogram)
+
+	// Requeued observations are merged with ones buffered after retrieval
+	buffer.Buffer([]domain.Metric{first})
+	buffer.Requeue(consumer, snapshot)
+	snapshot = buffer.Retrieve(consumer)
+	require.Len(t, snapshot, 1)
+	assert.Equal(t, pauses(2, 2, 7), snapshot[0].Histogram)
+}
+
 func TestBufferWithRaceCondition(t *testing.T) {
 	buffer := NewInMemBuffer()
+	buffer.Register(consumer)
 
 	count := 1000
 	wg := sync.WaitGroup{}
@@ -94,7 +149,7 @@ func TestBufferWithRaceCondition(t *testing.T) {
 	}
 	wg.Wait()
 
-	result := buffer.Retrieve()
+	result := buffer.Retrieve(consumer)
 	assert.Equal(t, domain.Counter(1000), result[0].Counter)
 }
 
@@ -139,47 +194,94 @@ func TestRetrieve(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			buf := inMemBuffer{
-				buffer: tt.buffer,
-				mutex:  &sync.RWMutex{},
+				buffers: map[string]map[string]*domain.Metric{consumer: tt.buffer},
+				mutex:   &sync.RWMutex{},
 			}
-			list := buf.Retrieve()
+			list := buf.Retrieve(consumer)
 			assert.ElementsMatch(t, tt.want, list)
+			// Buffer is swapped with an empty one after retrieving
+			assert.Empty(t, buf.buffers[consumer])
 		})
 	}
 }
 
-func TestFlush(t *testing.T) {
-	var (
-		basicCounter = domain.NewCounter(domain.PollCount, 10)
-		basicGauge   = domain.NewGauge(domain.Alloc, 10.333)
-	)
+func TestRequeue(t *testing.T) {
 	tests := []struct {
-		name   string
-		buffer map[string]*domain.Metric
-		want   map[string]*domain.Metric
+		name     string
+		have     map[string]*domain.Metric
+		snapshot []domain.Metric
+		want     []domain.Metric
 	}{
 		{
-			name:   "flush empty buffer",
-			buffer: map[string]*domain.Metric{},
-			want:   map[string]*domain.Metric{},
+			name: "requeue to empty buffer",
+			have: map[string]*domain.Metric{},
+			snapshot: []domain.Metric{
+				domain.NewCounter(domain.PollCount, 10),
+				domain.NewGauge(domain.Alloc, 10.333),
+			},
+			want: []domain.Metric{
+				domain.NewCounter(domain.PollCount, 10),
+				domain.NewGauge(domain.Alloc, 10.333),
+			},
 		},
 		{
-			name: "flush non-empty buffer",
-			buffer: map[string]*domain.Metric{
-				domain.PollCount: &basicCounter,
-				domain.Alloc:     &basicGauge,
+			name: "requeue counter, deltas are summed",
+			have: map[string]*domain.Metric{
+				domain.PollCount: {Name: domain.PollCount, Type: domain.TypeCounter, Counter: 5},
+			},
+			snapshot: []domain.Metric{
+				domain.NewCounter(domain.PollCount, 10),
+			},
+			want: []domain.Metric{
+				domain.NewCounter(domain.PollCount, 15),
+			},
+		},
+		{
+			name: "requeue gauge, newer buffered value is kept",
+			have: map[string]*domain.Metric{
+				domain.Alloc: {Name: domain.Alloc, Type: domain.TypeGauge, Gauge: 20.555},
+			},
+			snapshot: []domain.Metric{
+				domain.NewGauge(domain.Alloc, 10.333),
+			},
+			want: []domain.Metric{
+				domain.NewGauge(domain.Alloc, 20.555),
 			},
-			want: map[string]*domain.Metric{},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			buf := inMemBuffer{
-				buffer: tt.buffer,
-				mutex:  &sync.RWMutex{},
+				buffers: map[string]map[string]*domain.Metric{consumer: tt.have},
+				mutex:   &sync.RWMutex{},
 			}
-			buf.Flush()
-			assert.EqualValues(t, tt.want, buf.buffer)
+			buf.Requeue(consumer, tt.snapshot)
+			assert.ElementsMatch(t, tt.want, buf.Retrieve(consumer))
 		})
 	}
 }
+
+func TestRetrieveAndRequeueWithRaceCondition(t *testing.T) {
+	buffer := NewInMemBuffer()
+	buffer.Register(consumer)
+
+	count := 1000
+	wg := sync.WaitGroup{}
+	wg.Add(count * 2)
+
+	for i := 0; i < count; i++ {
+		go func() {
+			buffer.Buffer([]domain.Metric{domain.NewCounter(domain.PollCount, 1)})
+			wg.Done()
+		}()
+		go func() {
+			// Simulate failed export: nothing should be lost or counted twice
+			buffer.Requeue(consumer, buffer.Retrieve(consumer))
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	result := buffer.Retrieve(consumer)
+	assert.Equal(t, domain.Counter(1000), result[0].Counter)
+}
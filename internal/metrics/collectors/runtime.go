@@ -3,18 +3,26 @@ package collectors
 import (
 	"context"
 	"runtime"
+	"sync"
 
+	"eridiumdev/yandex-praktikum-go-devops/config"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/worker"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
 )
 
 type runtimeCollector struct {
 	*worker.Worker
+	gcPauseBuckets []float64
+	// lastNumGC is the number of GC cycles seen by previous collection
+	lastNumGC uint32
+	mutex     *sync.Mutex
 }
 
-func NewRuntimeCollector(name string) *runtimeCollector {
+func NewRuntimeCollector(name string, cfg config.RuntimeCollectorConfig) *runtimeCollector {
 	col := &runtimeCollector{
-		Worker: worker.New(name, 1),
+		Worker:         worker.New(name, 1),
+		gcPauseBuckets: cfg.GCPauseBuckets,
+		mutex:          &sync.Mutex{},
 	}
 	return col
 }
@@ -50,6 +58,7 @@ func (col *runtimeCollector) getRuntimeSnapshot() []domain.Metric {
 		domain.NewGauge(domain.NumForcedGC, domain.Gauge(stats.NumForcedGC)),
 		domain.NewGauge(domain.NumGC, domain.Gauge(stats.NumGC)),
 		domain.NewGauge(domain.OtherSys, domain.Gauge(stats.OtherSys)),
+		domain.NewHistogram(domain.PauseNs, col.getGCPauses(stats)),
 		domain.NewGauge(domain.PauseTotalNs, domain.Gauge(stats.PauseTotalNs)),
 		domain.NewGauge(domain.StackInuse, domain.Gauge(stats.StackInuse)),
 		domain.NewGauge(domain.StackSys, domain.Gauge(stats.StackSys)),
@@ -57,3 +66,25 @@ func (col *runtimeCollector) getRuntimeSnapshot() []domain.Metric {
 		domain.NewGauge(domain.TotalAlloc, domain.Gauge(stats.TotalAlloc)),
 	}
 }
+
+// getGCPauses returns histogram of GC pauses, which happened since previous collection
+// Runtime keeps only last 256 pauses, so older ones are lost if there were more GC cycles in between
+func (col *runtimeCollector) getGCPauses(stats *runtime.MemStats) *domain.Histogram {
+	col.mutex.Lock()
+	defer col.mutex.Unlock()
+
+	pauses := domain.NewHistogramValue(col.gcPauseBuckets)
+
+	newCycles := stats.NumGC - col.lastNumGC
+	if newCycles > uint32(len(stats.PauseNs)) {
+		newCycles = uint32(len(stats.PauseNs))
+	}
+	for i := uint32(0); i < newCycles; i++ {
+		// PauseNs is a circular buffer, the most recent pause is at [(NumGC+255)%256]
+		idx := (stats.NumGC - 1 - i) % uint32(len(stats.PauseNs))
+		pauses.Observe(float64(stats.PauseNs[idx]))
+	}
+	col.lastNumGC = stats.NumGC
+
+	return pauses
+}
@@ -2,16 +2,18 @@ package collectors
 
 import (
 	"context"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"eridiumdev/yandex-praktikum-go-devops/config"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
 )
 
 func TestRuntimeCollect(t *testing.T) {
-	col := NewRuntimeCollector("runtime")
+	col := NewRuntimeCollector("runtime", config.RuntimeCollectorConfig{})
 	snapshot, err := col.Collect(context.Background())
 
 	require.NoError(t, err)
@@ -25,3 +27,31 @@ func TestRuntimeCollect(t *testing.T) {
 		}
 	}
 }
+
+func TestRuntimeCollectGCPauses(t *testing.T) {
+	buckets := []float64{1e4, 1e6, 1e8}
+	col := NewRuntimeCollector("runtime", config.RuntimeCollectorConfig{GCPauseBuckets: buckets})
+
+	// First collection reports all pauses so far
+	_, err := col.Collect(context.Background())
+	require.NoError(t, err)
+
+	runtime.GC()
+	runtime.GC()
+
+	snapshot, err := col.Collect(context.Background())
+	require.NoError(t, err)
+
+	var pauses *domain.Histogram
+	for _, m := range snapshot {
+		if m.Name == domain.PauseNs {
+			assert.Equal(t, domain.TypeHistogram, m.Type)
+			pauses = m.Histogram
+			break
+		}
+	}
+	require.NotNil(t, pauses)
+	assert.Equal(t, buckets, pauses.Bounds)
+	assert.GreaterOrEqual(t, pauses.Count, uint64(2))
+	assert.NoError(t, pauses.Validate())
+}
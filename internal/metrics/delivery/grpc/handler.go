@@ -0,0 +1,113 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/delivery/grpc/pb"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+const (
+	ErrStringInvalidMetricType = "invalid metric type"
+	ErrStringInvalidValue      = "invalid metric value"
+	ErrStringInvalidHash       = "invalid hash"
+	ErrStringMetricNotFound    = "metric not found"
+	ErrStringDatabaseError     = "database error"
+)
+
+type MetricsServer struct {
+	pb.UnimplementedMetricsServer
+	service MetricsService
+}
+
+func NewMetricsServer(service MetricsService) *MetricsServer {
+	return &MetricsServer{
+		service: service,
+	}
+}
+
+func (s *MetricsServer) UpdateBatch(ctx context.Context, req *pb.UpdateBatchRequest) (*pb.UpdateBatchResponse, error) {
+	return s.updateMany(ctx, req.GetMetrics())
+}
+
+func (s *MetricsServer) UpdateStream(stream pb.Metrics_UpdateStreamServer) error {
+	ctx := stream.Context()
+	received := make([]*pb.Metric, 0)
+	for {
+		m, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			logger.New(ctx).Errorf("[metrics grpc server] error when receiving metric: %s", err.Error())
+			return err
+		}
+		received = append(received, m)
+	}
+
+	resp, err := s.updateMany(ctx, received)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp)
+}
+
+func (s *MetricsServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	if !domain.IsValidMetricType(req.GetType()) {
+		logger.New(ctx).Errorf("[metrics grpc server] received invalid metric type '%s'", req.GetType())
+		return nil, status.Error(codes.Unimplemented, ErrStringInvalidMetricType)
+	}
+
+	metric, found, err := s.service.Get(ctx, req.GetId(), domain.Labels(req.GetLabels()).Copy())
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics grpc server] error when getting metric: %s", err.Error())
+		return nil, status.Error(codes.Internal, ErrStringDatabaseError)
+	}
+	if !found || metric.Type != req.GetType() {
+		logger.New(ctx).Errorf("[metrics grpc server] metric '%s/%s' not found", req.GetType(), req.GetId())
+		return nil, status.Error(codes.NotFound, ErrStringMetricNotFound)
+	}
+	return &pb.GetResponse{Metric: TranslateFromMetric(metric)}, nil
+}
+
+func (s *MetricsServer) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	list, err := s.service.List(ctx)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics grpc server] error when getting metrics: %s", err.Error())
+		return nil, status.Error(codes.Internal, ErrStringDatabaseError)
+	}
+	return &pb.ListResponse{Metrics: translateFromMetrics(list)}, nil
+}
+
+func (s *MetricsServer) updateMany(ctx context.Context, list []*pb.Metric) (*pb.UpdateBatchResponse, error) {
+	for _, m := range list {
+		if !domain.IsValidMetricType(m.GetType()) {
+			logger.New(ctx).Errorf("[metrics grpc server] received invalid metric type '%s'", m.GetType())
+			return nil, status.Error(codes.Unimplemented, ErrStringInvalidMetricType)
+		}
+	}
+	metrics := translateToMetrics(list)
+	for _, metric := range metrics {
+		if err := metric.ValidateValue(); err != nil {
+			logger.New(ctx).Errorf("[metrics grpc server] received invalid value of metric %s: %s", metric.Name, err.Error())
+			return nil, status.Error(codes.InvalidArgument, ErrStringInvalidValue)
+		}
+	}
+
+	updatedMetrics, err := s.service.UpdateMany(ctx, metrics)
+	if errors.Is(err, domain.ErrUpdateJournaled) {
+		// Updates are accepted, but their resulting values are not known yet, so none are returned
+		return &pb.UpdateBatchResponse{}, nil
+	}
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics grpc server] error when updating metrics: %s", err.Error())
+		return nil, status.Error(codes.Internal, ErrStringDatabaseError)
+	}
+	return &pb.UpdateBatchResponse{Metrics: translateFromMetrics(updatedMetrics)}, nil
+}
@@ -0,0 +1,222 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/backup"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/delivery/grpc/pb"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/hash"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/repository"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/service"
+)
+
+func init() {
+	logger.InitZerolog(context.Background(), config.LoggerConfig{
+		Level: logger.LevelCritical,
+		Mode:  logger.ModeDevelopment,
+	})
+}
+
+func getDummyHasher() MetricsHasher {
+	return hash.NewHasher("s3cr3t-k3y")
+}
+
+// startTestServer starts gRPC server with in-mem repo on bufconn and returns a client connected to it
+// If signClient is true, client signs outgoing metrics with hash interceptors
+func startTestServer(t *testing.T, signClient bool) pb.MetricsClient {
+	ctx := context.Background()
+	repo := repository.NewInMemRepo()
+	_ = repo.Store(ctx, domain.NewCounter(domain.PollCount, 5))
+	_ = repo.Store(ctx, domain.NewGauge(domain.Alloc, 10.123))
+
+	svc, err := service.NewMetricsService(ctx, repo, &backup.Mock{}, config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	interceptor := NewHashInterceptor(getDummyHasher())
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(interceptor.UnaryServer()),
+		grpc.ChainStreamInterceptor(interceptor.StreamServer()),
+	)
+	pb.RegisterMetricsServer(s, NewMetricsServer(svc))
+
+	listener := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = s.Serve(listener)
+	}()
+	t.Cleanup(s.Stop)
+
+	opts := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+	if signClient {
+		opts = append(opts,
+			grpc.WithChainUnaryInterceptor(interceptor.UnaryClient()),
+			grpc.WithChainStreamInterceptor(interceptor.StreamClient()),
+		)
+	}
+	conn, err := grpc.Dial("bufnet", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return pb.NewMetricsClient(conn)
+}
+
+func counter(name string, delta int64, hash string) *pb.Metric {
+	return &pb.Metric{Id: name, Type: domain.TypeCounter, Delta: &delta, Hash: hash}
+}
+
+func gauge(name string, value float64, hash string) *pb.Metric {
+	return &pb.Metric{Id: name, Type: domain.TypeGauge, Value: &value, Hash: hash}
+}
+
+func TestUpdateBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		signClient bool
+		metrics    []*pb.Metric
+		wantCode   codes.Code
+		want       []domain.Metric
+	}{
+		{
+			name:       "positive test: counter and gauge",
+			signClient: true,
+			metrics:    []*pb.Metric{counter(domain.PollCount, 5, ""), gauge(domain.Alloc, 10.2, "")},
+			wantCode:   codes.OK,
+			want: []domain.Metric{
+				domain.NewCounter(domain.PollCount, 10),
+				domain.NewGauge(domain.Alloc, 10.2),
+			},
+		},
+		{
+			name:       "positive test: unsigned metrics",
+			signClient: false,
+			metrics:    []*pb.Metric{counter(domain.PollCount, 5, "")},
+			wantCode:   codes.OK,
+			want: []domain.Metric{
+				domain.NewCounter(domain.PollCount, 10),
+			},
+		},
+		{
+			name:       "negative test: bad hash",
+			signClient: false,
+			metrics:    []*pb.Metric{counter(domain.PollCount, 5, "-")},
+			wantCode:   codes.InvalidArgument,
+		},
+		{
+			name:       "negative test: bad metric type",
+			signClient: true,
+			metrics:    []*pb.Metric{{Id: domain.PollCount, Type: "unknown"}},
+			wantCode:   codes.Unimplemented,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := startTestServer(t, tt.signClient)
+			resp, err := client.UpdateBatch(context.Background(), &pb.UpdateBatchRequest{Metrics: tt.metrics})
+			require.Equal(t, tt.wantCode, status.Code(err), "status code")
+			if tt.wantCode != codes.OK {
+				return
+			}
+			assert.ElementsMatch(t, tt.want, translateToMetrics(resp.GetMetrics()))
+			for _, m := range resp.GetMetrics() {
+				assert.True(t, getDummyHasher().Check(context.Background(), TranslateToMetric(m), m.GetHash()), "response hash")
+			}
+		})
+	}
+}
+
+func TestUpdateStream(t *testing.T) {
+	client := startTestServer(t, true)
+
+	stream, err := client.UpdateStream(context.Background())
+	require.NoError(t, err)
+	for _, m := range []*pb.Metric{
+		counter(domain.PollCount, 1, ""),
+		counter(domain.PollCount, 2, ""),
+		gauge(domain.Alloc, 1.5, ""),
+	} {
+		require.NoError(t, stream.Send(m))
+	}
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 8),
+		domain.NewGauge(domain.Alloc, 1.5),
+	}, translateToMetrics(resp.GetMetrics()))
+}
+
+func TestUpdateStreamWithBadHash(t *testing.T) {
+	client := startTestServer(t, false)
+
+	stream, err := client.UpdateStream(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(counter(domain.PollCount, 1, "-")))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *pb.GetRequest
+		wantCode codes.Code
+		want     domain.Metric
+	}{
+		{
+			name:     "positive test: counter",
+			req:      &pb.GetRequest{Id: domain.PollCount, Type: domain.TypeCounter},
+			wantCode: codes.OK,
+			want:     domain.NewCounter(domain.PollCount, 5),
+		},
+		{
+			name:     "negative test: wrong metric type",
+			req:      &pb.GetRequest{Id: domain.PollCount, Type: "unknown"},
+			wantCode: codes.Unimplemented,
+		},
+		{
+			name:     "negative test: metric not found",
+			req:      &pb.GetRequest{Id: "abcd", Type: domain.TypeCounter},
+			wantCode: codes.NotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := startTestServer(t, true)
+			resp, err := client.Get(context.Background(), tt.req)
+			require.Equal(t, tt.wantCode, status.Code(err), "status code")
+			if tt.wantCode != codes.OK {
+				return
+			}
+			assert.Equal(t, tt.want, TranslateToMetric(resp.GetMetric()))
+			assert.Equal(t, "7148ff92910a879bba42647839901cdd4f9c68f952657e36ead4e894511d82af", resp.GetMetric().GetHash())
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	client := startTestServer(t, true)
+	resp, err := client.List(context.Background(), &pb.ListRequest{})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 5),
+		domain.NewGauge(domain.Alloc, 10.123),
+	}, translateToMetrics(resp.GetMetrics()))
+}
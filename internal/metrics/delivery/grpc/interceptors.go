@@ -0,0 +1,149 @@
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/delivery/grpc/pb"
+)
+
+// HashInterceptor applies HMAC hashes to metrics, travelling in gRPC messages:
+// server-side it checks hashes of incoming metrics and signs outgoing ones,
+// client-side it signs outgoing metrics
+type HashInterceptor struct {
+	hasher MetricsHasher
+}
+
+func NewHashInterceptor(hasher MetricsHasher) *HashInterceptor {
+	return &HashInterceptor{
+		hasher: hasher,
+	}
+}
+
+func (i *HashInterceptor) UnaryServer() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if err := i.check(ctx, req); err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err == nil {
+			i.sign(ctx, resp)
+		}
+		return resp, err
+	}
+}
+
+func (i *HashInterceptor) StreamServer() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		return handler(srv, &hashServerStream{ServerStream: ss, interceptor: i})
+	}
+}
+
+func (i *HashInterceptor) UnaryClient() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		i.sign(ctx, req)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (i *HashInterceptor) StreamClient() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &hashClientStream{ClientStream: cs, interceptor: i}, nil
+	}
+}
+
+// check validates hashes of all metrics in msg, metrics without hash are not checked
+func (i *HashInterceptor) check(ctx context.Context, msg interface{}) error {
+	for _, m := range metricsOf(msg) {
+		if m.GetHash() != "" && !i.hasher.Check(ctx, TranslateToMetric(m), m.GetHash()) {
+			logger.New(ctx).Errorf("[metrics grpc hash interceptor] provided hash for metric %s is invalid", m.GetId())
+			return status.Error(codes.InvalidArgument, ErrStringInvalidHash)
+		}
+	}
+	return nil
+}
+
+// sign populates hashes of all metrics in msg
+func (i *HashInterceptor) sign(ctx context.Context, msg interface{}) {
+	for _, m := range metricsOf(msg) {
+		m.Hash = i.hasher.Hash(ctx, TranslateToMetric(m))
+	}
+}
+
+// metricsOf extracts metrics from any message of the Metrics service
+func metricsOf(msg interface{}) []*pb.Metric {
+	switch m := msg.(type) {
+	case *pb.Metric:
+		return []*pb.Metric{m}
+	case *pb.UpdateBatchRequest:
+		return m.GetMetrics()
+	case *pb.UpdateBatchResponse:
+		return m.GetMetrics()
+	case *pb.GetResponse:
+		if m.GetMetric() != nil {
+			return []*pb.Metric{m.GetMetric()}
+		}
+	case *pb.ListResponse:
+		return m.GetMetrics()
+	}
+	return nil
+}
+
+type hashServerStream struct {
+	grpc.ServerStream
+	interceptor *HashInterceptor
+}
+
+func (s *hashServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.interceptor.check(s.Context(), m)
+}
+
+func (s *hashServerStream) SendMsg(m interface{}) error {
+	s.interceptor.sign(s.Context(), m)
+	return s.ServerStream.SendMsg(m)
+}
+
+type hashClientStream struct {
+	grpc.ClientStream
+	interceptor *HashInterceptor
+}
+
+func (s *hashClientStream) SendMsg(m interface{}) error {
+	s.interceptor.sign(s.Context(), m)
+	return s.ClientStream.SendMsg(m)
+}
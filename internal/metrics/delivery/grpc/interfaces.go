@@ -0,0 +1,22 @@
+package grpc
+
+import (
+	"context"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// These are the interfaces required for handling metrics gRPC requests
+
+// MetricsService should be able to perform common operations on metrics, such as updating and retrieving
+type MetricsService interface {
+	UpdateMany(ctx context.Context, metrics []domain.Metric) ([]domain.Metric, error)
+	Get(ctx context.Context, name string, labels domain.Labels) (m domain.Metric, found bool, err error)
+	List(ctx context.Context) ([]domain.Metric, error)
+}
+
+// MetricsHasher can calculate hashes based on metric, and also check if provided hash matches calculated
+type MetricsHasher interface {
+	Hash(ctx context.Context, metric domain.Metric) string
+	Check(ctx context.Context, metric domain.Metric, hash string) bool
+}
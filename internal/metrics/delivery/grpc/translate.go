@@ -0,0 +1,73 @@
+package grpc
+
+import (
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/delivery/grpc/pb"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// TranslateToMetric converts protobuf metric to domain metric
+func TranslateToMetric(m *pb.Metric) domain.Metric {
+	metric := domain.Metric{
+		Name:   m.GetId(),
+		Type:   m.GetType(),
+		Labels: domain.Labels(m.GetLabels()).Copy(),
+	}
+	if m.Delta != nil {
+		metric.Counter = domain.Counter(m.GetDelta())
+	}
+	if m.Value != nil {
+		metric.Gauge = domain.Gauge(m.GetValue())
+	}
+	if h := m.GetHistogram(); h != nil {
+		metric.Histogram = &domain.Histogram{
+			Bounds: h.GetBounds(),
+			Counts: h.GetCounts(),
+			Sum:    h.GetSum(),
+			Count:  h.GetCount(),
+		}
+	}
+	return metric
+}
+
+// TranslateFromMetric converts domain metric to protobuf metric, hash is populated by interceptors
+func TranslateFromMetric(metric domain.Metric) *pb.Metric {
+	result := &pb.Metric{
+		Id:     metric.Name,
+		Type:   metric.Type,
+		Labels: metric.Labels.Copy(),
+	}
+	switch metric.Type {
+	case domain.TypeCounter:
+		val := int64(metric.Counter)
+		result.Delta = &val
+	case domain.TypeGauge:
+		val := float64(metric.Gauge)
+		result.Value = &val
+	case domain.TypeHistogram:
+		if h := metric.Histogram; h != nil {
+			result.Histogram = &pb.Histogram{
+				Bounds: h.Bounds,
+				Counts: h.Counts,
+				Sum:    h.Sum,
+				Count:  h.Count,
+			}
+		}
+	}
+	return result
+}
+
+func translateToMetrics(list []*pb.Metric) []domain.Metric {
+	metrics := make([]domain.Metric, 0, len(list))
+	for _, m := range list {
+		metrics = append(metrics, TranslateToMetric(m))
+	}
+	return metrics
+}
+
+func translateFromMetrics(metrics []domain.Metric) []*pb.Metric {
+	list := make([]*pb.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		list = append(list, TranslateFromMetric(metric))
+	}
+	return list
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/rendering"
+)
+
+// DashboardPath is where dashboard is served, metric pages are at '<DashboardPath>/{name}'
+const DashboardPath = "/dashboard"
+
+// dashboardChartPoints is max amount of points on metric page chart, history is downsampled to fit
+const dashboardChartPoints = 120
+
+// SetDashboardRenderer enables dashboard pages, they respond with 404 until it is set
+func (h *MetricsHandler) SetDashboardRenderer(renderer MetricsDashboardRenderer) {
+	h.dashboard = renderer
+}
+
+// Dashboard handles 'GET /dashboard?q=&refresh=' requests, metrics are grouped by collector or name prefix,
+// 'q' searches series keys (case-insensitive), 'refresh' is auto-refresh interval (duration or seconds, 0 is off)
+func (h *MetricsHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := logger.ContextFromRequest(r)
+	if h.dashboard == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	opts := rendering.DashboardOptions{
+		BasePath: DashboardPath,
+		Search:   r.URL.Query().Get("q"),
+	}
+	if refresh := r.URL.Query().Get("refresh"); refresh != "" {
+		var err error
+		if opts.Refresh, err = parseDuration(refresh); err != nil || opts.Refresh < 0 {
+			logger.New(ctx).Errorf("[metrics handler] received invalid refresh interval '%s'", refresh)
+			h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidQuery)
+			return
+		}
+	}
+
+	list, err := h.service.List(ctx)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when getting metrics: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
+		return
+	}
+
+	body, err := h.dashboard.RenderDashboard(list, opts)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when rendering dashboard: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringRenderingError)
+		return
+	}
+	h.HTML(ctx, w, body)
+}
+
+// MetricPage handles 'GET /dashboard/{name}?labels=&range=' requests, the page shows current value of the series
+// and its history within 'range' (duration or seconds, 1 hour by default), if repository keeps history
+func (h *MetricsHandler) MetricPage(w http.ResponseWriter, r *http.Request) {
+	ctx := logger.ContextFromRequest(r)
+	if h.dashboard == nil {
+		http.NotFound(w, r)
+		return
+	}
+	mName := h.params.URLParam(r, "name")
+
+	labels, err := parseLabelsQuery(r)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] received invalid labels: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidLabels)
+		return
+	}
+	opts := rendering.DashboardOptions{
+		BasePath: DashboardPath,
+		Range:    DefaultHistoryRange,
+	}
+	if historyRange := r.URL.Query().Get("range"); historyRange != "" {
+		if opts.Range, err = parseDuration(historyRange); err != nil || opts.Range <= 0 {
+			logger.New(ctx).Errorf("[metrics handler] received invalid history range '%s'", historyRange)
+			h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidTimeRange)
+			return
+		}
+	}
+
+	metric, found, err := h.service.Get(ctx, mName, labels)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when getting metric: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
+		return
+	}
+	if !found {
+		h.PlainText(ctx, w, http.StatusNotFound, ErrStringMetricNotFound)
+		return
+	}
+
+	// Current value is still useful if history cannot be read, so history error is shown on the page
+	now := time.Now()
+	samples, historyErr := h.service.History(ctx, mName, labels, domain.HistoryQuery{
+		From: now.Add(-opts.Range),
+		To:   now,
+		Step: opts.Range / dashboardChartPoints,
+	})
+	if historyErr != nil && !errors.Is(historyErr, domain.ErrHistoryNotSupported) {
+		// Details of database errors are not shown to visitors
+		logger.New(ctx).Errorf("[metrics handler] error when getting metric history: %s", historyErr.Error())
+		historyErr = errors.New(ErrStringDatabaseError)
+	}
+
+	body, err := h.dashboard.RenderMetricPage(metric, samples, historyErr, opts)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when rendering metric page: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringRenderingError)
+		return
+	}
+	h.HTML(ctx, w, body)
+}
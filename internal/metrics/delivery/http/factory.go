@@ -69,19 +69,22 @@ func (f *requestResponseFactory) BuildGetMetricResponse(
 	}
 }
 
-func (f *requestResponseFactory) populateGenericMetric(ctx context.Context, metric domain.Metric) domain.GenericMetric {
-	result := domain.GenericMetric{
-		ID:    metric.Name,
-		MType: metric.Type,
+func (f *requestResponseFactory) BuildListMetricsResponse(
+	ctx context.Context,
+	page domain.MetricsPage,
+) domain.ListMetricsResponse {
+	resp := domain.ListMetricsResponse{
+		Metrics:    make([]domain.GenericMetric, 0, len(page.Metrics)),
+		NextCursor: page.NextCursor,
 	}
-	switch metric.Type {
-	case domain.TypeCounter:
-		val := int64(metric.Counter)
-		result.Delta = &val
-	case domain.TypeGauge:
-		val := float64(metric.Gauge)
-		result.Value = &val
+	for _, metric := range page.Metrics {
+		resp.Metrics = append(resp.Metrics, f.populateGenericMetric(ctx, metric))
 	}
+	return resp
+}
+
+func (f *requestResponseFactory) populateGenericMetric(ctx context.Context, metric domain.Metric) domain.GenericMetric {
+	result := domain.NewGenericMetric(metric)
 	result.Hash = f.hasher.Hash(ctx, metric)
 	return result
 }
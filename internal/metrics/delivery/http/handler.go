@@ -2,46 +2,80 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/handlers"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/rendering"
 )
 
 const (
 	ErrStringInvalidJSON       = "invalid JSON"
 	ErrStringInvalidMetricType = "invalid metric type"
+	ErrStringInvalidValue      = "invalid metric value"
+	ErrStringInvalidTimeRange  = "invalid time range"
+	ErrStringInvalidLabels     = "invalid labels"
+	ErrStringInvalidPattern    = "invalid metric name pattern"
+	ErrStringInvalidQuery      = "invalid query"
 	ErrStringInvalidHash       = "invalid hash"
 	ErrStringMetricNotFound    = "metric not found"
+	ErrStringNoMetricsMatched  = "no metrics matched"
 	ErrStringRenderingError    = "rendering error"
+	ErrStringUnsupportedFormat = "unsupported format"
 	ErrStringDatabaseError     = "database error"
+	ErrStringHistoryNotKept    = "history is not kept by metrics storage"
+	ErrStringStreamUnavailable = "stream unavailable"
 )
 
+// DefaultHistoryRange is used when history is requested without 'from' parameter
+const DefaultHistoryRange = time.Hour
+
 type MetricsHandler struct {
 	*handlers.HTTPHandler
-	service  MetricsService
-	renderer MetricsRenderer
-	factory  MetricsRequestResponseFactory
-	hasher   MetricsHasher
+	service MetricsService
+	// formats of metrics list in order of preference, renderers are keyed by format name
+	formats    []rendering.Format
+	renderers  map[string]MetricsRenderer
+	exposition MetricsExpositionRenderer
+	dashboard  MetricsDashboardRenderer
+	factory    MetricsRequestResponseFactory
+	hasher     MetricsHasher
+	params     URLParamExtractor
 }
 
 func NewMetricsHandler(
 	service MetricsService,
 	renderer MetricsRenderer,
+	exposition MetricsExpositionRenderer,
 	factory MetricsRequestResponseFactory,
 	hasher MetricsHasher,
+	params URLParamExtractor,
 ) *MetricsHandler {
 	return &MetricsHandler{
 		HTTPHandler: &handlers.HTTPHandler{},
 		service:     service,
-		renderer:    renderer,
+		formats:     []rendering.Format{rendering.FormatHTML},
+		renderers:   map[string]MetricsRenderer{rendering.FormatHTML.Name: renderer},
+		exposition:  exposition,
 		factory:     factory,
 		hasher:      hasher,
+		params:      params,
+	}
+}
+
+// AddRenderer adds format of metrics list, HTML renderer passed to NewMetricsHandler stays the default one
+func (h *MetricsHandler) AddRenderer(format rendering.Format, renderer MetricsRenderer) {
+	if _, ok := h.renderers[format.Name]; !ok {
+		h.formats = append(h.formats, format)
 	}
+	h.renderers[format.Name] = renderer
 }
 
 func (h *MetricsHandler) Update(w http.ResponseWriter, r *http.Request) {
@@ -59,6 +93,11 @@ func (h *MetricsHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	metric := req.TranslateToMetric()
+	if err := metric.ValidateValue(); err != nil {
+		logger.New(ctx).Errorf("[metrics handler] received invalid metric value: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidValue)
+		return
+	}
 
 	// Validate hash
 	if req.Hash != "" && !h.hasher.Check(ctx, metric, req.Hash) {
@@ -68,6 +107,11 @@ func (h *MetricsHandler) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	updatedMetric, err := h.service.Update(ctx, metric)
+	if errors.Is(err, domain.ErrUpdateJournaled) {
+		// Update is accepted, but its resulting value is not known yet
+		h.PlainText(ctx, w, http.StatusAccepted, "")
+		return
+	}
 	if err != nil {
 		logger.New(ctx).Errorf("[metrics handler] error when updating metric: %s", err.Error())
 		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
@@ -94,6 +138,11 @@ func (h *MetricsHandler) UpdateBatch(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		metric := reqMetric.TranslateToMetric()
+		if err := metric.ValidateValue(); err != nil {
+			logger.New(ctx).Errorf("[metrics handler] received invalid value of metric %s: %s", reqMetric.ID, err.Error())
+			h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidValue)
+			return
+		}
 
 		// Validate hash
 		if reqMetric.Hash != "" && !h.hasher.Check(ctx, metric, reqMetric.Hash) {
@@ -105,6 +154,10 @@ func (h *MetricsHandler) UpdateBatch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	updatedMetrics, err := h.service.UpdateMany(ctx, metrics)
+	if errors.Is(err, domain.ErrUpdateJournaled) {
+		h.PlainText(ctx, w, http.StatusAccepted, "")
+		return
+	}
 	if err != nil {
 		logger.New(ctx).Errorf("[metrics handler] error when updating metric: %s", err.Error())
 		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
@@ -121,6 +174,68 @@ func (h *MetricsHandler) UpdateBatch(w http.ResponseWriter, r *http.Request) {
 	h.PlainText(ctx, w, http.StatusOK, string(body))
 }
 
+// UpdateFromURL handles legacy '/update/{type}/{name}/{value}' requests, responding with updated value in plain text
+// Labels can be set with optional '?labels=key1=value1,key2=value2' query parameter
+func (h *MetricsHandler) UpdateFromURL(w http.ResponseWriter, r *http.Request) {
+	ctx := logger.ContextFromRequest(r)
+	mType := h.params.URLParam(r, "type")
+	mName := h.params.URLParam(r, "name")
+	mValue := h.params.URLParam(r, "value")
+
+	labels, err := parseLabelsQuery(r)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] received invalid labels: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidLabels)
+		return
+	}
+
+	// Histograms cannot be expressed as a single value, so they are not supported by legacy requests
+	if !domain.IsValidMetricType(mType) || mType == domain.TypeHistogram {
+		logger.New(ctx).Errorf("[metrics handler] received invalid metric type '%s'", mType)
+		h.PlainText(ctx, w, http.StatusNotImplemented, ErrStringInvalidMetricType)
+		return
+	}
+	if mName == "" {
+		logger.New(ctx).Errorf("[metrics handler] received empty metric name")
+		h.PlainText(ctx, w, http.StatusNotFound, ErrStringMetricNotFound)
+		return
+	}
+
+	metric := domain.Metric{Name: mName, Type: mType, Labels: labels}
+	switch mType {
+	case domain.TypeCounter:
+		counter, err := strconv.ParseInt(mValue, 10, 64)
+		if err != nil {
+			logger.New(ctx).Errorf("[metrics handler] received invalid counter value '%s'", mValue)
+			h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidValue)
+			return
+		}
+		metric.Counter = domain.Counter(counter)
+	case domain.TypeGauge:
+		gauge, err := strconv.ParseFloat(mValue, 64)
+		if err != nil {
+			logger.New(ctx).Errorf("[metrics handler] received invalid gauge value '%s'", mValue)
+			h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidValue)
+			return
+		}
+		metric.Gauge = domain.Gauge(gauge)
+	}
+
+	updatedMetric, err := h.service.Update(ctx, metric)
+	if errors.Is(err, domain.ErrUpdateJournaled) {
+		// Update is accepted, but its resulting value is not known yet
+		h.PlainText(ctx, w, http.StatusAccepted, "")
+		return
+	}
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when updating metric: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
+		return
+	}
+
+	h.PlainText(ctx, w, http.StatusOK, updatedMetric.StringValue())
+}
+
 func (h *MetricsHandler) Get(w http.ResponseWriter, r *http.Request) {
 	ctx := logger.ContextFromRequest(r)
 	var req domain.GetMetricRequest
@@ -136,7 +251,7 @@ func (h *MetricsHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metric, found, err := h.service.Get(ctx, req.ID)
+	metric, found, err := h.service.Get(ctx, req.ID, domain.Labels(req.Labels).Copy())
 	if err != nil {
 		logger.New(ctx).Errorf("[metrics handler] error when getting metric: %s", err.Error())
 		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
@@ -151,8 +266,186 @@ func (h *MetricsHandler) Get(w http.ResponseWriter, r *http.Request) {
 	h.JSON(ctx, w, http.StatusOK, h.factory.BuildGetMetricResponse(ctx, metric))
 }
 
+// GetFromURL handles legacy '/value/{type}/{name}' requests, responding with metric value in plain text
+// Labels can be set with optional '?labels=key1=value1,key2=value2' query parameter
+func (h *MetricsHandler) GetFromURL(w http.ResponseWriter, r *http.Request) {
+	ctx := logger.ContextFromRequest(r)
+	mType := h.params.URLParam(r, "type")
+	mName := h.params.URLParam(r, "name")
+
+	labels, err := parseLabelsQuery(r)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] received invalid labels: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidLabels)
+		return
+	}
+
+	if !domain.IsValidMetricType(mType) {
+		logger.New(ctx).Errorf("[metrics handler] received invalid metric type '%s'", mType)
+		h.PlainText(ctx, w, http.StatusNotImplemented, ErrStringInvalidMetricType)
+		return
+	}
+
+	metric, found, err := h.service.Get(ctx, mName, labels)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when getting metric: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
+		return
+	}
+	if !found || metric.Type != mType {
+		logger.New(ctx).Errorf("[metrics handler] metric '%s/%s' not found", mType, mName)
+		h.PlainText(ctx, w, http.StatusNotFound, ErrStringMetricNotFound)
+		return
+	}
+
+	h.PlainText(ctx, w, http.StatusOK, metric.StringValue())
+}
+
+// History handles '/api/v1/metrics/{name}/history?from=&to=&step=&labels=' requests
+// 'from' and 'to' are RFC3339 timestamps or unix seconds, 'step' is a duration (e.g. '1m') or seconds,
+// 'labels' select the series in 'key1=value1,key2=value2' form
+func (h *MetricsHandler) History(w http.ResponseWriter, r *http.Request) {
+	ctx := logger.ContextFromRequest(r)
+	mName := h.params.URLParam(r, "name")
+
+	labels, err := parseLabelsQuery(r)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] received invalid labels: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidLabels)
+		return
+	}
+
+	query, err := parseHistoryQuery(r)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] received invalid history query: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidTimeRange)
+		return
+	}
+
+	metric, found, err := h.service.Get(ctx, mName, labels)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when getting metric: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
+		return
+	}
+	if !found {
+		logger.New(ctx).Errorf("[metrics handler] metric '%s' not found", domain.SeriesKey(mName, labels))
+		h.PlainText(ctx, w, http.StatusNotFound, ErrStringMetricNotFound)
+		return
+	}
+
+	samples, err := h.service.History(ctx, mName, labels, query)
+	if errors.Is(err, domain.ErrHistoryNotSupported) {
+		logger.New(ctx).Errorf("[metrics handler] cannot get metric history: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusNotImplemented, ErrStringHistoryNotKept)
+		return
+	}
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when getting metric history: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
+		return
+	}
+
+	resp := domain.GetMetricHistoryResponse{
+		ID:     metric.Name,
+		MType:  metric.Type,
+		Labels: metric.Labels.Copy(),
+		Points: make([]domain.HistoryPoint, 0, len(samples)),
+	}
+	for _, sample := range samples {
+		resp.Points = append(resp.Points, domain.HistoryPoint{Timestamp: sample.Timestamp, Value: sample.Value})
+	}
+	h.JSON(ctx, w, http.StatusOK, resp)
+}
+
+// Query handles 'GET /api/v1/metrics' requests, metrics are filtered by query parameters:
+// 'name' (exact, repeatable), 'type' (repeatable or comma-separated), 'prefix', 'glob', 'regex' (of the whole name)
+// and 'label' matchers (repeatable, e.g. 'host=a', 'env!=prod', 'dc=~eu-.*', 'dc!~us-.*'),
+// 'sort' is one of 'name', 'type', 'value' (with '-' prefix for descending order),
+// 'limit' is page size and 'cursor' is 'next_cursor' of the previous page
+func (h *MetricsHandler) Query(w http.ResponseWriter, r *http.Request) {
+	ctx := logger.ContextFromRequest(r)
+
+	query, err := parseMetricsQuery(r)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] received invalid metrics query: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidQuery)
+		return
+	}
+
+	page, err := h.service.Query(ctx, query)
+	if errors.Is(err, domain.ErrInvalidQuery) {
+		logger.New(ctx).Errorf("[metrics handler] received invalid metrics query: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidQuery)
+		return
+	}
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when querying metrics: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
+		return
+	}
+	h.JSON(ctx, w, http.StatusOK, h.factory.BuildListMetricsResponse(ctx, page))
+}
+
+// Delete handles 'DELETE /api/v1/metrics?name=<glob>' requests, all series of matching metrics are removed
+func (h *MetricsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := logger.ContextFromRequest(r)
+	pattern := r.URL.Query().Get("name")
+
+	deleted, err := h.service.Delete(ctx, pattern)
+	if !h.checkPatternResult(w, r, deleted, err) {
+		return
+	}
+	logger.New(ctx).Infof("[metrics handler] deleted %d series matching '%s'", deleted, pattern)
+	h.JSON(ctx, w, http.StatusOK, domain.DeleteMetricsResponse{Deleted: deleted})
+}
+
+// Reset handles 'POST /api/v1/metrics/reset?name=<glob>' requests, matching counters are set to 0
+func (h *MetricsHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	ctx := logger.ContextFromRequest(r)
+	pattern := r.URL.Query().Get("name")
+
+	reset, err := h.service.ResetCounters(ctx, pattern)
+	if !h.checkPatternResult(w, r, reset, err) {
+		return
+	}
+	logger.New(ctx).Infof("[metrics handler] reset %d counters matching '%s'", reset, pattern)
+	h.JSON(ctx, w, http.StatusOK, domain.ResetMetricsResponse{Reset: reset})
+}
+
+// checkPatternResult responds with an error if operation on metrics matching a pattern failed or matched nothing
+func (h *MetricsHandler) checkPatternResult(w http.ResponseWriter, r *http.Request, affected int, err error) bool {
+	ctx := logger.ContextFromRequest(r)
+	switch {
+	case errors.Is(err, domain.ErrInvalidPattern):
+		logger.New(ctx).Errorf("[metrics handler] received invalid pattern: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidPattern)
+		return false
+	case err != nil:
+		logger.New(ctx).Errorf("[metrics handler] error when changing metrics: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
+		return false
+	case affected == 0:
+		h.PlainText(ctx, w, http.StatusNotFound, ErrStringNoMetricsMatched)
+		return false
+	}
+	return true
+}
+
+// List renders all metrics in format chosen by '?format=' parameter (e.g. 'json', 'csv', 'text', 'prometheus')
+// or negotiated by Accept header, HTML is rendered by default
 func (h *MetricsHandler) List(w http.ResponseWriter, r *http.Request) {
 	ctx := logger.ContextFromRequest(r)
+	w.Header().Add("Vary", "Accept")
+
+	format, ok := negotiateFormat(r, h.formats)
+	if !ok {
+		logger.New(ctx).Errorf("[metrics handler] no format matches format '%s', accept '%s'",
+			r.URL.Query().Get("format"), r.Header.Get("Accept"))
+		h.PlainText(ctx, w, http.StatusNotAcceptable, ErrStringUnsupportedFormat)
+		return
+	}
+
 	list, err := h.service.List(ctx)
 	if err != nil {
 		logger.New(ctx).Errorf("[metrics handler] error when getting metrics: %s", err.Error())
@@ -160,17 +453,168 @@ func (h *MetricsHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Sort metrics by name
-	sort.Slice(list, func(i, j int) bool {
-		return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
-	})
+	sortByName(list)
 
-	html, err := h.renderer.RenderList(list)
+	body, err := h.renderers[format.Name].RenderList(list)
 	if err != nil {
-		logger.New(ctx).Errorf(fmt.Sprintf("[metrics handler] error when rendering html: %s", err.Error()))
+		logger.New(ctx).Errorf("[metrics handler] error when rendering %s: %s", format.Name, err.Error())
 		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringRenderingError)
 		return
 	}
 
-	h.HTML(ctx, w, html)
+	h.Raw(ctx, w, http.StatusOK, body, format.ContentType)
+}
+
+// Exposition renders all metrics for Prometheus scraping,
+// OpenMetrics format is used if client negotiates it with Accept header
+func (h *MetricsHandler) Exposition(w http.ResponseWriter, r *http.Request) {
+	ctx := logger.ContextFromRequest(r)
+	list, err := h.service.List(ctx)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when getting metrics: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringDatabaseError)
+		return
+	}
+
+	sortByName(list)
+
+	openMetrics := acceptsOpenMetrics(r.Header.Get("Accept"))
+	body, err := h.exposition.RenderExposition(list, openMetrics)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] error when rendering exposition: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringRenderingError)
+		return
+	}
+
+	contentType := rendering.ContentTypePrometheus
+	if openMetrics {
+		contentType = rendering.ContentTypeOpenMetrics
+	}
+	h.Raw(ctx, w, http.StatusOK, body, contentType)
+}
+
+// sortByName sorts metrics by name, series of the same metric are sorted by labels
+func sortByName(list []domain.Metric) {
+	sort.Slice(list, func(i, j int) bool {
+		nameI, nameJ := strings.ToLower(list[i].Name), strings.ToLower(list[j].Name)
+		if nameI != nameJ {
+			return nameI < nameJ
+		}
+		return list[i].Labels.String() < list[j].Labels.String()
+	})
+}
+
+// acceptsOpenMetrics checks if Accept header lists OpenMetrics media type (and does not reject it with q=0)
+func acceptsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if strings.TrimSpace(params[0]) != "application/openmetrics-text" {
+			continue
+		}
+		for _, param := range params[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				if weight, err := strconv.ParseFloat(strings.TrimPrefix(q, "q="), 64); err == nil && weight == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func parseLabelsQuery(r *http.Request) (domain.Labels, error) {
+	return domain.ParseLabels(r.URL.Query().Get("labels"))
+}
+
+func parseMetricsQuery(r *http.Request) (domain.MetricsQuery, error) {
+	values := r.URL.Query()
+	query := domain.MetricsQuery{
+		Sort:   values.Get("sort"),
+		Cursor: values.Get("cursor"),
+	}
+	var err error
+	if query.Filter, err = parseMetricsFilter(r); err != nil {
+		return query, err
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit <= 0 {
+			return query, fmt.Errorf("invalid limit '%s'", limit)
+		}
+	}
+	return query, nil
+}
+
+// parseMetricsFilter parses 'name', 'type', 'prefix', 'glob', 'regex' and 'label' query parameters
+func parseMetricsFilter(r *http.Request) (domain.MetricsFilter, error) {
+	values := r.URL.Query()
+	filter := domain.MetricsFilter{
+		Names:  values["name"],
+		Prefix: values.Get("prefix"),
+		Glob:   values.Get("glob"),
+		Regex:  values.Get("regex"),
+	}
+	for _, types := range values["type"] {
+		for _, mType := range strings.Split(types, ",") {
+			if !domain.IsValidMetricType(mType) {
+				return filter, fmt.Errorf("invalid metric type '%s'", mType)
+			}
+			filter.Types = append(filter.Types, mType)
+		}
+	}
+	for _, label := range values["label"] {
+		matcher, err := domain.ParseLabelMatcher(label)
+		if err != nil {
+			return filter, err
+		}
+		filter.Labels = append(filter.Labels, matcher)
+	}
+	return filter, nil
+}
+
+func parseHistoryQuery(r *http.Request) (domain.HistoryQuery, error) {
+	var err error
+	query := domain.HistoryQuery{To: time.Now()}
+	values := r.URL.Query()
+
+	if to := values.Get("to"); to != "" {
+		if query.To, err = parseTime(to); err != nil {
+			return query, err
+		}
+	}
+	query.From = query.To.Add(-DefaultHistoryRange)
+	if from := values.Get("from"); from != "" {
+		if query.From, err = parseTime(from); err != nil {
+			return query, err
+		}
+	}
+	if step := values.Get("step"); step != "" {
+		if query.Step, err = parseDuration(step); err != nil {
+			return query, err
+		}
+	}
+
+	if query.From.After(query.To) {
+		return query, fmt.Errorf("'from' %s is after 'to' %s", query.From, query.To)
+	}
+	if query.Step < 0 {
+		return query, fmt.Errorf("'step' %s is negative", query.Step)
+	}
+	return query, nil
+}
+
+// parseTime parses either RFC3339 timestamp or unix seconds (possibly fractional)
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseDuration parses either Go duration (e.g. '1m30s') or seconds (possibly fractional)
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
 }
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
@@ -9,16 +10,20 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"eridiumdev/yandex-praktikum-go-devops/config"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/middleware"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/routing"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/backup"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/hash"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/rendering"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/repository"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/service"
 )
@@ -41,6 +46,22 @@ func getDummyRenderer() *dummyRenderer {
 	return &dummyRenderer{}
 }
 
+type dummyDashboardRenderer struct{}
+
+func (e *dummyDashboardRenderer) RenderDashboard(list []domain.Metric, opts rendering.DashboardOptions) ([]byte, error) {
+	return []byte(fmt.Sprintf("<dashboard search=%q refresh=%s metrics=%d>", opts.Search, opts.Refresh, len(list))), nil
+}
+
+func (e *dummyDashboardRenderer) RenderMetricPage(
+	metric domain.Metric,
+	samples []domain.Sample,
+	historyErr error,
+	opts rendering.DashboardOptions,
+) ([]byte, error) {
+	return []byte(fmt.Sprintf("<metric %s=%s range=%s samples=%d error=%v>",
+		metric.Key(), metric.StringValue(), opts.Range, len(samples), historyErr)), nil
+}
+
 func getDummyHasher() MetricsHasher {
 	return hash.NewHasher("s3cr3t-k3y")
 }
@@ -61,6 +82,8 @@ func getDummyBackuper() service.MetricsBackuper {
 	return &backup.Mock{}
 }
 
+const dummyAdminToken = "4dm1n-t0k3n"
+
 type Want struct {
 	code        int
 	response    string
@@ -68,11 +91,12 @@ type Want struct {
 }
 
 type TestCase struct {
-	name   string
-	url    string
-	method string
-	body   string
-	want   Want
+	name    string
+	url     string
+	method  string
+	body    string
+	headers map[string]string
+	want    Want
 }
 
 func init() {
@@ -83,20 +107,38 @@ func init() {
 }
 
 func runTests(t *testing.T, tt TestCase) {
+	runTestsWithRepo(t, getDummyRepo(), tt)
+}
+
+func runTestsWithRepo(t *testing.T, repo service.MetricsRepository, tt TestCase) {
 	ctx := context.Background()
 	router := routing.NewChiRouter()
-	repo := getDummyRepo()
 	backuper := getDummyBackuper()
 
 	svc, _ := service.NewMetricsService(ctx, repo, backuper, config.BackupConfig{
 		Interval:  0,
 		DoRestore: false,
-	})
+	}, config.RetentionConfig{})
 
-	h := NewMetricsHandler(svc, getDummyRenderer(), getDummyFactory(), getDummyHasher())
+	h := NewMetricsHandler(svc, getDummyRenderer(), rendering.NewPrometheusEngine(),
+		getDummyFactory(), getDummyHasher(), router)
+	h.AddRenderer(rendering.FormatJSON, rendering.NewJSONEngine())
+	h.AddRenderer(rendering.FormatCSV, rendering.NewCSVEngine())
+	h.AddRenderer(rendering.FormatText, rendering.NewTextEngine())
+	h.AddRenderer(rendering.FormatPrometheus, rendering.NewPrometheusEngine())
+	h.SetDashboardRenderer(&dummyDashboardRenderer{})
 	router.AddRoute(http.MethodGet, "/", h.List)
+	router.AddRoute(http.MethodGet, "/metrics", h.Exposition)
 	router.AddRoute(http.MethodPost, "/value", h.Get)
 	router.AddRoute(http.MethodPost, "/update", h.Update)
+	router.AddRoute(http.MethodGet, "/value/{type}/{name}", h.GetFromURL)
+	router.AddRoute(http.MethodPost, "/update/{type}/{name}/{value}", h.UpdateFromURL)
+	router.AddRoute(http.MethodGet, "/api/v1/metrics", h.Query)
+	router.AddRoute(http.MethodGet, "/api/v1/metrics/{name}/history", h.History)
+	router.AddRoute(http.MethodGet, DashboardPath, h.Dashboard)
+	router.AddRoute(http.MethodGet, DashboardPath+"/{name}", h.MetricPage)
+	router.AddRoute(http.MethodDelete, "/api/v1/metrics", h.Delete, middleware.RequireToken(dummyAdminToken))
+	router.AddRoute(http.MethodPost, "/api/v1/metrics/reset", h.Reset, middleware.RequireToken(dummyAdminToken))
 
 	s := httptest.NewServer(router.Mux)
 	defer s.Close()
@@ -106,6 +148,9 @@ func runTests(t *testing.T, tt TestCase) {
 
 	req, err := http.NewRequest(tt.method, s.URL+tt.url, &buffer)
 	require.NoError(t, err)
+	for key, value := range tt.headers {
+		req.Header.Set(key, value)
+	}
 
 	resp, doErr := http.DefaultClient.Do(req)
 	require.NoError(t, doErr)
@@ -170,6 +215,28 @@ func TestUpdate(t *testing.T) {
 				contentType: "application/json; charset=utf-8",
 			},
 		},
+		{
+			name:   "positive test: labelled counter is a separate series",
+			url:    "/update",
+			method: http.MethodPost,
+			body:   `{"id":"PollCount","type":"counter","delta":5,"labels":{"host":"a"}}`,
+			want: Want{
+				code:        http.StatusOK,
+				response:    `{"id":"PollCount","type":"counter","delta":5,"hash":"cd13484fb908905362af62d401f3ad66a5e97e9cb02a8184c2f68e653f798c53","labels":{"host":"a"}}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: histogram",
+			url:    "/update",
+			method: http.MethodPost,
+			body:   `{"id":"PauseNs","type":"histogram","histogram":{"bounds":[1],"counts":[1,1],"sum":2.5,"count":2}}`,
+			want: Want{
+				code:        http.StatusOK,
+				response:    `{"id":"PauseNs","type":"histogram","hash":"a0c29845a284b5226d6a4b9152fec27fd2480c6e3060715cf528888565d0219a","histogram":{"bounds":[1],"counts":[1,1],"sum":2.5,"count":2}}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
 		{
 			name:   "negative test: bad hash",
 			url:    "/update",
@@ -214,6 +281,17 @@ func TestUpdate(t *testing.T) {
 				contentType: "text/plain; charset=utf-8",
 			},
 		},
+		{
+			name:   "negative test: inconsistent histogram",
+			url:    "/update",
+			method: http.MethodPost,
+			body:   `{"id":"PauseNs","type":"histogram","histogram":{"bounds":[1],"counts":[1],"sum":2.5,"count":2}}`,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidValue,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
 		{
 			name:   "negative test: bad metric type",
 			url:    "/update",
@@ -244,6 +322,44 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+// journalingRepo accepts increments without writing them, like write-behind repo while database is unavailable
+type journalingRepo struct {
+	service.MetricsRepository
+}
+
+func (r journalingRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	return nil, domain.ErrUpdateJournaled
+}
+
+func TestUpdateJournaled(t *testing.T) {
+	tests := []TestCase{
+		{
+			name:   "JSON update is accepted without value",
+			url:    "/update",
+			method: http.MethodPost,
+			body:   `{"id":"PollCount","type":"counter","delta":5}`,
+			want: Want{
+				code:        http.StatusAccepted,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "URL update is accepted without value",
+			url:    "/update/counter/PollCount/5",
+			method: http.MethodPost,
+			want: Want{
+				code:        http.StatusAccepted,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runTestsWithRepo(t, journalingRepo{getDummyRepo()}, tt)
+		})
+	}
+}
+
 func TestGet(t *testing.T) {
 	tests := []TestCase{
 		{
@@ -309,6 +425,176 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestUpdateFromURL(t *testing.T) {
+	tests := []TestCase{
+		{
+			name:   "positive test: counter",
+			url:    "/update/counter/PollCount/5",
+			method: http.MethodPost,
+			want: Want{
+				code:        http.StatusOK,
+				response:    "10",
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: new counter",
+			url:    "/update/counter/testCounter/100",
+			method: http.MethodPost,
+			want: Want{
+				code:        http.StatusOK,
+				response:    "100",
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: gauge",
+			url:    "/update/gauge/Alloc/10.20",
+			method: http.MethodPost,
+			want: Want{
+				code:        http.StatusOK,
+				response:    "10.2",
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: bad counter value",
+			url:    "/update/counter/PollCount/abcd",
+			method: http.MethodPost,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidValue,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: float counter value",
+			url:    "/update/counter/PollCount/1.5",
+			method: http.MethodPost,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidValue,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: bad gauge value",
+			url:    "/update/gauge/Alloc/none",
+			method: http.MethodPost,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidValue,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: bad metric type",
+			url:    "/update/unknown/PollCount/5",
+			method: http.MethodPost,
+			want: Want{
+				code:        http.StatusNotImplemented,
+				response:    ErrStringInvalidMetricType,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: missing value",
+			url:    "/update/counter/PollCount",
+			method: http.MethodPost,
+			want: Want{
+				code:        http.StatusNotFound,
+				response:    "404 page not found",
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runTests(t, tt)
+		})
+	}
+}
+
+func TestGetFromURL(t *testing.T) {
+	tests := []TestCase{
+		{
+			name:   "positive test: counter",
+			url:    "/value/counter/PollCount",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusOK,
+				response:    "5",
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: gauge",
+			url:    "/value/gauge/Alloc",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusOK,
+				response:    "10.123",
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: unknown labelled series",
+			url:    "/value/counter/PollCount?labels=host=a",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusNotFound,
+				response:    ErrStringMetricNotFound,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: invalid labels",
+			url:    "/value/counter/PollCount?labels=host",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidLabels,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: bad metric type",
+			url:    "/value/unknown/PollCount",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusNotImplemented,
+				response:    ErrStringInvalidMetricType,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: wrong metric type",
+			url:    "/value/gauge/PollCount",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusNotFound,
+				response:    ErrStringMetricNotFound,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: metric not found",
+			url:    "/value/counter/abcd",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusNotFound,
+				response:    ErrStringMetricNotFound,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runTests(t, tt)
+		})
+	}
+}
+
 func TestList(t *testing.T) {
 	tests := []TestCase{
 		{
@@ -322,6 +608,95 @@ func TestList(t *testing.T) {
 				contentType: "text/html; charset=utf-8",
 			},
 		},
+		{
+			name:   "positive test: browser gets html",
+			url:    "/",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Accept": "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			},
+			want: Want{
+				code:        http.StatusOK,
+				response:    "<html>Alloc : 10.123 | PollCount : 5</html>",
+				contentType: "text/html; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: json by accept",
+			url:    "/",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Accept": "text/html;q=0.5, application/json",
+			},
+			want: Want{
+				code: http.StatusOK,
+				response: `{"metrics":[{"id":"Alloc","type":"gauge","value":10.123},` +
+					`{"id":"PollCount","type":"counter","delta":5}]}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: plain text by accept",
+			url:    "/",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Accept": "text/plain",
+			},
+			want: Want{
+				code:        http.StatusOK,
+				response:    "Alloc 10.123\nPollCount 5",
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: prometheus by accept",
+			url:    "/",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Accept": "text/plain;version=0.0.4",
+			},
+			want: Want{
+				code:        http.StatusOK,
+				response:    "# TYPE Alloc gauge\nAlloc 10.123\n# TYPE PollCount counter\nPollCount 5",
+				contentType: rendering.ContentTypePrometheus,
+			},
+		},
+		{
+			name:   "positive test: csv by parameter, accept is ignored",
+			url:    "/?format=csv",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Accept": "text/html",
+			},
+			want: Want{
+				code:        http.StatusOK,
+				response:    "name,type,labels,value\nAlloc,gauge,,10.123\nPollCount,counter,,5",
+				contentType: "text/csv; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: unknown format",
+			url:    "/?format=xml",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusNotAcceptable,
+				response:    ErrStringUnsupportedFormat,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: nothing acceptable",
+			url:    "/",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Accept": "application/xml, text/html;q=0",
+			},
+			want: Want{
+				code:        http.StatusNotAcceptable,
+				response:    ErrStringUnsupportedFormat,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -329,3 +704,484 @@ func TestList(t *testing.T) {
 		})
 	}
 }
+
+func TestExposition(t *testing.T) {
+	tests := []TestCase{
+		{
+			name:   "prometheus text format",
+			url:    "/metrics",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Accept": "text/plain;version=0.0.4;q=0.5,*/*;q=0.1",
+			},
+			want: Want{
+				code:        http.StatusOK,
+				response:    "# TYPE Alloc gauge\nAlloc 10.123\n# TYPE PollCount counter\nPollCount 5",
+				contentType: rendering.ContentTypePrometheus,
+			},
+		},
+		{
+			name:   "openmetrics text format",
+			url:    "/metrics",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Accept": "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5",
+			},
+			want: Want{
+				code:        http.StatusOK,
+				response:    "# TYPE Alloc gauge\nAlloc 10.123\n# TYPE PollCount counter\nPollCount_total 5\n# EOF",
+				contentType: rendering.ContentTypeOpenMetrics,
+			},
+		},
+		{
+			name:   "openmetrics rejected",
+			url:    "/metrics",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"Accept": "application/openmetrics-text;q=0,text/plain",
+			},
+			want: Want{
+				code:        http.StatusOK,
+				response:    "# TYPE Alloc gauge\nAlloc 10.123\n# TYPE PollCount counter\nPollCount 5",
+				contentType: rendering.ContentTypePrometheus,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runTests(t, tt)
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	tests := []TestCase{
+		{
+			name: "positive test: counter, one bucket since epoch",
+			// All samples fall into the bucket starting at 1000000000 (2001-09-09T01:46:40Z)
+			url:    "/api/v1/metrics/PollCount/history?from=0&step=1000000000",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusOK,
+				response:    `{"id":"PollCount","type":"counter","points":[{"ts":"2001-09-09T01:46:40Z","value":5}]}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: gauge, empty range",
+			url:    "/api/v1/metrics/Alloc/history?from=2000-01-01T00:00:00Z&to=2000-01-02T00:00:00Z",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusOK,
+				response:    `{"id":"Alloc","type":"gauge","points":[]}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: from after to",
+			url:    "/api/v1/metrics/Alloc/history?from=2000-01-02T00:00:00Z&to=2000-01-01T00:00:00Z",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidTimeRange,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: bad step",
+			url:    "/api/v1/metrics/Alloc/history?step=often",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidTimeRange,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: metric not found",
+			url:    "/api/v1/metrics/abcd/history",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusNotFound,
+				response:    ErrStringMetricNotFound,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runTests(t, tt)
+		})
+	}
+
+	// Repository without history (e.g. Redis) only keeps the latest values
+	runTestsWithRepo(t, struct{ service.MetricsRepository }{getDummyRepo()}, TestCase{
+		name:   "negative test: history is not kept",
+		url:    "/api/v1/metrics/Alloc/history",
+		method: http.MethodGet,
+		want: Want{
+			code:        http.StatusNotImplemented,
+			response:    ErrStringHistoryNotKept,
+			contentType: "text/plain; charset=utf-8",
+		},
+	})
+}
+
+func TestQuery(t *testing.T) {
+	// Cursor of the first page, sorted by name
+	cursor := "eyJzIjoibmFtZSIsIm4iOiJBbGxvYyIsImsiOiJBbGxvYyIsInQiOiJnYXVnZSIsInYiOjEwLjEyM30"
+	tests := []TestCase{
+		{
+			name:   "positive test: first page",
+			url:    "/api/v1/metrics?limit=1",
+			method: http.MethodGet,
+			want: Want{
+				code: http.StatusOK,
+				response: `{"metrics":[{"id":"Alloc","type":"gauge","value":10.123,` +
+					`"hash":"7e9e3da35d6b5e7bd5b2458f14fd54f566cfeb0e5b192cc220a08cf0b42f14a3"}],` +
+					`"next_cursor":"` + cursor + `"}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: next page",
+			url:    "/api/v1/metrics?limit=1&cursor=" + cursor,
+			method: http.MethodGet,
+			want: Want{
+				code: http.StatusOK,
+				response: `{"metrics":[{"id":"PollCount","type":"counter","delta":5,` +
+					`"hash":"7148ff92910a879bba42647839901cdd4f9c68f952657e36ead4e894511d82af"}]}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: filtered",
+			url:    "/api/v1/metrics?type=gauge,histogram&regex=All.*&label=host!=a",
+			method: http.MethodGet,
+			want: Want{
+				code: http.StatusOK,
+				response: `{"metrics":[{"id":"Alloc","type":"gauge","value":10.123,` +
+					`"hash":"7e9e3da35d6b5e7bd5b2458f14fd54f566cfeb0e5b192cc220a08cf0b42f14a3"}]}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: nothing matched",
+			url:    "/api/v1/metrics?prefix=abcd",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusOK,
+				response:    `{"metrics":[]}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: invalid type",
+			url:    "/api/v1/metrics?type=abcd",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidQuery,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: invalid label matcher",
+			url:    "/api/v1/metrics?label=host",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidQuery,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: invalid sort",
+			url:    "/api/v1/metrics?sort=size",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidQuery,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runTests(t, tt)
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	authorized := map[string]string{"Authorization": "Bearer " + dummyAdminToken}
+	tests := []TestCase{
+		{
+			name:    "positive test: by glob",
+			url:     "/api/v1/metrics?name=Poll*",
+			method:  http.MethodDelete,
+			headers: authorized,
+			want: Want{
+				code:        http.StatusOK,
+				response:    `{"deleted":1}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
+		{
+			name:    "negative test: nothing matched",
+			url:     "/api/v1/metrics?name=abcd",
+			method:  http.MethodDelete,
+			headers: authorized,
+			want: Want{
+				code:        http.StatusNotFound,
+				response:    ErrStringNoMetricsMatched,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:    "negative test: invalid pattern",
+			url:     "/api/v1/metrics?name=%5B",
+			method:  http.MethodDelete,
+			headers: authorized,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidPattern,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:    "negative test: invalid token",
+			url:     "/api/v1/metrics?name=Alloc",
+			method:  http.MethodDelete,
+			headers: map[string]string{"Authorization": "Bearer abcd"},
+			want: Want{
+				code:        http.StatusUnauthorized,
+				response:    http.StatusText(http.StatusUnauthorized),
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: no token",
+			url:    "/api/v1/metrics?name=Alloc",
+			method: http.MethodDelete,
+			want: Want{
+				code:        http.StatusUnauthorized,
+				response:    http.StatusText(http.StatusUnauthorized),
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runTests(t, tt)
+		})
+	}
+}
+
+func TestReset(t *testing.T) {
+	authorized := map[string]string{"Authorization": "Bearer " + dummyAdminToken}
+	tests := []TestCase{
+		{
+			name:    "positive test: all counters",
+			url:     "/api/v1/metrics/reset?name=*",
+			method:  http.MethodPost,
+			headers: authorized,
+			want: Want{
+				code:        http.StatusOK,
+				response:    `{"reset":1}`,
+				contentType: "application/json; charset=utf-8",
+			},
+		},
+		{
+			name:    "negative test: gauges are not reset",
+			url:     "/api/v1/metrics/reset?name=Alloc",
+			method:  http.MethodPost,
+			headers: authorized,
+			want: Want{
+				code:        http.StatusNotFound,
+				response:    ErrStringNoMetricsMatched,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: no token",
+			url:    "/api/v1/metrics/reset?name=*",
+			method: http.MethodPost,
+			want: Want{
+				code:        http.StatusUnauthorized,
+				response:    http.StatusText(http.StatusUnauthorized),
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runTests(t, tt)
+		})
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	tests := []TestCase{
+		{
+			name:   "positive test: dashboard",
+			url:    "/dashboard?q=alloc&refresh=10",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusOK,
+				response:    `<dashboard search="alloc" refresh=10s metrics=2>`,
+				contentType: "text/html; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: invalid refresh interval",
+			url:    "/dashboard?refresh=-1",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidQuery,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: metric page with default range",
+			url:    "/dashboard/Alloc",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusOK,
+				response:    `<metric Alloc=10.123 range=1h0m0s samples=1 error=<nil>>`,
+				contentType: "text/html; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test: metric page with range",
+			url:    "/dashboard/PollCount?range=15m",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusOK,
+				response:    `<metric PollCount=5 range=15m0s samples=1 error=<nil>>`,
+				contentType: "text/html; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: metric page with invalid range",
+			url:    "/dashboard/PollCount?range=soon",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusBadRequest,
+				response:    ErrStringInvalidTimeRange,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "negative test: metric page of unknown series",
+			url:    "/dashboard/Alloc?labels=host=a",
+			method: http.MethodGet,
+			want: Want{
+				code:        http.StatusNotFound,
+				response:    ErrStringMetricNotFound,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runTests(t, tt)
+		})
+	}
+}
+
+func startStreamServer(t *testing.T, cfg config.StreamConfig) *httptest.Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	router := routing.NewChiRouter()
+	svc, err := service.NewMetricsService(ctx, getDummyRepo(), nil, config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+	svc.EnableStreaming(ctx, cfg)
+
+	h := NewMetricsHandler(svc, getDummyRenderer(), rendering.NewPrometheusEngine(),
+		getDummyFactory(), getDummyHasher(), router)
+	router.AddRoute(http.MethodPost, "/update/{type}/{name}/{value}", h.UpdateFromURL)
+	router.AddRoute(http.MethodGet, "/api/v1/stream", h.Stream, middleware.BasicSet...)
+
+	s := httptest.NewServer(router.Mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestStreamEvents(t *testing.T) {
+	s := startStreamServer(t, config.StreamConfig{BufferSize: 10, Heartbeat: time.Hour})
+
+	resp, err := http.Get(s.URL + "/api/v1/stream?type=bogus")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(s.URL + "/api/v1/stream?name=PollCount")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	for _, url := range []string{"/update/gauge/Alloc/1", "/update/counter/PollCount/2"} {
+		updateResp, updateErr := http.Post(s.URL+url, "text/plain", nil)
+		require.NoError(t, updateErr)
+		updateResp.Body.Close()
+	}
+
+	// Alloc is filtered out, so the first event is PollCount update
+	reader := bufio.NewReader(resp.Body)
+	event, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event: update\n", event)
+	data, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"metrics":[{"id":"PollCount","type":"counter","delta":7,`+
+		`"hash":"`+getDummyHasher().Hash(context.Background(), domain.NewCounter(domain.PollCount, 7))+`"}]}`,
+		strings.TrimPrefix(data, "data: "))
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	s := startStreamServer(t, config.StreamConfig{BufferSize: 10, Heartbeat: 10 * time.Millisecond})
+
+	pinged := make(chan struct{}, 1)
+	streamURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/api/v1/stream?type=gauge"
+	conn, resp, err := websocket.DefaultDialer.Dial(streamURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	defer resp.Body.Close()
+	conn.SetPingHandler(func(string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+
+	for _, url := range []string{"/update/counter/PollCount/2", "/update/gauge/Alloc/1.5"} {
+		updateResp, updateErr := http.Post(s.URL+url, "text/plain", nil)
+		require.NoError(t, updateErr)
+		updateResp.Body.Close()
+	}
+
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"metrics":[{"id":"Alloc","type":"gauge","value":1.5,`+
+		`"hash":"`+getDummyHasher().Hash(context.Background(), domain.NewGauge(domain.Alloc, 1.5))+`"}]}`,
+		string(message))
+
+	// Pings are handled while reading
+	go func() {
+		for {
+			if _, _, readErr := conn.ReadMessage(); readErr != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-pinged:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat ping was not sent")
+	}
+}
@@ -2,23 +2,58 @@ package http
 
 import (
 	"context"
+	"net/http"
 
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/rendering"
 )
 
 // These are the interfaces required for handling metrics requests
 
-// MetricsRenderer should apply metrics to some template, resulting in renderable output
+// MetricsRenderer should render list of metrics in some format, e.g. apply them to HTML template or encode as CSV
 type MetricsRenderer interface {
 	RenderList(list []domain.Metric) ([]byte, error)
 }
 
+// MetricsExpositionRenderer should render metrics in a format suitable for scraping by Prometheus
+type MetricsExpositionRenderer interface {
+	RenderExposition(list []domain.Metric, openMetrics bool) ([]byte, error)
+}
+
+// MetricsDashboardRenderer should render dashboard of all metrics and page of a single metric with its history,
+// history error is shown on the page instead of the history, if it is set
+type MetricsDashboardRenderer interface {
+	RenderDashboard(list []domain.Metric, opts rendering.DashboardOptions) ([]byte, error)
+	RenderMetricPage(
+		metric domain.Metric,
+		samples []domain.Sample,
+		historyErr error,
+		opts rendering.DashboardOptions,
+	) ([]byte, error)
+}
+
 // MetricsService should be able to perform common operations on metrics, such as updating and retrieving
 type MetricsService interface {
 	Update(ctx context.Context, metric domain.Metric) (domain.Metric, error)
 	UpdateMany(ctx context.Context, metrics []domain.Metric) ([]domain.Metric, error)
-	Get(ctx context.Context, name string) (m domain.Metric, found bool, err error)
+	Get(ctx context.Context, name string, labels domain.Labels) (m domain.Metric, found bool, err error)
 	List(ctx context.Context) ([]domain.Metric, error)
+	Query(ctx context.Context, query domain.MetricsQuery) (domain.MetricsPage, error)
+	History(ctx context.Context, name string, labels domain.Labels, query domain.HistoryQuery) ([]domain.Sample, error)
+	Delete(ctx context.Context, pattern string) (int, error)
+	ResetCounters(ctx context.Context, pattern string) (int, error)
+	Subscribe(filter domain.MetricsFilter) (MetricsSubscription, error)
+}
+
+// MetricsSubscription should deliver batches of updated metrics and heartbeats, until it is done
+// (Err tells why it was closed by the service), it must be closed when not needed
+// It is an alias of interface literal, so that Subscribe of a service returning identical interface matches it
+type MetricsSubscription = interface {
+	Updates() <-chan []domain.Metric
+	Heartbeats() <-chan struct{}
+	Done() <-chan struct{}
+	Err() error
+	Close()
 }
 
 // MetricsRequestResponseFactory can build various requests/responses for usage in the handler
@@ -28,6 +63,7 @@ type MetricsRequestResponseFactory interface {
 	BuildUpdateBatchMetricRequest(ctx context.Context, metrics []domain.Metric) []domain.UpdateMetricRequest
 	BuildUpdateBatchMetricResponse(ctx context.Context, metrics []domain.Metric) []domain.UpdateMetricResponse
 	BuildGetMetricResponse(ctx context.Context, metric domain.Metric) domain.GetMetricResponse
+	BuildListMetricsResponse(ctx context.Context, page domain.MetricsPage) domain.ListMetricsResponse
 }
 
 // MetricsHasher can calculate hashes based on metric, and also check if provided hash matches calculated
@@ -35,3 +71,8 @@ type MetricsHasher interface {
 	Hash(ctx context.Context, metric domain.Metric) string
 	Check(ctx context.Context, metric domain.Metric, hash string) bool
 }
+
+// URLParamExtractor can extract URL path parameters from request, e.g. '{name}' in '/value/{type}/{name}'
+type URLParamExtractor interface {
+	URLParam(req *http.Request, name string) string
+}
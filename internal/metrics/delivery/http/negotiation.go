@@ -0,0 +1,90 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/rendering"
+)
+
+// mediaRange is one of media types listed in Accept header, e.g. 'text/plain; version=0.0.4; q=0.5'
+type mediaRange struct {
+	mediaType string
+	version   string
+	weight    float64
+}
+
+// negotiateFormat picks format by 'format' query parameter if it is set, otherwise by Accept header
+// Formats are listed in order of preference, the first one is picked if any format is acceptable (e.g. '*/*')
+func negotiateFormat(r *http.Request, formats []rendering.Format) (rendering.Format, bool) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, format := range formats {
+			if format.Name == name {
+				return format, true
+			}
+		}
+		return rendering.Format{}, false
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return formats[0], true
+	}
+	for _, accepted := range parseAccept(accept) {
+		if format, ok := matchFormat(accepted, formats); ok {
+			return format, true
+		}
+	}
+	return rendering.Format{}, false
+}
+
+// parseAccept returns acceptable media ranges of Accept header, most preferred first
+func parseAccept(accept string) []mediaRange {
+	ranges := make([]mediaRange, 0)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		accepted := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), weight: 1}
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch strings.ToLower(key) {
+			case "q":
+				if weight, err := strconv.ParseFloat(value, 64); err == nil {
+					accepted.weight = weight
+				}
+			case "version":
+				accepted.version = value
+			}
+		}
+		if accepted.mediaType != "" && accepted.weight > 0 {
+			ranges = append(ranges, accepted)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].weight > ranges[j].weight
+	})
+	return ranges
+}
+
+// matchFormat finds format of media range, formats of the same media type are told apart by version,
+// the one without version is matched if range has no version
+func matchFormat(accepted mediaRange, formats []rendering.Format) (rendering.Format, bool) {
+	if accepted.mediaType == "*/*" {
+		return formats[0], true
+	}
+	if strings.HasSuffix(accepted.mediaType, "/*") {
+		for _, format := range formats {
+			if strings.HasPrefix(format.MediaType, strings.TrimSuffix(accepted.mediaType, "*")) {
+				return format, true
+			}
+		}
+		return rendering.Format{}, false
+	}
+	for _, format := range formats {
+		if format.MediaType == accepted.mediaType && format.Version == accepted.version {
+			return format, true
+		}
+	}
+	return rendering.Format{}, false
+}
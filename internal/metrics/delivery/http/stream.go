@@ -0,0 +1,162 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// streamWriteTimeout limits how long a single WebSocket frame may take to be written to a client
+const streamWriteTimeout = 10 * time.Second
+
+// Origin is checked by default, so that pages of other sites cannot read the stream with visitors' cookies
+var upgrader = websocket.Upgrader{}
+
+// Stream handles 'GET /api/v1/stream' requests, updated metrics are pushed to the client as they come in,
+// over WebSocket if client asks to upgrade the connection, otherwise as Server-Sent Events
+// Metrics are filtered by the same parameters as in Query: 'name', 'type', 'prefix', 'glob', 'regex' and 'label'
+// Every message is '{"metrics":[...]}' with metrics updated by a single request, that match the filter
+func (h *MetricsHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	ctx := logger.ContextFromRequest(r)
+
+	filter, err := parseMetricsFilter(r)
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] received invalid stream filter: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidQuery)
+		return
+	}
+
+	sub, err := h.service.Subscribe(filter)
+	if errors.Is(err, domain.ErrInvalidQuery) {
+		logger.New(ctx).Errorf("[metrics handler] received invalid stream filter: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusBadRequest, ErrStringInvalidQuery)
+		return
+	}
+	if err != nil {
+		logger.New(ctx).Errorf("[metrics handler] cannot subscribe to stream: %s", err.Error())
+		h.PlainText(ctx, w, http.StatusServiceUnavailable, ErrStringStreamUnavailable)
+		return
+	}
+	defer sub.Close()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		h.streamWebSocket(ctx, w, r, sub)
+	} else {
+		h.streamEvents(ctx, w, r, sub)
+	}
+}
+
+// streamEvents sends updates as 'update' events, heartbeats as comments,
+// and 'close' event with the reason when subscription is closed by the server
+func (h *MetricsHandler) streamEvents(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	sub MetricsSubscription,
+) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.New(ctx).Errorf("[metrics handler] response writer does not support streaming")
+		h.PlainText(ctx, w, http.StatusInternalServerError, ErrStringStreamUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Disables response buffering of nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		var err error
+		select {
+		case metrics := <-sub.Updates():
+			var body []byte
+			if body, err = h.encodeStreamMessage(ctx, metrics); err == nil {
+				_, err = fmt.Fprintf(w, "event: update\ndata: %s\n\n", body)
+			}
+		case <-sub.Heartbeats():
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-sub.Done():
+			logger.New(ctx).Infof("[metrics handler] stream closed by server: %s", sub.Err())
+			_, _ = fmt.Fprintf(w, "event: close\ndata: %s\n\n", sub.Err())
+			flusher.Flush()
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err != nil {
+			logger.New(ctx).Errorf("[metrics handler] error when writing to stream: %s", err.Error())
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// streamWebSocket sends updates as text messages, heartbeats as pings,
+// and close message with the reason when subscription is closed by the server
+// Messages sent by the client are ignored, they are only read to handle pongs and close messages
+func (h *MetricsHandler) streamWebSocket(
+	ctx context.Context,
+	w http.ResponseWriter,
+	r *http.Request,
+	sub MetricsSubscription,
+) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrader has already responded with an error
+		logger.New(ctx).Errorf("[metrics handler] websocket upgrade failed: %s", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		for {
+			if _, _, readErr := conn.NextReader(); readErr != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case metrics := <-sub.Updates():
+			var body []byte
+			if body, err = h.encodeStreamMessage(ctx, metrics); err == nil {
+				_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				err = conn.WriteMessage(websocket.TextMessage, body)
+			}
+		case <-sub.Heartbeats():
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout))
+		case <-sub.Done():
+			logger.New(ctx).Infof("[metrics handler] stream closed by server: %s", sub.Err())
+			code := websocket.CloseGoingAway
+			if errors.Is(sub.Err(), domain.ErrSlowSubscriber) {
+				code = websocket.CloseTryAgainLater
+			}
+			message := websocket.FormatCloseMessage(code, sub.Err().Error())
+			_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(streamWriteTimeout))
+			return
+		case <-disconnected:
+			return
+		}
+		if err != nil {
+			logger.New(ctx).Errorf("[metrics handler] error when writing to websocket: %s", err.Error())
+			return
+		}
+	}
+}
+
+func (h *MetricsHandler) encodeStreamMessage(ctx context.Context, metrics []domain.Metric) ([]byte, error) {
+	return json.Marshal(h.factory.BuildListMetricsResponse(ctx, domain.MetricsPage{Metrics: metrics}))
+}
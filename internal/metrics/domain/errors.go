@@ -0,0 +1,20 @@
+package domain
+
+import "errors"
+
+var (
+	// ErrUpdateJournaled is returned when update is accepted, but not written yet (e.g. database is unavailable),
+	// so resulting values are not known
+	ErrUpdateJournaled     = errors.New("update is journaled, resulting values are not known yet")
+	ErrHistoryNotSupported = errors.New("metrics repository does not support history")
+	ErrInvalidPattern      = errors.New("invalid metric name pattern")
+	ErrInvalidQuery        = errors.New("invalid metrics query")
+)
+
+// Stream errors tell why subscription was refused or closed
+var (
+	ErrStreamingDisabled  = errors.New("metrics streaming is not enabled")
+	ErrTooManySubscribers = errors.New("too many stream subscribers")
+	ErrSlowSubscriber     = errors.New("stream subscriber fell behind, updates were dropped")
+	ErrStreamClosed       = errors.New("metrics stream is closed")
+)
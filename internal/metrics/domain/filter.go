@@ -0,0 +1,167 @@
+package domain
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MetricsFilter selects metrics by name, type and labels, all of the set predicates must match
+// Names and Types match any of the values, patterns are matched against the whole metric name
+type MetricsFilter struct {
+	Names  []string
+	Types  []string
+	Prefix string
+	// Glob is a shell pattern, e.g. 'Heap*', see path.Match
+	Glob string
+	// Regex is RE2 expression, e.g. 'Heap(Alloc|Sys)'
+	Regex  string
+	Labels []LabelMatcher
+}
+
+// Label matching operators, same as in Prometheus selectors
+const (
+	LabelEqual     = "="
+	LabelNotEqual  = "!="
+	LabelRegex     = "=~"
+	LabelNotRegex  = "!~"
+	labelOperators = "!=~"
+)
+
+// LabelMatcher matches value of a label, missing label is matched as an empty value
+// Regex values are matched against the whole label value
+type LabelMatcher struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+// IsEmpty checks if filter matches every metric
+func (f *MetricsFilter) IsEmpty() bool {
+	return f == nil || (len(f.Names) == 0 && !f.hasPatterns())
+}
+
+// OnlyNames checks if filter has no predicates except for names
+func (f *MetricsFilter) OnlyNames() bool {
+	return f != nil && len(f.Names) > 0 && !f.hasPatterns()
+}
+
+// hasPatterns checks if filter has predicates other than exact names
+func (f *MetricsFilter) hasPatterns() bool {
+	return len(f.Types) > 0 || f.Prefix != "" || f.Glob != "" || f.Regex != "" || len(f.Labels) > 0
+}
+
+// Matcher compiles filter patterns and returns func that checks if metric matches the filter
+func (f *MetricsFilter) Matcher() (func(metric Metric) bool, error) {
+	if f.IsEmpty() {
+		return func(metric Metric) bool { return true }, nil
+	}
+	if _, err := path.Match(f.Glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob '%s': %w", f.Glob, err)
+	}
+	var nameRegex *regexp.Regexp
+	if f.Regex != "" {
+		var err error
+		if nameRegex, err = compileAnchored(f.Regex); err != nil {
+			return nil, fmt.Errorf("invalid regex '%s': %w", f.Regex, err)
+		}
+	}
+	labelRegexes := make([]*regexp.Regexp, len(f.Labels))
+	for i, matcher := range f.Labels {
+		switch matcher.Operator {
+		case LabelEqual, LabelNotEqual:
+		case LabelRegex, LabelNotRegex:
+			regex, err := compileAnchored(matcher.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex of label '%s': %w", matcher.Key, err)
+			}
+			labelRegexes[i] = regex
+		default:
+			return nil, fmt.Errorf("invalid operator '%s' of label '%s'", matcher.Operator, matcher.Key)
+		}
+	}
+
+	return func(metric Metric) bool {
+		switch {
+		case len(f.Names) > 0 && !contains(f.Names, metric.Name),
+			len(f.Types) > 0 && !contains(f.Types, metric.Type),
+			!strings.HasPrefix(metric.Name, f.Prefix),
+			nameRegex != nil && !nameRegex.MatchString(metric.Name):
+			return false
+		}
+		if f.Glob != "" {
+			if matched, _ := path.Match(f.Glob, metric.Name); !matched {
+				return false
+			}
+		}
+		for i, matcher := range f.Labels {
+			value := metric.Labels[matcher.Key]
+			var matched bool
+			switch matcher.Operator {
+			case LabelEqual:
+				matched = value == matcher.Value
+			case LabelNotEqual:
+				matched = value != matcher.Value
+			case LabelRegex:
+				matched = labelRegexes[i].MatchString(value)
+			case LabelNotRegex:
+				matched = !labelRegexes[i].MatchString(value)
+			}
+			if !matched {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// ParseLabelMatcher parses label matcher in 'key<operator>value' form, e.g. 'host=a', 'env!~dev|test'
+func ParseLabelMatcher(value string) (LabelMatcher, error) {
+	i := strings.IndexAny(value, labelOperators)
+	if i <= 0 || strings.TrimSpace(value[:i]) == "" {
+		return LabelMatcher{}, fmt.Errorf("invalid label matcher '%s', must be in 'key=value' form", value)
+	}
+	for _, operator := range []string{LabelNotEqual, LabelRegex, LabelNotRegex, LabelEqual} {
+		if strings.HasPrefix(value[i:], operator) {
+			return LabelMatcher{Key: strings.TrimSpace(value[:i]), Operator: operator, Value: value[i+len(operator):]}, nil
+		}
+	}
+	return LabelMatcher{}, fmt.Errorf("invalid operator in label matcher '%s'", value)
+}
+
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func contains(slice []string, elem string) bool {
+	for _, value := range slice {
+		if value == elem {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort orders of metrics listing, '-' prefix (e.g. '-value') reverses the order
+// Metrics with equal sort values are ordered by series key, so that the order is stable across pages
+const (
+	SortByName  = "name"
+	SortByType  = "type"
+	SortByValue = "value"
+)
+
+// MetricsQuery selects a page of filtered and sorted metrics
+// Cursor is an opaque value returned with the previous page, listing continues right after it
+type MetricsQuery struct {
+	Filter MetricsFilter
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+// MetricsPage is a result of MetricsQuery, NextCursor is empty if there are no more metrics
+type MetricsPage struct {
+	Metrics    []Metric
+	NextCursor string
+}
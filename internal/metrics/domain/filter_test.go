@@ -0,0 +1,117 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsFilterMatcher(t *testing.T) {
+	metrics := []Metric{
+		NewCounter(PollCount, 1),
+		{Name: HeapAlloc, Type: TypeGauge, Labels: Labels{"host": "a", "env": "prod"}},
+		{Name: HeapSys, Type: TypeGauge, Labels: Labels{"host": "b"}},
+		NewGauge(Alloc, 1),
+	}
+	tests := []struct {
+		name    string
+		filter  *MetricsFilter
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "nil filter",
+			filter: nil,
+			want:   []string{PollCount, HeapAlloc, HeapSys, Alloc},
+		},
+		{
+			name:   "names and types",
+			filter: &MetricsFilter{Names: []string{PollCount, Alloc}, Types: []string{TypeGauge}},
+			want:   []string{Alloc},
+		},
+		{
+			name:   "prefix",
+			filter: &MetricsFilter{Prefix: "Heap"},
+			want:   []string{HeapAlloc, HeapSys},
+		},
+		{
+			name:   "glob",
+			filter: &MetricsFilter{Glob: "*Alloc"},
+			want:   []string{HeapAlloc, Alloc},
+		},
+		{
+			name:   "regex matches the whole name",
+			filter: &MetricsFilter{Regex: "Heap|Alloc"},
+			want:   []string{Alloc},
+		},
+		{
+			name: "label matchers, missing label is empty",
+			filter: &MetricsFilter{Labels: []LabelMatcher{
+				{Key: "host", Operator: LabelRegex, Value: "a|b"},
+				{Key: "env", Operator: LabelNotEqual, Value: "prod"},
+			}},
+			want: []string{HeapSys},
+		},
+		{
+			name:   "label is absent",
+			filter: &MetricsFilter{Labels: []LabelMatcher{{Key: "host", Operator: LabelEqual, Value: ""}}},
+			want:   []string{PollCount, Alloc},
+		},
+		{
+			name:    "invalid regex",
+			filter:  &MetricsFilter{Regex: "Heap("},
+			wantErr: true,
+		},
+		{
+			name:    "invalid glob",
+			filter:  &MetricsFilter{Glob: "Heap["},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := tt.filter.Matcher()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			matched := make([]string, 0)
+			for _, metric := range metrics {
+				if matches(metric) {
+					matched = append(matched, metric.Name)
+				}
+			}
+			assert.Equal(t, tt.want, matched)
+		})
+	}
+}
+
+func TestParseLabelMatcher(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    LabelMatcher
+		wantErr bool
+	}{
+		{value: "host=a", want: LabelMatcher{Key: "host", Operator: LabelEqual, Value: "a"}},
+		{value: "host!=a", want: LabelMatcher{Key: "host", Operator: LabelNotEqual, Value: "a"}},
+		{value: "dc=~eu-.*", want: LabelMatcher{Key: "dc", Operator: LabelRegex, Value: "eu-.*"}},
+		{value: "dc!~a=b", want: LabelMatcher{Key: "dc", Operator: LabelNotRegex, Value: "a=b"}},
+		{value: "host=", want: LabelMatcher{Key: "host", Operator: LabelEqual, Value: ""}},
+		{value: "host", wantErr: true},
+		{value: "=a", wantErr: true},
+		{value: "host!a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			matcher, err := ParseLabelMatcher(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, matcher)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+var (
+	ErrHistogramBounds       = errors.New("histogram bounds must be sorted and unique")
+	ErrHistogramCounts       = errors.New("histogram must have one more count than bounds")
+	ErrHistogramCount        = errors.New("histogram count must be the sum of bucket counts")
+	ErrHistogramIncompatible = errors.New("histograms have different bounds")
+)
+
+// Histogram is a distribution of observed values over buckets
+// Bucket i counts values in (Bounds[i-1], Bounds[i]], the last bucket counts values above the last bound (+Inf)
+// Like counters, histograms are sent as deltas (observations since the last export) and accumulated by the server
+// Histograms should be treated as immutable, merging creates a new histogram
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+func NewHistogram(name string, value *Histogram) Metric {
+	return Metric{
+		Name:      name,
+		Type:      TypeHistogram,
+		Histogram: value,
+	}
+}
+
+// NewHistogramValue creates an empty histogram with given bucket bounds
+func NewHistogramValue(bounds []float64) *Histogram {
+	return &Histogram{
+		Bounds: append([]float64(nil), bounds...),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe adds value to the histogram
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.Bounds, value)
+	h.Counts[i]++
+	h.Sum += value
+	h.Count++
+}
+
+// Validate checks that histogram is consistent, e.g. after it is received from the wire
+func (h *Histogram) Validate() error {
+	for i := 1; i < len(h.Bounds); i++ {
+		if !(h.Bounds[i-1] < h.Bounds[i]) {
+			return ErrHistogramBounds
+		}
+	}
+	for _, bound := range h.Bounds {
+		if math.IsNaN(bound) {
+			return ErrHistogramBounds
+		}
+	}
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return ErrHistogramCounts
+	}
+	var count uint64
+	for _, c := range h.Counts {
+		count += c
+	}
+	if count != h.Count {
+		return ErrHistogramCount
+	}
+	return nil
+}
+
+// IsCompatible checks if histograms have the same bounds, so they can be merged
+func (h *Histogram) IsCompatible(other *Histogram) bool {
+	if h == nil || other == nil || len(h.Bounds) != len(other.Bounds) {
+		return false
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge returns a new histogram with observations of both histograms
+func (h *Histogram) Merge(other *Histogram) (*Histogram, error) {
+	if !h.IsCompatible(other) {
+		return nil, ErrHistogramIncompatible
+	}
+	result := NewHistogramValue(h.Bounds)
+	for i := range result.Counts {
+		result.Counts[i] = h.Counts[i] + other.Counts[i]
+	}
+	result.Sum = h.Sum + other.Sum
+	result.Count = h.Count + other.Count
+	return result, nil
+}
+
+// MergeHistograms merges newer histogram on top of older one
+// If bounds are different (e.g. buckets were reconfigured), older observations cannot be kept and newer histogram wins
+func MergeHistograms(older, newer *Histogram) *Histogram {
+	merged, err := older.Merge(newer)
+	if err != nil {
+		return newer
+	}
+	return merged
+}
+
+func (h *Histogram) String() string {
+	if h == nil {
+		return ""
+	}
+	return fmt.Sprintf("count=%d sum=%s", h.Count, Gauge(h.Sum))
+}
+
+// ValidateValue checks that metric carries a valid value of its type (only histograms can be invalid)
+func (m Metric) ValidateValue() error {
+	if !m.IsHistogram() {
+		return nil
+	}
+	if m.Histogram == nil {
+		return ErrHistogramCounts
+	}
+	return m.Histogram.Validate()
+}
+
+func (m Metric) IsHistogram() bool {
+	return m.Type == TypeHistogram
+}
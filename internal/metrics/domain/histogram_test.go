@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	h := NewHistogramValue([]float64{1, 10})
+	for _, value := range []float64{0.5, 1, 5, 100} {
+		h.Observe(value)
+	}
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(4), h.Count)
+	assert.Equal(t, 106.5, h.Sum)
+	assert.NoError(t, h.Validate())
+}
+
+func TestHistogramValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		histogram Histogram
+		wantErr   error
+	}{
+		{
+			name:      "valid",
+			histogram: Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 0, 2}, Sum: 10, Count: 3},
+		},
+		{
+			name:      "no bounds",
+			histogram: Histogram{Counts: []uint64{2}, Sum: 1, Count: 2},
+		},
+		{
+			name:      "unsorted bounds",
+			histogram: Histogram{Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0}},
+			wantErr:   ErrHistogramBounds,
+		},
+		{
+			name:      "duplicate bounds",
+			histogram: Histogram{Bounds: []float64{1, 1}, Counts: []uint64{0, 0, 0}},
+			wantErr:   ErrHistogramBounds,
+		},
+		{
+			name:      "counts do not match bounds",
+			histogram: Histogram{Bounds: []float64{1, 2}, Counts: []uint64{0, 0}},
+			wantErr:   ErrHistogramCounts,
+		},
+		{
+			name:      "count is not sum of buckets",
+			histogram: Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 3},
+			wantErr:   ErrHistogramCount,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantErr, tt.histogram.Validate())
+		})
+	}
+}
+
+func TestMergeHistograms(t *testing.T) {
+	older := &Histogram{Bounds: []float64{1}, Counts: []uint64{1, 2}, Sum: 5, Count: 3}
+	newer := &Histogram{Bounds: []float64{1}, Counts: []uint64{3, 0}, Sum: 1, Count: 3}
+
+	merged := MergeHistograms(older, newer)
+	require.NotNil(t, merged)
+	assert.Equal(t, &Histogram{Bounds: []float64{1}, Counts: []uint64{4, 2}, Sum: 6, Count: 6}, merged)
+	// Merged histograms are left untouched
+	assert.Equal(t, []uint64{1, 2}, older.Counts)
+	assert.Equal(t, []uint64{3, 0}, newer.Counts)
+
+	reconfigured := &Histogram{Bounds: []float64{1, 2}, Counts: []uint64{0, 1, 0}, Sum: 1.5, Count: 1}
+	assert.Same(t, reconfigured, MergeHistograms(older, reconfigured))
+	assert.Same(t, newer, MergeHistograms(nil, newer))
+}
@@ -0,0 +1,77 @@
+package domain
+
+import "time"
+
+// Sample is a value of metric at some point in time
+// For counters (and histograms), value is the accumulated value (not delta)
+type Sample struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// HistoryQuery defines time range [From, To] of history,
+// if Step is set, samples are downsampled so that there is at most one sample per Step
+type HistoryQuery struct {
+	From time.Time
+	To   time.Time
+	Step time.Duration
+}
+
+// FloatValue returns value of metric as float, e.g. for storing it as a history sample
+// For histograms, it is the accumulated number of observations
+func (m Metric) FloatValue() float64 {
+	switch m.Type {
+	case TypeCounter:
+		return float64(m.Counter)
+	case TypeGauge:
+		return float64(m.Gauge)
+	case TypeHistogram:
+		if m.Histogram == nil {
+			return 0
+		}
+		return float64(m.Histogram.Count)
+	default:
+		return 0
+	}
+}
+
+// Resolutions of stored history, raw samples are rolled up into minute and then hour aggregates
+const (
+	ResolutionRaw    time.Duration = 0
+	ResolutionMinute               = time.Minute
+	ResolutionHour                 = time.Hour
+)
+
+// Rollup is an aggregate of metric samples within [Timestamp, Timestamp + resolution) bucket
+// For gauges, Min/Max/Sum/Count/Last describe the values, for counters Delta is the sum of increments
+type Rollup struct {
+	Timestamp time.Time
+	Min       float64
+	Max       float64
+	Sum       float64
+	Count     int64
+	Last      float64
+	Delta     float64
+}
+
+// SeriesRange selects history of a series since From, so that history of many series is read at once
+type SeriesRange struct {
+	Name   string
+	Labels Labels
+	From   time.Time
+}
+
+// SeriesRollup is a rollup of a series, so that rollups of many series are stored at once
+type SeriesRollup struct {
+	Name   string
+	Labels Labels
+	Rollup
+}
+
+// Avg returns average value of samples within the rollup
+func (r Rollup) Avg() float64 {
+	if r.Count == 0 {
+		return 0
+	}
+	return r.Sum / float64(r.Count)
+}
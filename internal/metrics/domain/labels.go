@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels are key/value dimensions of metric (e.g. host, agent_id, env)
+// Metrics with the same name, but different labels are different series
+type Labels map[string]string
+
+// String returns labels in canonical form, sorted by key, e.g. 'env="prod",host="a"'
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(key)
+		builder.WriteByte('=')
+		builder.WriteString(strconv.Quote(l[key]))
+	}
+	return builder.String()
+}
+
+// Merge returns copy of labels with defaults added, labels already set are not overwritten
+func (l Labels) Merge(defaults Labels) Labels {
+	if len(l) == 0 && len(defaults) == 0 {
+		return nil
+	}
+	result := make(Labels, len(l)+len(defaults))
+	for key, value := range defaults {
+		result[key] = value
+	}
+	for key, value := range l {
+		result[key] = value
+	}
+	return result
+}
+
+// Copy returns a copy of labels, nil if there are none
+func (l Labels) Copy() Labels {
+	return l.Merge(nil)
+}
+
+// ParseLabels parses labels in 'key1=value1,key2=value2' form, empty string means no labels
+func ParseLabels(value string) (Labels, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	labels := make(Labels)
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label '%s', must be in 'key=value' form", pair)
+		}
+		labels[key] = strings.TrimSpace(val)
+	}
+	return labels, nil
+}
@@ -0,0 +1,98 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric Metric
+		want   string
+	}{
+		{
+			name:   "no labels",
+			metric: NewGauge(Alloc, 1),
+			want:   Alloc,
+		},
+		{
+			name:   "empty labels",
+			metric: Metric{Name: Alloc, Type: TypeGauge, Labels: Labels{}},
+			want:   Alloc,
+		},
+		{
+			name:   "labels sorted by key",
+			metric: Metric{Name: Alloc, Type: TypeGauge, Labels: Labels{"host": "a", "env": "prod"}},
+			want:   `Alloc{env="prod",host="a"}`,
+		},
+		{
+			name:   "quotes are escaped",
+			metric: Metric{Name: Alloc, Type: TypeGauge, Labels: Labels{"host": `a"b`}},
+			want:   `Alloc{host="a\"b"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.metric.Key())
+		})
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Labels
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "several labels",
+			value: "host=a, env = prod",
+			want:  Labels{"host": "a", "env": "prod"},
+		},
+		{
+			name:  "empty value",
+			value: "host=",
+			want:  Labels{"host": ""},
+		},
+		{
+			name:    "no value",
+			value:   "host",
+			wantErr: true,
+		},
+		{
+			name:    "no key",
+			value:   "=a",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, err := ParseLabels(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, labels)
+		})
+	}
+}
+
+func TestLabelsMerge(t *testing.T) {
+	labels := Labels{"host": "a"}
+	merged := labels.Merge(Labels{"host": "default", "env": "prod"})
+
+	assert.Equal(t, Labels{"host": "a", "env": "prod"}, merged)
+	// Original labels are not modified
+	assert.Equal(t, Labels{"host": "a"}, labels)
+	assert.Nil(t, Labels(nil).Merge(nil))
+}
@@ -1,19 +1,32 @@
 package domain
 
 const (
-	TypeGauge   = "gauge"
-	TypeCounter = "counter"
+	TypeGauge     = "gauge"
+	TypeCounter   = "counter"
+	TypeHistogram = "histogram"
 )
 
 type Metric struct {
-	Name    string
-	Type    string
-	Counter Counter
-	Gauge   Gauge
+	Name      string
+	Type      string
+	Counter   Counter
+	Gauge     Gauge
+	Histogram *Histogram
+	Labels    Labels
 }
 
-type MetricsFilter struct {
-	Names []string
+// Key identifies series of metric: name alone if there are no labels,
+// otherwise name with labels, e.g. 'Alloc{host="a"}'
+func (m Metric) Key() string {
+	return SeriesKey(m.Name, m.Labels)
+}
+
+// SeriesKey builds series key from metric name and labels, see Metric.Key
+func SeriesKey(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+	return name + "{" + labels.String() + "}"
 }
 
 func (m Metric) StringValue() string {
@@ -22,13 +35,33 @@ func (m Metric) StringValue() string {
 		return m.Counter.String()
 	case TypeGauge:
 		return m.Gauge.String()
+	case TypeHistogram:
+		return m.Histogram.String()
 	default:
 		return ""
 	}
 }
 
+// IsCumulative checks if values of metric are accumulated by the server (counters and histograms)
+func (m Metric) IsCumulative() bool {
+	return m.IsCounter() || m.IsHistogram()
+}
+
+// Accumulate applies metric on top of the existing metric of the same series
+// For counters, old value is added on top of new value, histograms are merged, gauges are overwritten
+// If metric type has changed, the new metric replaces the existing one
+func Accumulate(existing, metric Metric) Metric {
+	switch {
+	case metric.IsCounter() && existing.IsCounter():
+		metric.Counter += existing.Counter
+	case metric.IsHistogram() && existing.IsHistogram():
+		metric.Histogram = MergeHistograms(existing.Histogram, metric.Histogram)
+	}
+	return metric
+}
+
 func IsValidMetricType(metricType string) bool {
-	for _, possible := range []string{TypeCounter, TypeGauge} {
+	for _, possible := range []string{TypeCounter, TypeGauge, TypeHistogram} {
 		if metricType == possible {
 			return true
 		}
@@ -23,6 +23,7 @@ const (
 	NumForcedGC   = "NumForcedGC"
 	NumGC         = "NumGC"
 	OtherSys      = "OtherSys"
+	PauseNs       = "PauseNs"
 	PauseTotalNs  = "PauseTotalNs"
 	StackInuse    = "StackInuse"
 	StackSys      = "StackSys"
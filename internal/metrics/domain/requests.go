@@ -1,11 +1,15 @@
 package domain
 
+import "time"
+
 type GenericMetric struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	Hash  string   `json:"hash,omitempty"`
+	ID        string            `json:"id"`
+	MType     string            `json:"type"`
+	Delta     *int64            `json:"delta,omitempty"`
+	Value     *float64          `json:"value,omitempty"`
+	Hash      string            `json:"hash,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Histogram *Histogram        `json:"histogram,omitempty"`
 }
 
 type UpdateMetricRequest struct {
@@ -24,10 +28,56 @@ type GetMetricResponse struct {
 	GenericMetric
 }
 
+type ListMetricsResponse struct {
+	Metrics    []GenericMetric `json:"metrics"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type GetMetricHistoryResponse struct {
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Points []HistoryPoint    `json:"points"`
+}
+
+type DeleteMetricsResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+type ResetMetricsResponse struct {
+	Reset int `json:"reset"`
+}
+
+type HistoryPoint struct {
+	Timestamp time.Time `json:"ts"`
+	Value     float64   `json:"value"`
+}
+
+// NewGenericMetric converts metric to its JSON representation, hash is not set
+func NewGenericMetric(metric Metric) GenericMetric {
+	result := GenericMetric{
+		ID:     metric.Name,
+		MType:  metric.Type,
+		Labels: metric.Labels.Copy(),
+	}
+	switch metric.Type {
+	case TypeCounter:
+		val := int64(metric.Counter)
+		result.Delta = &val
+	case TypeGauge:
+		val := float64(metric.Gauge)
+		result.Value = &val
+	case TypeHistogram:
+		result.Histogram = metric.Histogram
+	}
+	return result
+}
+
 func (g GenericMetric) TranslateToMetric() Metric {
 	metric := Metric{
-		Name: g.ID,
-		Type: g.MType,
+		Name:   g.ID,
+		Type:   g.MType,
+		Labels: Labels(g.Labels).Copy(),
 	}
 	if g.Delta != nil {
 		metric.Counter = Counter(*g.Delta)
@@ -35,5 +85,8 @@ func (g GenericMetric) TranslateToMetric() Metric {
 	if g.Value != nil {
 		metric.Gauge = Gauge(*g.Value)
 	}
+	if g.Histogram != nil {
+		metric.Histogram = g.Histogram
+	}
 	return metric
 }
@@ -0,0 +1,102 @@
+package exporters
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/worker"
+	delivery "eridiumdev/yandex-praktikum-go-devops/internal/metrics/delivery/grpc"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/delivery/grpc/pb"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+type GRPCExporter struct {
+	*worker.Worker
+	conn   *grpc.ClientConn
+	client pb.MetricsClient
+	cfg    config.GRPCExporterConfig
+}
+
+func NewGRPCExporter(
+	ctx context.Context,
+	name string,
+	hasher delivery.MetricsHasher,
+	cfg config.GRPCExporterConfig,
+) (*GRPCExporter, error) {
+	hashInterceptor := delivery.NewHashInterceptor(hasher)
+
+	// Connection is established lazily, so this does not fail if server is not up yet
+	conn, err := grpc.Dial(cfg.Address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(hashInterceptor.UnaryClient()),
+		grpc.WithChainStreamInterceptor(hashInterceptor.StreamClient()),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "[grpc exporter] error when creating client connection")
+	}
+
+	exp := &GRPCExporter{
+		Worker: worker.New(name, 1),
+		conn:   conn,
+		client: pb.NewMetricsClient(conn),
+		cfg:    cfg,
+	}
+	go exp.waitAndClose(ctx)
+	return exp, nil
+}
+
+func (exp *GRPCExporter) Export(ctx context.Context, metrics []domain.Metric) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, exp.cfg.Timeout)
+	defer cancel()
+
+	var err error
+	if exp.cfg.UseStream {
+		err = exp.exportStream(timeoutCtx, metrics)
+	} else {
+		_, err = exp.client.UpdateBatch(timeoutCtx, &pb.UpdateBatchRequest{
+			Metrics: exp.translate(metrics),
+		})
+	}
+	if err != nil {
+		return errors.Wrapf(err, "[grpc exporter] failed to export %d metrics", len(metrics))
+	}
+	logger.New(ctx).Infof("[grpc exporter] exported %d metrics successfully", len(metrics))
+	return nil
+}
+
+func (exp *GRPCExporter) exportStream(ctx context.Context, metrics []domain.Metric) error {
+	stream, err := exp.client.UpdateStream(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range exp.translate(metrics) {
+		if err = stream.Send(m); err != nil {
+			return err
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func (exp *GRPCExporter) translate(metrics []domain.Metric) []*pb.Metric {
+	list := make([]*pb.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		list = append(list, delivery.TranslateFromMetric(metric))
+	}
+	return list
+}
+
+func (exp *GRPCExporter) waitAndClose(ctx context.Context) {
+	<-ctx.Done()
+	logger.New(ctx).Debugf("[grpc exporter] context cancelled, closing connection")
+
+	err := exp.conn.Close()
+	if err != nil {
+		logger.New(ctx).Errorf("[grpc exporter] error when closing connection: %s", err.Error())
+	}
+}
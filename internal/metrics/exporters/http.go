@@ -7,9 +7,11 @@ import (
 	"net/http"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
 
 	"eridiumdev/yandex-praktikum-go-devops/config"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/retry"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/worker"
 	delivery "eridiumdev/yandex-praktikum-go-devops/internal/metrics/delivery/http"
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
@@ -20,6 +22,7 @@ type HTTPExporter struct {
 	address string
 	factory delivery.MetricsRequestResponseFactory
 	client  *resty.Client
+	retry   *retry.Policy
 }
 
 func NewHTTPExporter(
@@ -33,18 +36,31 @@ func NewHTTPExporter(
 		factory: factory,
 		client: resty.New().
 			SetTimeout(cfg.Timeout),
+		retry: retry.NewPolicy(cfg.Retry),
 	}
 	return exp
 }
 
 func (exp *HTTPExporter) Export(ctx context.Context, metrics []domain.Metric) error {
-	req, err := exp.prepareRequest(ctx, metrics)
-	if err != nil {
-		return err
-	}
-	resp, err := req.Send()
+	var resp *resty.Response
+
+	err := exp.retry.Do(ctx, "http exporter", func(ctx context.Context, attempt int) (bool, error) {
+		req, err := exp.prepareRequest(ctx, metrics)
+		if err != nil {
+			return false, err
+		}
+		resp, err = req.Send()
+		if err != nil {
+			return exp.retry.IsRetryableError(err), err
+		}
+		if resp.IsError() {
+			return exp.retry.IsRetryableStatus(resp.StatusCode()),
+				fmt.Errorf("export not acknowledged by server, status %s", resp.Status())
+		}
+		return false, nil
+	})
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "[http exporter] failed to export %d metrics", len(metrics))
 	}
 	logger.New(ctx).Infof("[http exporter] exported %d metrics successfully, status %s", len(metrics), resp.Status())
 	return nil
@@ -3,7 +3,11 @@ package exporters
 import (
 	"context"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -69,3 +73,121 @@ func TestPrepareRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestExportRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxAttempts  int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{
+			name:         "success on first attempt",
+			statuses:     []int{http.StatusOK},
+			maxAttempts:  3,
+			wantAttempts: 1,
+		},
+		{
+			name:         "success after retryable statuses",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK},
+			maxAttempts:  3,
+			wantAttempts: 3,
+		},
+		{
+			name:         "attempts run out",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			maxAttempts:  2,
+			wantAttempts: 2,
+			wantErr:      true,
+		},
+		{
+			name:         "non-retryable status",
+			statuses:     []int{http.StatusBadRequest, http.StatusOK},
+			maxAttempts:  3,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempt := atomic.AddInt32(&attempts, 1)
+				w.WriteHeader(tt.statuses[attempt-1])
+			}))
+			defer s.Close()
+
+			exp := NewHTTPExporter("http",
+				delivery.NewRequestResponseFactory(hash.NewHasher("s3cr3t-k3y")),
+				config.HTTPExporterConfig{
+					Address: strings.TrimPrefix(s.URL, "http://"),
+					Timeout: time.Second,
+					Retry: config.RetryConfig{
+						MaxAttempts:       tt.maxAttempts,
+						BaseDelay:         time.Millisecond,
+						MaxDelay:          5 * time.Millisecond,
+						Jitter:            0.2,
+						RetryableStatuses: []int{http.StatusInternalServerError, http.StatusServiceUnavailable},
+					},
+				})
+			err := exp.Export(context.Background(), []domain.Metric{domain.NewCounter(domain.PollCount, 5)})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantAttempts, atomic.LoadInt32(&attempts), "attempts")
+		})
+	}
+}
+
+func TestExportRetryRespectsDeadline(t *testing.T) {
+	var attempts int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	exp := NewHTTPExporter("http",
+		delivery.NewRequestResponseFactory(hash.NewHasher("s3cr3t-k3y")),
+		config.HTTPExporterConfig{
+			Address: strings.TrimPrefix(s.URL, "http://"),
+			Timeout: time.Second,
+			Retry: config.RetryConfig{
+				MaxAttempts:       10,
+				BaseDelay:         time.Second,
+				MaxDelay:          time.Second,
+				RetryableStatuses: []int{http.StatusServiceUnavailable},
+			},
+		})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := exp.Export(ctx, []domain.Metric{domain.NewCounter(domain.PollCount, 5)})
+	require.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts), "attempts")
+}
+
+func TestExportRetryOnNetworkError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	address := strings.TrimPrefix(s.URL, "http://")
+	// Close the server right away, so that connection is refused
+	s.Close()
+
+	exp := NewHTTPExporter("http",
+		delivery.NewRequestResponseFactory(hash.NewHasher("s3cr3t-k3y")),
+		config.HTTPExporterConfig{
+			Address: address,
+			Timeout: time.Second,
+			Retry: config.RetryConfig{
+				MaxAttempts:     3,
+				BaseDelay:       time.Millisecond,
+				MaxDelay:        time.Millisecond,
+				RetryableErrors: []string{"connection-refused"},
+			},
+		})
+	assert.True(t, exp.retry.IsRetryableError(exp.Export(context.Background(),
+		[]domain.Metric{domain.NewCounter(domain.PollCount, 5)})))
+}
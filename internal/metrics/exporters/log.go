@@ -21,7 +21,7 @@ func NewLogExporter(name string) *LogExporter {
 
 func (exp *LogExporter) Export(ctx context.Context, mtx []domain.Metric) error {
 	for _, metric := range mtx {
-		logger.New(ctx).Infof("%s:%s (%s)", metric.Name, metric.StringValue(), metric.Type)
+		logger.New(ctx).Infof("%s:%s (%s)", metric.Key(), metric.StringValue(), metric.Type)
 	}
 	return nil
 }
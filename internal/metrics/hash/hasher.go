@@ -22,12 +22,17 @@ func NewHasher(hashKey string) *hasher {
 }
 
 func (h *hasher) Hash(ctx context.Context, metric domain.Metric) string {
+	// Series key is the same as name for metrics without labels, so their hashes are unchanged
 	var payload string
 	switch metric.Type {
 	case domain.TypeCounter:
-		payload = fmt.Sprintf("%s:counter:%d", metric.Name, metric.Counter)
+		payload = fmt.Sprintf("%s:counter:%d", metric.Key(), metric.Counter)
 	case domain.TypeGauge:
-		payload = fmt.Sprintf("%s:gauge:%f", metric.Name, metric.Gauge)
+		payload = fmt.Sprintf("%s:gauge:%f", metric.Key(), metric.Gauge)
+	case domain.TypeHistogram:
+		if h := metric.Histogram; h != nil {
+			payload = fmt.Sprintf("%s:histogram:%v:%v:%f:%d", metric.Key(), h.Bounds, h.Counts, h.Sum, h.Count)
+		}
 	}
 
 	hash := hmac.New(sha256.New, h.hashKey)
@@ -0,0 +1,30 @@
+package rendering
+
+import (
+	"bytes"
+	"encoding/csv"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+type csvEngine struct{}
+
+func NewCSVEngine() *csvEngine {
+	return &csvEngine{}
+}
+
+// RenderList renders metrics as 'name,type,labels,value' rows with a header, labels are in 'key="value",...' form
+func (e *csvEngine) RenderList(list []domain.Metric) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if err := writer.Write([]string{"name", "type", "labels", "value"}); err != nil {
+		return nil, err
+	}
+	for _, metric := range list {
+		if err := writer.Write([]string{metric.Name, metric.Type, metric.Labels.String(), formatValue(metric)}); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
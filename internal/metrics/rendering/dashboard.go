@@ -0,0 +1,281 @@
+package rendering
+
+import (
+	"html/template"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// Groups of metrics on dashboard, metrics of other collectors are grouped by name prefix
+const (
+	GroupRuntime = "runtime"
+	GroupSystem  = "system"
+	GroupAgent   = "agent"
+	GroupOther   = "other"
+)
+
+// Sizes of SVG images on metric page
+const (
+	sparklineWidth  = 160
+	sparklineHeight = 32
+	chartWidth      = 800
+	chartHeight     = 300
+)
+
+var collectorGroups = map[string]string{
+	domain.Alloc:           GroupRuntime,
+	domain.BuckHashSys:     GroupRuntime,
+	domain.Frees:           GroupRuntime,
+	domain.GCCPUFraction:   GroupRuntime,
+	domain.GCSys:           GroupRuntime,
+	domain.HeapAlloc:       GroupRuntime,
+	domain.HeapIdle:        GroupRuntime,
+	domain.HeapInuse:       GroupRuntime,
+	domain.HeapObjects:     GroupRuntime,
+	domain.HeapReleased:    GroupRuntime,
+	domain.HeapSys:         GroupRuntime,
+	domain.LastGC:          GroupRuntime,
+	domain.Lookups:         GroupRuntime,
+	domain.MCacheInuse:     GroupRuntime,
+	domain.MCacheSys:       GroupRuntime,
+	domain.MSpanInuse:      GroupRuntime,
+	domain.MSpanSys:        GroupRuntime,
+	domain.Mallocs:         GroupRuntime,
+	domain.NextGC:          GroupRuntime,
+	domain.NumForcedGC:     GroupRuntime,
+	domain.NumGC:           GroupRuntime,
+	domain.OtherSys:        GroupRuntime,
+	domain.PauseNs:         GroupRuntime,
+	domain.PauseTotalNs:    GroupRuntime,
+	domain.StackInuse:      GroupRuntime,
+	domain.StackSys:        GroupRuntime,
+	domain.Sys:             GroupRuntime,
+	domain.TotalAlloc:      GroupRuntime,
+	domain.PollCount:       GroupAgent,
+	domain.RandomValue:     GroupAgent,
+	domain.TotalMemory:     GroupSystem,
+	domain.FreeMemory:      GroupSystem,
+	domain.CPUutilization1: GroupSystem,
+}
+
+// DashboardOptions are chosen by dashboard visitor
+// BasePath is path of dashboard, metric pages are at '<BasePath>/<name>?labels=<labels>'
+type DashboardOptions struct {
+	BasePath string
+	Search   string
+	Refresh  time.Duration
+	Range    time.Duration
+}
+
+// DashboardView is data of dashboard template: metrics matching the search, grouped by collector or prefix
+type DashboardView struct {
+	Options DashboardOptions
+	Groups  []MetricGroup
+	Total   int
+	Shown   int
+	// RefreshSeconds is 0 if auto-refresh is disabled
+	RefreshSeconds int
+	RefreshSelect  Select
+}
+
+type MetricGroup struct {
+	Name    string
+	Metrics []MetricView
+}
+
+// Select is a drop-down of options, e.g. refresh intervals, it is submitted as 'Name' query parameter
+type Select struct {
+	Name    string
+	Label   string
+	Options []Choice
+}
+
+type Choice struct {
+	Value    string
+	Label    string
+	Selected bool
+}
+
+// MetricView is a metric formatted for display, Value is in human-readable units and Raw is the exact value
+// LabelsQuery is labels in 'key1=value1,key2=value2' form, which is accepted by 'labels' query parameter
+type MetricView struct {
+	Name        string
+	Type        string
+	Labels      string
+	LabelsQuery string
+	Key         string
+	Unit        string
+	Value       string
+	Raw         string
+	URL         string
+}
+
+// MetricPageView is data of metric page template, it holds history of metric within the range as charts
+type MetricPageView struct {
+	Options      DashboardOptions
+	Metric       MetricView
+	Group        string
+	Samples      int
+	Min          string
+	Max          string
+	Sparkline    template.HTML
+	Chart        template.HTML
+	HistoryError string
+	RangeSelect  Select
+}
+
+var (
+	refreshChoices = []time.Duration{0, 5 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute}
+	rangeChoices   = []time.Duration{15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+)
+
+// groupOf returns dashboard group of metric: collector of known metrics, otherwise prefix of the name
+// up to the first separator (e.g. 'http' for 'http_requests_total'), or 'other' if there is no separator
+func groupOf(name string) string {
+	if group, ok := collectorGroups[name]; ok {
+		return group
+	}
+	if strings.HasPrefix(name, "CPUutilization") {
+		// There is one metric per CPU core
+		return GroupSystem
+	}
+	if i := strings.IndexAny(name, "._:-/"); i > 0 {
+		return name[:i]
+	}
+	return GroupOther
+}
+
+// NewDashboardView groups metrics matching the search (case-insensitive substring of series key),
+// groups are sorted by name, so are metrics within a group
+func NewDashboardView(list []domain.Metric, opts DashboardOptions) DashboardView {
+	view := DashboardView{
+		Options:        opts,
+		Total:          len(list),
+		RefreshSeconds: int(opts.Refresh / time.Second),
+		RefreshSelect:  Select{Name: "refresh", Label: "Refresh"},
+	}
+	for _, choice := range refreshChoices {
+		label := "off"
+		if choice > 0 {
+			label = shortDuration(choice)
+		}
+		view.RefreshSelect.Options = append(view.RefreshSelect.Options, Choice{
+			Value:    strconv.Itoa(int(choice / time.Second)),
+			Label:    label,
+			Selected: choice == opts.Refresh,
+		})
+	}
+
+	search := strings.ToLower(strings.TrimSpace(opts.Search))
+	groups := make(map[string][]MetricView)
+	for _, metric := range list {
+		if search != "" && !strings.Contains(strings.ToLower(metric.Key()), search) {
+			continue
+		}
+		group := groupOf(metric.Name)
+		groups[group] = append(groups[group], newMetricView(metric, opts))
+		view.Shown++
+	}
+	for name, metrics := range groups {
+		sort.Slice(metrics, func(i, j int) bool {
+			nameI, nameJ := strings.ToLower(metrics[i].Name), strings.ToLower(metrics[j].Name)
+			if nameI != nameJ {
+				return nameI < nameJ
+			}
+			return metrics[i].Labels < metrics[j].Labels
+		})
+		view.Groups = append(view.Groups, MetricGroup{Name: name, Metrics: metrics})
+	}
+	sort.Slice(view.Groups, func(i, j int) bool {
+		return view.Groups[i].Name < view.Groups[j].Name
+	})
+	return view
+}
+
+// NewMetricPageView renders history of metric as sparkline and chart, historyErr is shown instead if it is set
+func NewMetricPageView(
+	metric domain.Metric,
+	samples []domain.Sample,
+	historyErr error,
+	opts DashboardOptions,
+) MetricPageView {
+	view := MetricPageView{
+		Options:     opts,
+		Metric:      newMetricView(metric, opts),
+		Group:       groupOf(metric.Name),
+		Samples:     len(samples),
+		RangeSelect: Select{Name: "range", Label: "Range"},
+	}
+	for _, choice := range rangeChoices {
+		view.RangeSelect.Options = append(view.RangeSelect.Options, Choice{
+			Value:    shortDuration(choice),
+			Label:    shortDuration(choice),
+			Selected: choice == opts.Range,
+		})
+	}
+	if historyErr != nil {
+		view.HistoryError = historyErr.Error()
+		return view
+	}
+
+	unit := unitOf(metric.Name)
+	if len(samples) > 0 {
+		low, high := samples[0].Value, samples[0].Value
+		for _, sample := range samples {
+			if sample.Value < low {
+				low = sample.Value
+			}
+			if sample.Value > high {
+				high = sample.Value
+			}
+		}
+		view.Min, view.Max = formatUnit(low, unit), formatUnit(high, unit)
+	}
+	view.Sparkline = renderSparkline(samples, sparklineWidth, sparklineHeight)
+	view.Chart = renderChart(samples, unit, chartWidth, chartHeight)
+	return view
+}
+
+func newMetricView(metric domain.Metric, opts DashboardOptions) MetricView {
+	view := MetricView{
+		Name:        metric.Name,
+		Type:        metric.Type,
+		Labels:      metric.Labels.String(),
+		LabelsQuery: labelsQuery(metric.Labels),
+		Key:         metric.Key(),
+		Unit:        unitOf(metric.Name),
+		Value:       formatHuman(metric),
+		Raw:         formatValue(metric),
+		URL:         opts.BasePath + "/" + url.PathEscape(metric.Name),
+	}
+	if view.LabelsQuery != "" {
+		view.URL += "?" + url.Values{"labels": {view.LabelsQuery}}.Encode()
+	}
+	return view
+}
+
+func labelsQuery(labels domain.Labels) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// shortDuration formats duration without zero units, e.g. '1h' instead of '1h0m0s'
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
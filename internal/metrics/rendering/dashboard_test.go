@@ -0,0 +1,138 @@
+package rendering
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/templating"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+	"eridiumdev/yandex-praktikum-go-devops/web"
+)
+
+func TestFormatHuman(t *testing.T) {
+	tests := []struct {
+		metric domain.Metric
+		want   string
+	}{
+		{metric: domain.NewGauge(domain.Alloc, 512), want: "512 B"},
+		{metric: domain.NewGauge(domain.HeapSys, 1.5*1024*1024), want: "1.5 MiB"},
+		{metric: domain.NewGauge("rx_bytes", 3*1024*1024*1024), want: "3.0 GiB"},
+		{metric: domain.NewGauge(domain.CPUutilization1, 12.345), want: "12.3%"},
+		{metric: domain.NewGauge(domain.GCCPUFraction, 0.0123), want: "1.23%"},
+		{metric: domain.NewGauge(domain.PauseTotalNs, 1234567), want: "1.235ms"},
+		{metric: domain.NewGauge("request_seconds", 90.4), want: "1m30s"},
+		{metric: domain.NewGauge(domain.LastGC, 0), want: "never"},
+		{metric: domain.NewGauge(domain.LastGC, 1.6e18), want: "2020-09-13T12:26:40Z"},
+		{metric: domain.NewCounter(domain.PollCount, 5), want: "5"},
+		{metric: domain.NewGauge(domain.RandomValue, 0.1234567), want: "0.1234567"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metric.Name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatHuman(tt.metric))
+		})
+	}
+}
+
+func TestNewDashboardView(t *testing.T) {
+	labelled := domain.NewGauge("http_requests", 1)
+	labelled.Labels = domain.Labels{"host": "a", "path": "/"}
+	list := []domain.Metric{
+		domain.NewGauge(domain.HeapAlloc, 2048),
+		domain.NewGauge(domain.Alloc, 1024),
+		domain.NewCounter(domain.PollCount, 5),
+		domain.NewGauge(domain.CPUutilization1, 50),
+		domain.NewGauge("CPUutilization2", 40),
+		labelled,
+		domain.NewGauge("Custom", 1),
+	}
+
+	view := NewDashboardView(list, DashboardOptions{BasePath: "/dashboard", Refresh: 10 * time.Second})
+	assert.Equal(t, 7, view.Shown)
+	assert.Equal(t, 10, view.RefreshSeconds)
+
+	groups := make(map[string][]string)
+	names := make([]string, 0)
+	for _, group := range view.Groups {
+		names = append(names, group.Name)
+		for _, metric := range group.Metrics {
+			groups[group.Name] = append(groups[group.Name], metric.Name)
+		}
+	}
+	assert.Equal(t, []string{GroupAgent, "http", GroupOther, GroupRuntime, GroupSystem}, names)
+	assert.Equal(t, []string{domain.Alloc, domain.HeapAlloc}, groups[GroupRuntime])
+	assert.Equal(t, []string{domain.CPUutilization1, "CPUutilization2"}, groups[GroupSystem])
+
+	requests := view.Groups[1].Metrics[0]
+	assert.Equal(t, "/dashboard/http_requests?labels=host%3Da%2Cpath%3D%2F", requests.URL)
+	assert.Equal(t, "host=a,path=/", requests.LabelsQuery)
+
+	// Search matches series keys, including labels
+	view = NewDashboardView(list, DashboardOptions{Search: "HOST=\"A"})
+	assert.Equal(t, 1, view.Shown)
+	assert.Equal(t, 7, view.Total)
+}
+
+func TestRenderSVG(t *testing.T) {
+	start := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	samples := []domain.Sample{
+		{Timestamp: start, Value: 10},
+		{Timestamp: start.Add(time.Minute), Value: 30},
+		{Timestamp: start.Add(2 * time.Minute), Value: 20},
+	}
+
+	sparkline := string(renderSparkline(samples, 100, 20))
+	assert.Contains(t, sparkline, `<polyline points="1.0,19.0 50.0,1.0 99.0,10.0"`)
+
+	chart := string(renderChart(samples, UnitBytes, 400, 200))
+	assert.Contains(t, chart, ">10 B</text>")
+	assert.Contains(t, chart, ">30 B</text>")
+	assert.Contains(t, chart, "2022-10-01 12:02:00 UTC")
+	assert.Equal(t, 3, strings.Count(chart, "<circle"))
+
+	// Flat line is drawn in the middle, single sample is drawn at the right edge
+	sparkline = string(renderSparkline(samples[:1], 100, 20))
+	assert.Contains(t, sparkline, `<polyline points="99.0,10.0"`)
+	assert.Contains(t, string(renderChart(nil, UnitNone, 400, 200)), "no data")
+}
+
+func TestRenderDashboardTemplates(t *testing.T) {
+	parser, err := templating.NewHTMLTemplateParser(context.Background(), web.Templates(), "", false)
+	require.NoError(t, err)
+	engine := NewHTMLEngine(parser)
+	metric := domain.NewGauge(domain.Alloc, 1024)
+	metric.Labels = domain.Labels{"host": "<a>"}
+	opts := DashboardOptions{BasePath: "/dashboard", Search: "alloc", Refresh: 5 * time.Second, Range: time.Hour}
+
+	body, err := engine.RenderDashboard([]domain.Metric{metric, domain.NewCounter(domain.PollCount, 5)}, opts)
+	require.NoError(t, err)
+	page := string(body)
+	assert.Contains(t, page, `<meta http-equiv="refresh" content="5">`)
+	assert.Contains(t, page, `<a href="/dashboard/Alloc?labels=host%3D%3Ca%3E">Alloc</a>`)
+	assert.Contains(t, page, "1.0 KiB")
+	assert.Contains(t, page, `host=&#34;&lt;a&gt;&#34;`)
+	assert.NotContains(t, page, domain.PollCount)
+
+	samples := []domain.Sample{{Timestamp: time.Now().Add(-time.Minute), Value: 512}, {Timestamp: time.Now(), Value: 1024}}
+	body, err = engine.RenderMetricPage(metric, samples, nil, opts)
+	require.NoError(t, err)
+	page = string(body)
+	assert.Contains(t, page, `<svg class="sparkline"`)
+	assert.Contains(t, page, `<svg class="chart"`)
+	assert.Contains(t, page, `<option value="1h" selected>`)
+	assert.Contains(t, page, `<input type="hidden" name="labels" value="host=&lt;a&gt;">`)
+
+	body, err = engine.RenderMetricPage(metric, nil, errors.New("history is not kept"), opts)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "History is not available: history is not kept")
+	assert.NotContains(t, string(body), "<svg")
+
+	// Metrics list template is still available along with the dashboard ones
+	_, err = engine.RenderList([]domain.Metric{metric})
+	require.NoError(t, err)
+}
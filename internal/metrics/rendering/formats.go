@@ -0,0 +1,49 @@
+package rendering
+
+import (
+	"strconv"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+const (
+	ContentTypeHTML = "text/html; charset=utf-8"
+	ContentTypeJSON = "application/json; charset=utf-8"
+	ContentTypeCSV  = "text/csv; charset=utf-8"
+	ContentTypeText = "text/plain; charset=utf-8"
+)
+
+// Format is a representation of metrics list, it is chosen by name (e.g. '?format=csv') or negotiated by media type
+type Format struct {
+	Name        string
+	ContentType string
+	// MediaType is matched against Accept header, Version distinguishes formats of the same media type
+	// (e.g. Prometheus text format is 'text/plain; version=0.0.4', while plain text has no version)
+	MediaType string
+	Version   string
+}
+
+var (
+	FormatHTML       = Format{Name: "html", ContentType: ContentTypeHTML, MediaType: "text/html"}
+	FormatJSON       = Format{Name: "json", ContentType: ContentTypeJSON, MediaType: "application/json"}
+	FormatCSV        = Format{Name: "csv", ContentType: ContentTypeCSV, MediaType: "text/csv"}
+	FormatText       = Format{Name: "text", ContentType: ContentTypeText, MediaType: "text/plain"}
+	FormatPrometheus = Format{
+		Name:        "prometheus",
+		ContentType: ContentTypePrometheus,
+		MediaType:   "text/plain",
+		Version:     "0.0.4",
+	}
+)
+
+// formatValue formats value of metric without losing precision, histograms are formatted as 'count=N sum=S'
+func formatValue(metric domain.Metric) string {
+	switch metric.Type {
+	case domain.TypeCounter:
+		return strconv.FormatInt(int64(metric.Counter), 10)
+	case domain.TypeGauge:
+		return formatPrometheusFloat(float64(metric.Gauge))
+	default:
+		return metric.StringValue()
+	}
+}
@@ -0,0 +1,58 @@
+package rendering
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+func TestRenderList(t *testing.T) {
+	labelled := domain.NewGauge(domain.Alloc, 0.1234567)
+	labelled.Labels = domain.Labels{"host": "a", "env": "prod"}
+	list := []domain.Metric{
+		labelled,
+		domain.NewCounter(domain.PollCount, 5),
+		domain.NewHistogram(domain.PauseNs,
+			&domain.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Sum: 2.5, Count: 2}),
+	}
+	tests := []struct {
+		name     string
+		renderer interface {
+			RenderList(list []domain.Metric) ([]byte, error)
+		}
+		want string
+	}{
+		{
+			name:     "json",
+			renderer: NewJSONEngine(),
+			want: `{"metrics":[{"id":"Alloc","type":"gauge","value":0.1234567,"labels":{"env":"prod","host":"a"}},` +
+				`{"id":"PollCount","type":"counter","delta":5},` +
+				`{"id":"PauseNs","type":"histogram","histogram":{"bounds":[1],"counts":[1,1],"sum":2.5,"count":2}}]}`,
+		},
+		{
+			name:     "csv",
+			renderer: NewCSVEngine(),
+			want: "name,type,labels,value\n" +
+				"Alloc,gauge,\"env=\"\"prod\"\",host=\"\"a\"\"\",0.1234567\n" +
+				"PollCount,counter,,5\n" +
+				"PauseNs,histogram,,count=2 sum=2.5\n",
+		},
+		{
+			name:     "text",
+			renderer: NewTextEngine(),
+			want: "Alloc{env=\"prod\",host=\"a\"} 0.1234567\n" +
+				"PollCount 5\n" +
+				"PauseNs count=2 sum=2.5\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := tt.renderer.RenderList(list)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
@@ -7,6 +7,8 @@ import (
 
 const (
 	metricsListTemplate = "metrics-list.html"
+	dashboardTemplate   = "dashboard.html"
+	metricPageTemplate  = "metric.html"
 )
 
 type htmlEngine struct {
@@ -22,3 +24,16 @@ func NewHTMLEngine(templateParser *templating.HTMLTemplateParser) *htmlEngine {
 func (e *htmlEngine) RenderList(list []domain.Metric) ([]byte, error) {
 	return e.templateParser.Parse(metricsListTemplate, list)
 }
+
+func (e *htmlEngine) RenderDashboard(list []domain.Metric, opts DashboardOptions) ([]byte, error) {
+	return e.templateParser.Parse(dashboardTemplate, NewDashboardView(list, opts))
+}
+
+func (e *htmlEngine) RenderMetricPage(
+	metric domain.Metric,
+	samples []domain.Sample,
+	historyErr error,
+	opts DashboardOptions,
+) ([]byte, error) {
+	return e.templateParser.Parse(metricPageTemplate, NewMetricPageView(metric, samples, historyErr, opts))
+}
@@ -0,0 +1,22 @@
+package rendering
+
+import (
+	"encoding/json"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+type jsonEngine struct{}
+
+func NewJSONEngine() *jsonEngine {
+	return &jsonEngine{}
+}
+
+// RenderList renders metrics the same way as the JSON API lists them, i.e. '{"metrics":[...]}'
+func (e *jsonEngine) RenderList(list []domain.Metric) ([]byte, error) {
+	resp := domain.ListMetricsResponse{Metrics: make([]domain.GenericMetric, 0, len(list))}
+	for _, metric := range list {
+		resp.Metrics = append(resp.Metrics, domain.NewGenericMetric(metric))
+	}
+	return json.Marshal(resp)
+}
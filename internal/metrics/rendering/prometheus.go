@@ -0,0 +1,162 @@
+package rendering
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+const (
+	ContentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+type prometheusEngine struct{}
+
+func NewPrometheusEngine() *prometheusEngine {
+	return &prometheusEngine{}
+}
+
+// RenderList renders metrics in Prometheus text exposition format
+func (e *prometheusEngine) RenderList(list []domain.Metric) ([]byte, error) {
+	return e.RenderExposition(list, false)
+}
+
+// RenderExposition renders metrics in Prometheus text exposition format,
+// or in OpenMetrics text format if openMetrics is true
+// Series of the same metric must follow each other (e.g. list is sorted by name), so that they share TYPE line
+func (e *prometheusEngine) RenderExposition(list []domain.Metric, openMetrics bool) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	previous := ""
+	for _, metric := range list {
+		name := SanitizePrometheusName(metric.Name)
+		labels := formatPrometheusLabels(metric.Labels)
+		switch metric.Type {
+		case domain.TypeCounter:
+			if name != previous {
+				fmt.Fprintf(&buffer, "# TYPE %s counter\n", name)
+			}
+			sample := name
+			if openMetrics {
+				// OpenMetrics requires counter samples to have '_total' suffix
+				sample += "_total"
+			}
+			fmt.Fprintf(&buffer, "%s%s %d\n", sample, labels, int64(metric.Counter))
+		case domain.TypeGauge:
+			if name != previous {
+				fmt.Fprintf(&buffer, "# TYPE %s gauge\n", name)
+			}
+			fmt.Fprintf(&buffer, "%s%s %s\n", name, labels, formatPrometheusFloat(float64(metric.Gauge)))
+		case domain.TypeHistogram:
+			if metric.Histogram == nil {
+				continue
+			}
+			if name != previous {
+				fmt.Fprintf(&buffer, "# TYPE %s histogram\n", name)
+			}
+			renderPrometheusHistogram(&buffer, name, metric.Labels, metric.Histogram)
+		}
+		previous = name
+	}
+	if openMetrics {
+		buffer.WriteString("# EOF\n")
+	}
+	return buffer.Bytes(), nil
+}
+
+// renderPrometheusHistogram renders histogram as cumulative '_bucket' samples followed by '_sum' and '_count'
+func renderPrometheusHistogram(buffer *bytes.Buffer, name string, labels domain.Labels, histogram *domain.Histogram) {
+	var cumulative uint64
+	for i, count := range histogram.Counts {
+		cumulative += count
+		bound := math.Inf(1)
+		if i < len(histogram.Bounds) {
+			bound = histogram.Bounds[i]
+		}
+		bucketLabels := labels.Copy()
+		if bucketLabels == nil {
+			bucketLabels = make(domain.Labels)
+		}
+		bucketLabels["le"] = formatPrometheusFloat(bound)
+		fmt.Fprintf(buffer, "%s_bucket%s %d\n", name, formatPrometheusLabels(bucketLabels), cumulative)
+	}
+	formatted := formatPrometheusLabels(labels)
+	fmt.Fprintf(buffer, "%s_sum%s %s\n", name, formatted, formatPrometheusFloat(histogram.Sum))
+	fmt.Fprintf(buffer, "%s_count%s %d\n", name, formatted, histogram.Count)
+}
+
+// SanitizePrometheusName converts name to a valid Prometheus metric name, matching [a-zA-Z_:][a-zA-Z0-9_:]*
+func SanitizePrometheusName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	var builder strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			builder.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				// Names cannot start with a digit
+				builder.WriteRune('_')
+			}
+			builder.WriteRune(r)
+		default:
+			builder.WriteRune('_')
+		}
+	}
+	return builder.String()
+}
+
+// SanitizePrometheusLabelName converts name to a valid Prometheus label name, matching [a-zA-Z_][a-zA-Z0-9_]*
+func SanitizePrometheusLabelName(name string) string {
+	return strings.ReplaceAll(SanitizePrometheusName(name), ":", "_")
+}
+
+// formatPrometheusLabels formats labels as '{key="value",...}' sorted by key, or empty string if there are none
+func formatPrometheusLabels(labels domain.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(SanitizePrometheusLabelName(key))
+		builder.WriteString(`="`)
+		builder.WriteString(labelValueEscaper.Replace(labels[key]))
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+// labelValueEscaper escapes label values as required by exposition format
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatPrometheusFloat(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
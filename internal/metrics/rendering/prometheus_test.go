@@ -0,0 +1,107 @@
+package rendering
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+func TestRenderExposition(t *testing.T) {
+	list := []domain.Metric{
+		domain.NewGauge(domain.Alloc, 10.5),
+		domain.NewGauge("cpu.usage-1", domain.Gauge(math.Inf(1))),
+		domain.NewCounter(domain.PollCount, 5),
+	}
+	tests := []struct {
+		name        string
+		openMetrics bool
+		want        string
+	}{
+		{
+			name:        "prometheus text format",
+			openMetrics: false,
+			want: "# TYPE Alloc gauge\nAlloc 10.5\n" +
+				"# TYPE cpu_usage_1 gauge\ncpu_usage_1 +Inf\n" +
+				"# TYPE PollCount counter\nPollCount 5\n",
+		},
+		{
+			name:        "openmetrics text format",
+			openMetrics: true,
+			want: "# TYPE Alloc gauge\nAlloc 10.5\n" +
+				"# TYPE cpu_usage_1 gauge\ncpu_usage_1 +Inf\n" +
+				"# TYPE PollCount counter\nPollCount_total 5\n" +
+				"# EOF\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewPrometheusEngine().RenderExposition(list, tt.openMetrics)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(result))
+		})
+	}
+}
+
+func TestRenderExpositionWithLabels(t *testing.T) {
+	list := []domain.Metric{
+		{Name: domain.Alloc, Type: domain.TypeGauge, Gauge: 1, Labels: domain.Labels{"host": "a", "env": "prod"}},
+		{Name: domain.Alloc, Type: domain.TypeGauge, Gauge: 2, Labels: domain.Labels{"host": `b"1\n`}},
+		{Name: domain.PollCount, Type: domain.TypeCounter, Counter: 3, Labels: domain.Labels{"agent.id": "x"}},
+	}
+	want := "# TYPE Alloc gauge\n" +
+		`Alloc{env="prod",host="a"} 1` + "\n" +
+		`Alloc{host="b\"1\\n"} 2` + "\n" +
+		"# TYPE PollCount counter\n" +
+		`PollCount_total{agent_id="x"} 3` + "\n" +
+		"# EOF\n"
+
+	result, err := NewPrometheusEngine().RenderExposition(list, true)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(result))
+}
+
+func TestRenderExpositionHistogram(t *testing.T) {
+	histogram := &domain.Histogram{Bounds: []float64{0.5, 1}, Counts: []uint64{1, 2, 1}, Sum: 4.25, Count: 4}
+	list := []domain.Metric{
+		domain.NewHistogram(domain.PauseNs, histogram),
+		{Name: domain.PauseNs, Type: domain.TypeHistogram, Histogram: histogram, Labels: domain.Labels{"host": "a"}},
+	}
+	want := "# TYPE PauseNs histogram\n" +
+		`PauseNs_bucket{le="0.5"} 1` + "\n" +
+		`PauseNs_bucket{le="1"} 3` + "\n" +
+		`PauseNs_bucket{le="+Inf"} 4` + "\n" +
+		"PauseNs_sum 4.25\n" +
+		"PauseNs_count 4\n" +
+		`PauseNs_bucket{host="a",le="0.5"} 1` + "\n" +
+		`PauseNs_bucket{host="a",le="1"} 3` + "\n" +
+		`PauseNs_bucket{host="a",le="+Inf"} 4` + "\n" +
+		`PauseNs_sum{host="a"} 4.25` + "\n" +
+		`PauseNs_count{host="a"} 4` + "\n"
+
+	result, err := NewPrometheusEngine().RenderExposition(list, false)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(result))
+}
+
+func TestSanitizePrometheusName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Alloc", want: "Alloc"},
+		{name: "http_requests:rate5m", want: "http_requests:rate5m"},
+		{name: "cpu.usage-1", want: "cpu_usage_1"},
+		{name: "1stMetric", want: "_1stMetric"},
+		{name: "метрика", want: "_______"},
+		{name: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SanitizePrometheusName(tt.name))
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package rendering
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// Margins of chart plot area, left one fits value labels, bottom one fits time labels
+const (
+	chartMarginLeft   = 80
+	chartMarginRight  = 10
+	chartMarginTop    = 10
+	chartMarginBottom = 24
+	chartGridLines    = 4
+)
+
+// plot maps samples to coordinates within [x0, x1] x [y0, y1] area (y grows downwards, as in SVG)
+type plot struct {
+	samples  []domain.Sample
+	from, to int64
+	min, max float64
+	x0, x1   float64
+	y0, y1   float64
+}
+
+func newPlot(samples []domain.Sample, x0, y0, x1, y1 float64) plot {
+	p := plot{
+		samples: samples,
+		from:    samples[0].Timestamp.UnixNano(),
+		to:      samples[len(samples)-1].Timestamp.UnixNano(),
+		min:     samples[0].Value,
+		max:     samples[0].Value,
+		x0:      x0,
+		x1:      x1,
+		y0:      y0,
+		y1:      y1,
+	}
+	for _, sample := range samples {
+		if sample.Value < p.min {
+			p.min = sample.Value
+		}
+		if sample.Value > p.max {
+			p.max = sample.Value
+		}
+	}
+	// Flat line is drawn in the middle
+	if p.min == p.max {
+		p.min--
+		p.max++
+	}
+	return p
+}
+
+func (p plot) x(sample domain.Sample) float64 {
+	if p.from == p.to {
+		return p.x1
+	}
+	return p.x0 + (p.x1-p.x0)*float64(sample.Timestamp.UnixNano()-p.from)/float64(p.to-p.from)
+}
+
+func (p plot) y(value float64) float64 {
+	return p.y1 - (p.y1-p.y0)*(value-p.min)/(p.max-p.min)
+}
+
+func (p plot) points() string {
+	points := make([]string, 0, len(p.samples))
+	for _, sample := range p.samples {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", p.x(sample), p.y(sample.Value)))
+	}
+	return strings.Join(points, " ")
+}
+
+// renderSparkline renders samples as a line without axes, e.g. to show a trend next to the current value
+func renderSparkline(samples []domain.Sample, width, height int) template.HTML {
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg class="sparkline" xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" `+
+		`viewBox="0 0 %d %d" role="img">`, width, height, width, height)
+	if len(samples) > 0 {
+		p := newPlot(samples, 1, 1, float64(width-1), float64(height-1))
+		last := samples[len(samples)-1]
+		fmt.Fprintf(&svg, `<polyline points="%s" fill="none" stroke="currentColor" stroke-width="1.5"/>`, p.points())
+		fmt.Fprintf(&svg, `<circle cx="%.1f" cy="%.1f" r="2" fill="currentColor"/>`, p.x(last), p.y(last.Value))
+	}
+	svg.WriteString(`</svg>`)
+	// Markup is generated above, only numbers and escaped labels are interpolated
+	return template.HTML(svg.String())
+}
+
+// renderChart renders samples as a line chart with value grid (labelled in given unit) and time range (in UTC)
+func renderChart(samples []domain.Sample, unit string, width, height int) template.HTML {
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg class="chart" xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" `+
+		`viewBox="0 0 %d %d" role="img">`, width, height, width, height)
+	if len(samples) == 0 {
+		fmt.Fprintf(&svg, `<text x="%d" y="%d" text-anchor="middle">no data in this range</text>`, width/2, height/2)
+		svg.WriteString(`</svg>`)
+		return template.HTML(svg.String())
+	}
+
+	p := newPlot(samples, chartMarginLeft, chartMarginTop,
+		float64(width-chartMarginRight), float64(height-chartMarginBottom))
+	for i := 0; i <= chartGridLines; i++ {
+		value := p.min + (p.max-p.min)*float64(i)/chartGridLines
+		y := p.y(value)
+		fmt.Fprintf(&svg, `<line class="grid" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/>`, p.x0, y, p.x1, y)
+		fmt.Fprintf(&svg, `<text x="%.1f" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`,
+			p.x0-6, y, template.HTMLEscapeString(formatUnit(value, unit)))
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	fmt.Fprintf(&svg, `<text x="%.1f" y="%d" text-anchor="start">%s</text>`,
+		p.x0, height-6, first.Timestamp.UTC().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&svg, `<text x="%.1f" y="%d" text-anchor="end">%s UTC</text>`,
+		p.x1, height-6, last.Timestamp.UTC().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&svg, `<polyline points="%s" fill="none" stroke="currentColor" stroke-width="2"/>`, p.points())
+	for _, sample := range samples {
+		fmt.Fprintf(&svg, `<circle cx="%.1f" cy="%.1f" r="2" fill="currentColor"><title>%s: %s</title></circle>`,
+			p.x(sample), p.y(sample.Value), sample.Timestamp.UTC().Format("15:04:05"),
+			template.HTMLEscapeString(formatUnit(sample.Value, unit)))
+	}
+	svg.WriteString(`</svg>`)
+	return template.HTML(svg.String())
+}
@@ -0,0 +1,23 @@
+package rendering
+
+import (
+	"bytes"
+	"fmt"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+type textEngine struct{}
+
+func NewTextEngine() *textEngine {
+	return &textEngine{}
+}
+
+// RenderList renders metrics as 'name value' lines, series with labels are named by their key, e.g. 'Alloc{host="a"}'
+func (e *textEngine) RenderList(list []domain.Metric) ([]byte, error) {
+	var buffer bytes.Buffer
+	for _, metric := range list {
+		fmt.Fprintf(&buffer, "%s %s\n", metric.Key(), formatValue(metric))
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,134 @@
+package rendering
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// Units of metric values, they are only used to display values in human-readable form
+const (
+	UnitNone    = ""
+	UnitBytes   = "bytes"
+	UnitPercent = "percent"
+	// UnitRatio is a fraction in [0, 1], it is displayed as percent
+	UnitRatio       = "ratio"
+	UnitNanoseconds = "ns"
+	UnitSeconds     = "s"
+	// UnitTimestamp is unix time in nanoseconds
+	UnitTimestamp = "timestamp"
+)
+
+var metricUnits = map[string]string{
+	domain.Alloc:         UnitBytes,
+	domain.BuckHashSys:   UnitBytes,
+	domain.GCCPUFraction: UnitRatio,
+	domain.GCSys:         UnitBytes,
+	domain.HeapAlloc:     UnitBytes,
+	domain.HeapIdle:      UnitBytes,
+	domain.HeapInuse:     UnitBytes,
+	domain.HeapReleased:  UnitBytes,
+	domain.HeapSys:       UnitBytes,
+	domain.LastGC:        UnitTimestamp,
+	domain.MCacheInuse:   UnitBytes,
+	domain.MCacheSys:     UnitBytes,
+	domain.MSpanInuse:    UnitBytes,
+	domain.MSpanSys:      UnitBytes,
+	domain.NextGC:        UnitBytes,
+	domain.OtherSys:      UnitBytes,
+	domain.PauseNs:       UnitNanoseconds,
+	domain.PauseTotalNs:  UnitNanoseconds,
+	domain.StackInuse:    UnitBytes,
+	domain.StackSys:      UnitBytes,
+	domain.Sys:           UnitBytes,
+	domain.TotalAlloc:    UnitBytes,
+	domain.TotalMemory:   UnitBytes,
+	domain.FreeMemory:    UnitBytes,
+}
+
+// unitOf returns unit of known metrics, other metrics are recognized by name suffix (e.g. 'rx_bytes', 'LatencyNs')
+func unitOf(name string) string {
+	if unit, ok := metricUnits[name]; ok {
+		return unit
+	}
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(name, "CPUutilization"):
+		return UnitPercent
+	case strings.HasSuffix(lower, "bytes"):
+		return UnitBytes
+	case strings.HasSuffix(lower, "percent"):
+		return UnitPercent
+	case strings.HasSuffix(lower, "_ratio"):
+		return UnitRatio
+	case strings.HasSuffix(name, "Ns") || strings.HasSuffix(lower, "_ns"):
+		return UnitNanoseconds
+	case strings.HasSuffix(lower, "_seconds"):
+		return UnitSeconds
+	}
+	return UnitNone
+}
+
+// formatHuman formats value of metric in its unit, histograms and metrics without unit are formatted as is
+func formatHuman(metric domain.Metric) string {
+	unit := unitOf(metric.Name)
+	if metric.IsHistogram() || unit == UnitNone {
+		return formatValue(metric)
+	}
+	return formatUnit(metric.FloatValue(), unit)
+}
+
+// formatUnit formats value in given unit, e.g. '1.5 MiB', '12.5%', '1.2ms'
+func formatUnit(value float64, unit string) string {
+	switch unit {
+	case UnitBytes:
+		return formatBytes(value)
+	case UnitPercent:
+		return fmt.Sprintf("%.1f%%", value)
+	case UnitRatio:
+		return fmt.Sprintf("%.2f%%", value*100)
+	case UnitNanoseconds:
+		return formatDuration(time.Duration(value))
+	case UnitSeconds:
+		return formatDuration(time.Duration(value * float64(time.Second)))
+	case UnitTimestamp:
+		if value <= 0 {
+			return "never"
+		}
+		return time.Unix(0, int64(value)).UTC().Format(time.RFC3339)
+	default:
+		return strconv.FormatFloat(value, 'g', 6, 64)
+	}
+}
+
+// formatBytes formats size with binary prefixes, e.g. '512 B', '1.5 KiB'
+func formatBytes(value float64) string {
+	const prefixes = "KMGTPE"
+	if math.Abs(value) < 1024 {
+		return fmt.Sprintf("%.0f B", value)
+	}
+	i := -1
+	for math.Abs(value) >= 1024 && i < len(prefixes)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, prefixes[i])
+}
+
+// formatDuration rounds duration, so that at most 3 fractional digits are shown, e.g. '1.235ms', not '1.234567ms'
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	default:
+		return d.String()
+	}
+}
@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// ErrWriteUnconfirmed is wrapped by backing repositories if write might have been applied despite the error,
+// e.g. connection was lost on commit
+var ErrWriteUnconfirmed = errors.New("write might have been applied")
+
+// cachedRepo keeps current values of metrics in memory in front of backing repository (database)
+// Writes go through to the backing repository first, then the cache is updated with stored values,
+// writes are atomic, so failed ones leave cached values as they are (and reads keep working while database is down)
+// Reads are served from the cache, series that are not cached are loaded on demand
+// History and rollups are not cached, they are forwarded to the backing repository
+type cachedRepo struct {
+	BackingRepository
+	invalidator CacheInvalidator
+
+	metrics map[string]domain.Metric
+	// complete is set when all metrics are cached, so that List can be served from the cache
+	complete bool
+	// version is bumped on every write and invalidation, values read from backing repository
+	// are only cached if version did not change meanwhile (otherwise they may already be stale)
+	version uint64
+	// pending counts writes in flight per series, if writes to the same series overlap (dirty),
+	// the order of their results is unknown, so the series is evicted instead of updated
+	pending map[string]int
+	dirty   map[string]bool
+	mutex   *sync.RWMutex
+}
+
+func NewCachedRepo(
+	ctx context.Context,
+	repo BackingRepository,
+	cfg config.CacheConfig,
+	invalidator CacheInvalidator,
+) (*cachedRepo, error) {
+	r := &cachedRepo{
+		BackingRepository: repo,
+		invalidator:       invalidator,
+		metrics:           make(map[string]domain.Metric),
+		pending:           make(map[string]int),
+		dirty:             make(map[string]bool),
+		mutex:             &sync.RWMutex{},
+	}
+	if cfg.Warm {
+		if _, err := r.loadAll(ctx); err != nil {
+			return nil, err
+		}
+		logger.New(ctx).Infof("[cached repo] warmed up, metrics count = %d", len(r.metrics))
+	}
+	if invalidator != nil {
+		go invalidator.Listen(ctx, r.invalidate)
+	}
+	return r, nil
+}
+
+func (r *cachedRepo) Store(ctx context.Context, metrics ...domain.Metric) error {
+	keys := r.startWrite(metrics)
+	err := r.BackingRepository.Store(ctx, metrics...)
+	r.finishWrite(ctx, keys, metrics, err)
+	return err
+}
+
+func (r *cachedRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	keys := r.startWrite(metrics)
+	updated, err := r.BackingRepository.Increment(ctx, metrics...)
+	r.finishWrite(ctx, keys, updated, err)
+	return updated, err
+}
+
+// Delete removes series from the cache regardless of the result, as the delete might have been applied partially
+// Other replicas invalidate everything, as they might have cached series that were not cached here
+func (r *cachedRepo) Delete(ctx context.Context, names ...string) (int, error) {
+	deleted, err := r.BackingRepository.Delete(ctx, names...)
+	r.evict(ctx, func(metric domain.Metric) bool {
+		return sliceContains(names, metric.Name)
+	})
+	return deleted, err
+}
+
+// Expire drops the whole cache, as the cache does not know which series are stale
+func (r *cachedRepo) Expire(ctx context.Context, before time.Time) (int, error) {
+	deleted, err := r.BackingRepository.Expire(ctx, before)
+	if deleted > 0 || err != nil {
+		r.evict(ctx, func(metric domain.Metric) bool {
+			return true
+		})
+	}
+	return deleted, err
+}
+
+func (r *cachedRepo) Get(ctx context.Context, name string, labels domain.Labels) (domain.Metric, bool, error) {
+	key := domain.SeriesKey(name, labels)
+
+	r.mutex.RLock()
+	metric, found := r.metrics[key]
+	version := r.version
+	r.mutex.RUnlock()
+	if found {
+		return metric, true, nil
+	}
+
+	metric, found, err := r.BackingRepository.Get(ctx, name, labels)
+	if err != nil || !found {
+		return metric, found, err
+	}
+
+	r.mutex.Lock()
+	if r.version == version {
+		r.metrics[key] = metric
+	}
+	r.mutex.Unlock()
+	return metric, true, nil
+}
+
+func (r *cachedRepo) List(ctx context.Context, filter *domain.MetricsFilter) ([]domain.Metric, error) {
+	r.mutex.RLock()
+	if !r.complete {
+		r.mutex.RUnlock()
+		metrics, err := r.loadAll(ctx)
+		if err != nil {
+			return metrics, err
+		}
+		return filterMetrics(metrics, filter)
+	}
+	defer r.mutex.RUnlock()
+
+	result := make([]domain.Metric, 0, len(r.metrics))
+	for _, metric := range r.metrics {
+		result = append(result, metric)
+	}
+	return filterMetrics(result, filter)
+}
+
+// loadAll reads all metrics from backing repository and caches them, unless they were changed meanwhile
+func (r *cachedRepo) loadAll(ctx context.Context) ([]domain.Metric, error) {
+	r.mutex.RLock()
+	version := r.version
+	r.mutex.RUnlock()
+
+	metrics, err := r.BackingRepository.List(ctx, nil)
+	if err != nil {
+		return metrics, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.version == version {
+		r.metrics = make(map[string]domain.Metric, len(metrics))
+		for _, metric := range metrics {
+			r.metrics[metric.Key()] = metric
+		}
+		r.complete = true
+	}
+	return metrics, nil
+}
+
+func (r *cachedRepo) startWrite(metrics []domain.Metric) []string {
+	keys := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		keys = append(keys, metric.Key())
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.version++
+	for _, key := range keys {
+		r.pending[key]++
+		if r.pending[key] > 1 {
+			r.dirty[key] = true
+		}
+	}
+	return keys
+}
+
+// finishWrite updates cache with stored metrics (or evicts them if result of the write is unknown,
+// i.e. it was not confirmed or overlapped with another one), then notifies other replicas
+func (r *cachedRepo) finishWrite(ctx context.Context, keys []string, stored []domain.Metric, err error) {
+	unconfirmed := errors.Is(err, ErrWriteUnconfirmed)
+	r.mutex.Lock()
+	for i, key := range keys {
+		switch {
+		case unconfirmed || r.dirty[key]:
+			// Write might have been applied, or its result might be older than the cached one
+			delete(r.metrics, key)
+			r.complete = false
+		case err != nil:
+			// Write was not applied, cached value is still current
+		default:
+			r.metrics[key] = stored[i]
+		}
+		r.pending[key]--
+		if r.pending[key] == 0 {
+			delete(r.pending, key)
+			delete(r.dirty, key)
+		}
+	}
+	r.mutex.Unlock()
+
+	if (err == nil || unconfirmed) && r.invalidator != nil {
+		if notifyErr := r.invalidator.Notify(ctx, keys); notifyErr != nil {
+			logger.New(ctx).Errorf("[cached repo] error when notifying other replicas: %s", notifyErr.Error())
+		}
+	}
+}
+
+// evict removes matching series from the cache and tells other replicas to invalidate everything
+func (r *cachedRepo) evict(ctx context.Context, match func(metric domain.Metric) bool) {
+	r.mutex.Lock()
+	r.version++
+	for key, metric := range r.metrics {
+		if match(metric) {
+			delete(r.metrics, key)
+			// Evicted series might still exist (e.g. only stale ones were expired), so List reloads them
+			r.complete = false
+		}
+	}
+	r.mutex.Unlock()
+
+	if r.invalidator != nil {
+		if err := r.invalidator.Notify(ctx, nil); err != nil {
+			logger.New(ctx).Errorf("[cached repo] error when notifying other replicas: %s", err.Error())
+		}
+	}
+}
+
+// invalidate evicts series changed by other replicas, all series are evicted if keys are nil
+func (r *cachedRepo) invalidate(keys []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.version++
+	// New series might have been added, so List cannot be served from cache anymore
+	r.complete = false
+	if keys == nil {
+		r.metrics = make(map[string]domain.Metric)
+		return
+	}
+	for _, key := range keys {
+		delete(r.metrics, key)
+	}
+}
+
+func filterMetrics(metrics []domain.Metric, filter *domain.MetricsFilter) ([]domain.Metric, error) {
+	if filter.IsEmpty() {
+		return metrics, nil
+	}
+	matches, err := filter.Matcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "[cached repo] invalid filter")
+	}
+	result := make([]domain.Metric, 0)
+	for _, metric := range metrics {
+		if matches(metric) {
+			result = append(result, metric)
+		}
+	}
+	return result, nil
+}
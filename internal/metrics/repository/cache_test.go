@@ -0,0 +1,253 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// testInvalidator records notifications and exposes invalidate func of the listening repo
+type testInvalidator struct {
+	notified   [][]string
+	invalidate func(keys []string)
+	mutex      sync.Mutex
+}
+
+func (i *testInvalidator) Notify(ctx context.Context, keys []string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.notified = append(i.notified, keys)
+	return nil
+}
+
+func (i *testInvalidator) Listen(ctx context.Context, invalidate func(keys []string)) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.invalidate = invalidate
+}
+
+func (i *testInvalidator) listening() bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.invalidate != nil
+}
+
+func TestCachedRepoReads(t *testing.T) {
+	tests := []struct {
+		name string
+		warm bool
+	}{
+		{name: "warmed up on startup", warm: true},
+		{name: "loaded on first read", warm: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			backing := &flakyRepo{inMemRepo: NewInMemRepo()}
+			require.NoError(t, backing.Store(ctx, domain.NewCounter(domain.PollCount, 5), domain.NewGauge(domain.Alloc, 1)))
+
+			repo, err := NewCachedRepo(ctx, backing, config.CacheConfig{Warm: tt.warm}, nil)
+			require.NoError(t, err)
+
+			list, err := repo.List(ctx, nil)
+			require.NoError(t, err)
+			assert.Len(t, list, 2)
+
+			// Reads are served from cache, even when backing repo is unavailable
+			backing.setDown(true)
+			metric, found, err := repo.Get(ctx, domain.PollCount, nil)
+			require.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, domain.NewCounter(domain.PollCount, 5), metric)
+
+			list, err = repo.List(ctx, &domain.MetricsFilter{Names: []string{domain.Alloc}})
+			require.NoError(t, err)
+			assert.Equal(t, []domain.Metric{domain.NewGauge(domain.Alloc, 1)}, list)
+		})
+	}
+}
+
+func TestCachedRepoWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	backing := &flakyRepo{inMemRepo: NewInMemRepo()}
+	require.NoError(t, backing.Store(ctx, domain.NewCounter(domain.PollCount, 5)))
+
+	repo, err := NewCachedRepo(ctx, backing, config.CacheConfig{Warm: true}, nil)
+	require.NoError(t, err)
+
+	updated, err := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 2), domain.NewGauge(domain.Alloc, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{domain.NewCounter(domain.PollCount, 7), domain.NewGauge(domain.Alloc, 1)}, updated)
+
+	// Cache holds stored values
+	backing.setDown(true)
+	metric, found, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, domain.Counter(7), metric.Counter)
+
+	list, err := repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	// Failed write is not applied, so cached values are kept and reads keep working
+	_, err = repo.Increment(ctx, domain.NewCounter(domain.PollCount, 1))
+	assert.Error(t, err)
+	metric, found, err = repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, domain.Counter(7), metric.Counter)
+
+	list, err = repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+}
+
+// unconfirmedRepo applies writes, but reports them as failed, like a database that lost connection on commit
+type unconfirmedRepo struct {
+	*flakyRepo
+}
+
+func (r unconfirmedRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	if _, err := r.flakyRepo.Increment(ctx, metrics...); err != nil {
+		return nil, err
+	}
+	return nil, errors.Wrap(ErrWriteUnconfirmed, "connection reset")
+}
+
+func TestCachedRepoUnconfirmedWrite(t *testing.T) {
+	ctx := context.Background()
+	backing := unconfirmedRepo{&flakyRepo{inMemRepo: NewInMemRepo()}}
+	require.NoError(t, backing.Store(ctx, domain.NewCounter(domain.PollCount, 5), domain.NewGauge(domain.Alloc, 1)))
+
+	invalidator := &testInvalidator{}
+	repo, err := NewCachedRepo(ctx, backing, config.CacheConfig{Warm: true}, invalidator)
+	require.NoError(t, err)
+
+	// Series is evicted, as it is unknown whether the write was applied, so it is read again
+	_, err = repo.Increment(ctx, domain.NewCounter(domain.PollCount, 1))
+	assert.ErrorIs(t, err, ErrWriteUnconfirmed)
+	assert.NotContains(t, repo.metrics, domain.PollCount)
+	assert.Contains(t, repo.metrics, domain.Alloc)
+	assert.False(t, repo.complete)
+	assert.Equal(t, [][]string{{domain.PollCount}}, invalidator.notified)
+
+	metric, found, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, domain.Counter(6), metric.Counter)
+	assert.Contains(t, repo.metrics, domain.PollCount)
+}
+
+func TestCachedRepoWriteThroughWithRaceCondition(t *testing.T) {
+	ctx := context.Background()
+	backing := &flakyRepo{inMemRepo: NewInMemRepo()}
+	repo, err := NewCachedRepo(ctx, backing, config.CacheConfig{Warm: true}, nil)
+	require.NoError(t, err)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, incErr := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 1))
+			assert.NoError(t, incErr)
+		}()
+	}
+	wg.Wait()
+
+	metric, found, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, domain.Counter(100), metric.Counter)
+}
+
+func TestCachedRepoInvalidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backing := &flakyRepo{inMemRepo: NewInMemRepo()}
+	require.NoError(t, backing.Store(ctx, domain.NewCounter(domain.PollCount, 5), domain.NewGauge(domain.Alloc, 1)))
+
+	invalidator := &testInvalidator{}
+	repo, err := NewCachedRepo(ctx, backing, config.CacheConfig{Warm: true}, invalidator)
+	require.NoError(t, err)
+	require.Eventually(t, invalidator.listening, time.Second, time.Millisecond)
+
+	// Own writes are announced to other replicas
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 2)))
+	assert.Equal(t, [][]string{{domain.Alloc}}, invalidator.notified)
+
+	// Another replica writes to the database and notifies about it
+	require.NoError(t, backing.Store(ctx, domain.NewCounter(domain.PollCount, 10), domain.NewGauge(domain.RandomValue, 3)))
+	metric, _, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	assert.Equal(t, domain.Counter(5), metric.Counter)
+
+	invalidator.invalidate([]string{domain.PollCount, domain.RandomValue})
+	metric, _, err = repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	assert.Equal(t, domain.Counter(10), metric.Counter)
+
+	// New series of another replica are listed as well
+	list, err := repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, list, 3)
+}
+
+func TestCachedRepoDelete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backing := &flakyRepo{inMemRepo: NewInMemRepo()}
+	require.NoError(t, backing.Store(ctx, domain.NewCounter(domain.PollCount, 5), domain.NewGauge(domain.Alloc, 1)))
+
+	invalidator := &testInvalidator{}
+	repo, err := NewCachedRepo(ctx, backing, config.CacheConfig{Warm: true}, invalidator)
+	require.NoError(t, err)
+
+	deleted, err := repo.Delete(ctx, domain.PollCount)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	_, found, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	list, err := repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{domain.NewGauge(domain.Alloc, 1)}, list)
+
+	// Other replicas invalidate everything, as they might have cached other series of deleted metrics
+	assert.Equal(t, [][]string{nil}, invalidator.notified)
+}
+
+func TestCachedRepoExpire(t *testing.T) {
+	ctx := context.Background()
+	backing := &flakyRepo{inMemRepo: NewInMemRepo()}
+	require.NoError(t, backing.Store(ctx, domain.NewCounter(domain.PollCount, 5)))
+
+	repo, err := NewCachedRepo(ctx, backing, config.CacheConfig{Warm: true}, nil)
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+	before := time.Now()
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 1)))
+
+	expired, err := repo.Expire(ctx, before)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	// The whole cache is dropped, series that are still fresh are read again
+	list, err := repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{domain.NewGauge(domain.Alloc, 1)}, list)
+}
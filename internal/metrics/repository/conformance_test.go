@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// conformanceRepo is what every metrics repository implements, see service.MetricsRepository
+type conformanceRepo interface {
+	Store(ctx context.Context, metrics ...domain.Metric) error
+	Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error)
+	Get(ctx context.Context, name string, labels domain.Labels) (m domain.Metric, found bool, err error)
+	List(ctx context.Context, filter *domain.MetricsFilter) ([]domain.Metric, error)
+	Delete(ctx context.Context, names ...string) (int, error)
+	Expire(ctx context.Context, before time.Time) (int, error)
+}
+
+// TestConformance runs the same tests against every repository backend (Postgres is tested separately,
+// as it needs a running database), open returns a new empty repository
+func TestConformance(t *testing.T) {
+	backends := []struct {
+		name string
+		open func(t *testing.T) conformanceRepo
+	}{
+		{
+			name: "in-mem",
+			open: func(t *testing.T) conformanceRepo {
+				return NewInMemRepo()
+			},
+		},
+		{
+			name: "wal",
+			open: func(t *testing.T) conformanceRepo {
+				return openTestWAL(t, t.TempDir())
+			},
+		},
+		{
+			name: "sqlite",
+			open: func(t *testing.T) conformanceRepo {
+				return openTestSQLite(t, filepath.Join(t.TempDir(), "metrics.db"))
+			},
+		},
+		{
+			name: "redis",
+			open: func(t *testing.T) conformanceRepo {
+				repo, _ := openTestRedis(t)
+				return repo
+			},
+		},
+	}
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			t.Run("store and get", func(t *testing.T) {
+				testStoreAndGet(t, backend.open(t))
+			})
+			t.Run("list", func(t *testing.T) {
+				testList(t, backend.open(t))
+			})
+			t.Run("increment", func(t *testing.T) {
+				testIncrement(t, backend.open(t))
+			})
+			t.Run("delete and expire", func(t *testing.T) {
+				testDeleteAndExpire(t, backend.open(t))
+			})
+		})
+	}
+}
+
+func testStoreAndGet(t *testing.T, repo conformanceRepo) {
+	ctx := context.Background()
+
+	labelled := domain.NewCounter(domain.PollCount, 3)
+	labelled.Labels = domain.Labels{"host": "a", "env": "prod"}
+	histogram := domain.NewHistogram(domain.PauseNs,
+		&domain.Histogram{Bounds: []float64{1, 10}, Counts: []uint64{1, 0, 2}, Sum: 30.5, Count: 3})
+
+	require.NoError(t, repo.Store(ctx,
+		domain.NewCounter(domain.PollCount, 5),
+		domain.NewGauge(domain.Alloc, 10.333),
+		labelled,
+		histogram,
+	))
+	// Existing series are updated in place
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 7)))
+
+	tests := []struct {
+		name   string
+		labels domain.Labels
+		want   domain.Metric
+		found  bool
+	}{
+		{name: domain.PollCount, want: domain.NewCounter(domain.PollCount, 7), found: true},
+		{name: domain.Alloc, want: domain.NewGauge(domain.Alloc, 10.333), found: true},
+		{name: domain.PollCount, labels: domain.Labels{"env": "prod", "host": "a"}, want: labelled, found: true},
+		{name: domain.PauseNs, want: histogram, found: true},
+		{name: domain.PollCount, labels: domain.Labels{"host": "b"}, found: false},
+		{name: domain.RandomValue, found: false},
+	}
+	for _, tt := range tests {
+		t.Run(domain.SeriesKey(tt.name, tt.labels), func(t *testing.T) {
+			metric, found, err := repo.Get(ctx, tt.name, tt.labels)
+			require.NoError(t, err)
+			assert.Equal(t, tt.found, found)
+			if tt.found {
+				assert.Equal(t, tt.want, metric)
+			}
+		})
+	}
+}
+
+func testList(t *testing.T, repo conformanceRepo) {
+	ctx := context.Background()
+
+	labelled := domain.NewCounter(domain.PollCount, 3)
+	labelled.Labels = domain.Labels{"host": "a"}
+	require.NoError(t, repo.Store(ctx,
+		domain.NewCounter(domain.PollCount, 5),
+		labelled,
+		domain.NewGauge(domain.Alloc, 10.5),
+		domain.NewGauge(domain.RandomValue, 1),
+	))
+
+	list, err := repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, list, 4)
+
+	// Filter by names matches series with any labels
+	list, err = repo.List(ctx, &domain.MetricsFilter{Names: []string{domain.PollCount, domain.Alloc}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 5),
+		labelled,
+		domain.NewGauge(domain.Alloc, 10.5),
+	}, list)
+
+	list, err = repo.List(ctx, &domain.MetricsFilter{Types: []string{domain.TypeGauge}, Prefix: "Rand"})
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{domain.NewGauge(domain.RandomValue, 1)}, list)
+
+	list, err = repo.List(ctx, &domain.MetricsFilter{Regex: ".*o.*", Glob: "*Count"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Metric{domain.NewCounter(domain.PollCount, 5), labelled}, list)
+
+	_, err = repo.List(ctx, &domain.MetricsFilter{Regex: "("})
+	assert.Error(t, err)
+}
+
+func testIncrement(t *testing.T, repo conformanceRepo) {
+	ctx := context.Background()
+
+	pauses := func(counts ...uint64) *domain.Histogram {
+		h := &domain.Histogram{Bounds: []float64{1}, Counts: counts}
+		for _, count := range counts {
+			h.Count += count
+			h.Sum += float64(count)
+		}
+		return h
+	}
+
+	updated, err := repo.Increment(ctx,
+		domain.NewCounter(domain.PollCount, 5),
+		domain.NewGauge(domain.Alloc, 1.5),
+		domain.NewHistogram(domain.PauseNs, pauses(1, 2)),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 5),
+		domain.NewGauge(domain.Alloc, 1.5),
+		domain.NewHistogram(domain.PauseNs, pauses(1, 2)),
+	}, updated)
+
+	updated, err = repo.Increment(ctx,
+		domain.NewCounter(domain.PollCount, 7),
+		domain.NewGauge(domain.Alloc, 0.5),
+		domain.NewHistogram(domain.PauseNs, pauses(3, 0)),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 12),
+		domain.NewGauge(domain.Alloc, 0.5),
+		domain.NewHistogram(domain.PauseNs, pauses(4, 2)),
+	}, updated)
+
+	// Reconfigured histogram buckets replace stored observations
+	reconfigured := &domain.Histogram{Bounds: []float64{1, 2}, Counts: []uint64{0, 1, 0}, Sum: 1.5, Count: 1}
+	updated, err = repo.Increment(ctx, domain.NewHistogram(domain.PauseNs, reconfigured))
+	require.NoError(t, err)
+	assert.Equal(t, reconfigured, updated[0].Histogram)
+
+	// Type change replaces metric
+	updated, err = repo.Increment(ctx, domain.NewGauge(domain.PollCount, 2))
+	require.NoError(t, err)
+	assert.Equal(t, domain.NewGauge(domain.PollCount, 2), updated[0])
+
+	metric, found, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.NewGauge(domain.PollCount, 2), metric)
+}
+
+func testDeleteAndExpire(t *testing.T, repo conformanceRepo) {
+	ctx := context.Background()
+
+	labelled := domain.NewCounter(domain.PollCount, 3)
+	labelled.Labels = domain.Labels{"host": "a"}
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5), labelled, domain.NewGauge(domain.Alloc, 1)))
+	time.Sleep(5 * time.Millisecond)
+	middle := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	_, err := repo.Increment(ctx, domain.NewGauge(domain.HeapSys, 2))
+	require.NoError(t, err)
+
+	deleted, err := repo.Delete(ctx, domain.PollCount, domain.RandomValue)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	expired, err := repo.Expire(ctx, middle)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	list, err := repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{domain.NewGauge(domain.HeapSys, 2)}, list)
+
+	// Deleted series can be created again
+	updated, err := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{domain.NewCounter(domain.PollCount, 1)}, updated)
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"time"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+const DefaultHistoryCapacity = 1000
+
+// sampleRing is a fixed-size ring buffer of samples, oldest samples are overwritten when it is full
+type sampleRing struct {
+	samples []domain.Sample
+	start   int
+	count   int
+}
+
+func newSampleRing(capacity int) *sampleRing {
+	return &sampleRing{
+		samples: make([]domain.Sample, capacity),
+	}
+}
+
+func (r *sampleRing) add(sample domain.Sample) {
+	capacity := len(r.samples)
+	if capacity == 0 {
+		return
+	}
+	if r.count < capacity {
+		r.samples[(r.start+r.count)%capacity] = sample
+		r.count++
+		return
+	}
+	// Ring is full, overwrite the oldest sample
+	r.samples[r.start] = sample
+	r.start = (r.start + 1) % capacity
+}
+
+// between returns samples within [from, to] range, oldest first
+func (r *sampleRing) between(from, to time.Time) []domain.Sample {
+	result := make([]domain.Sample, 0)
+	for i := 0; i < r.count; i++ {
+		sample := r.samples[(r.start+i)%len(r.samples)]
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, sample)
+	}
+	return result
+}
+
+// dropBefore removes samples older than 'before', samples are added in time order so only the oldest are checked
+func (r *sampleRing) dropBefore(before time.Time) {
+	for r.count > 0 && r.samples[r.start].Timestamp.Before(before) {
+		r.samples[r.start] = domain.Sample{}
+		r.start = (r.start + 1) % len(r.samples)
+		r.count--
+	}
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+func (r *inMemRepo) Rollups(
+	ctx context.Context,
+	name string,
+	labels domain.Labels,
+	resolution time.Duration,
+	from, to time.Time,
+) ([]domain.Rollup, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	result := make([]domain.Rollup, 0)
+	for _, rollup := range r.rollups[resolution][domain.SeriesKey(name, labels)] {
+		if rollup.Timestamp.Before(from) || rollup.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, rollup)
+	}
+	return result, nil
+}
+
+func (r *inMemRepo) LastRollups(ctx context.Context, resolution time.Duration) (map[string]domain.Rollup, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	result := make(map[string]domain.Rollup, len(r.rollups[resolution]))
+	for key, rollups := range r.rollups[resolution] {
+		if len(rollups) > 0 {
+			result[key] = rollups[len(rollups)-1]
+		}
+	}
+	return result, nil
+}
+
+func (r *inMemRepo) SeriesHistory(
+	ctx context.Context,
+	ranges []domain.SeriesRange,
+	to time.Time,
+) (map[string][]domain.Sample, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	result := make(map[string][]domain.Sample, len(ranges))
+	for _, series := range ranges {
+		key := domain.SeriesKey(series.Name, series.Labels)
+		if ring, ok := r.history[key]; ok {
+			result[key] = ring.between(series.From, to)
+		}
+	}
+	return result, nil
+}
+
+func (r *inMemRepo) SeriesRollups(
+	ctx context.Context,
+	ranges []domain.SeriesRange,
+	resolution time.Duration,
+	to time.Time,
+) (map[string][]domain.Rollup, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	result := make(map[string][]domain.Rollup, len(ranges))
+	for _, series := range ranges {
+		key := domain.SeriesKey(series.Name, series.Labels)
+		for _, rollup := range r.rollups[resolution][key] {
+			if rollup.Timestamp.Before(series.From) || rollup.Timestamp.After(to) {
+				continue
+			}
+			result[key] = append(result[key], rollup)
+		}
+	}
+	return result, nil
+}
+
+func (r *inMemRepo) StoreRollups(ctx context.Context, resolution time.Duration, rollups ...domain.SeriesRollup) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.rollups == nil {
+		r.rollups = make(map[time.Duration]map[string][]domain.Rollup)
+	}
+	if _, ok := r.rollups[resolution]; !ok {
+		r.rollups[resolution] = make(map[string][]domain.Rollup)
+	}
+	for _, rollup := range rollups {
+		key := domain.SeriesKey(rollup.Name, rollup.Labels)
+		existing := r.rollups[resolution][key]
+
+		// Rollups of the same bucket are replaced, so that storing is idempotent
+		i := sort.Search(len(existing), func(i int) bool {
+			return !existing[i].Timestamp.Before(rollup.Timestamp)
+		})
+		if i < len(existing) && existing[i].Timestamp.Equal(rollup.Timestamp) {
+			existing[i] = rollup.Rollup
+			continue
+		}
+		existing = append(existing, domain.Rollup{})
+		copy(existing[i+1:], existing[i:])
+		existing[i] = rollup.Rollup
+		r.rollups[resolution][key] = existing
+	}
+	return nil
+}
+
+func (r *inMemRepo) DeleteSamples(ctx context.Context, names []string, before time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key, ring := range r.history {
+		if isSeriesOf(key, names) {
+			ring.dropBefore(before)
+		}
+	}
+	return nil
+}
+
+func (r *inMemRepo) DeleteRollups(
+	ctx context.Context,
+	names []string,
+	resolution time.Duration,
+	before time.Time,
+) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key, rollups := range r.rollups[resolution] {
+		if !isSeriesOf(key, names) {
+			continue
+		}
+		i := sort.Search(len(rollups), func(i int) bool {
+			return !rollups[i].Timestamp.Before(before)
+		})
+		if i > 0 {
+			r.rollups[resolution][key] = append([]domain.Rollup(nil), rollups[i:]...)
+		}
+	}
+	return nil
+}
+
+// isSeriesOf checks if series key belongs to a metric with one of the names, see domain.SeriesKey
+func isSeriesOf(key string, names []string) bool {
+	for _, name := range names {
+		if key == name || strings.HasPrefix(key, name+"{") {
+			return true
+		}
+	}
+	return false
+}
@@ -3,52 +3,194 @@ package repository
 import (
 	"context"
 	"sync"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
 )
 
 type inMemRepo struct {
+	// metrics, history and rollups are keyed by series key (name + labels)
 	metrics map[string]domain.Metric
 	mutex   *sync.RWMutex
+	// updated is when series was last stored, so that series which are not updated anymore can be expired
+	updated map[string]time.Time
+	// history keeps last historyCapacity samples per metric, history is not kept if nil
+	history         map[string]*sampleRing
+	historyCapacity int
+	// rollups are aggregates of history per resolution and metric, sorted by time
+	rollups map[time.Duration]map[string][]domain.Rollup
 }
 
 func NewInMemRepo() *inMemRepo {
-	return &inMemRepo{
-		metrics: make(map[string]domain.Metric),
-		mutex:   &sync.RWMutex{},
+	return NewInMemRepoWithHistory(DefaultHistoryCapacity)
+}
+
+// NewInMemRepoWithHistory keeps last historyCapacity samples per series, history is not kept if it is 0
+func NewInMemRepoWithHistory(historyCapacity int) *inMemRepo {
+	r := &inMemRepo{
+		metrics:         make(map[string]domain.Metric),
+		mutex:           &sync.RWMutex{},
+		updated:         make(map[string]time.Time),
+		historyCapacity: historyCapacity,
+		rollups:         make(map[time.Duration]map[string][]domain.Rollup),
+	}
+	if historyCapacity > 0 {
+		r.history = make(map[string]*sampleRing)
 	}
+	return r
 }
 
 func (r *inMemRepo) Store(ctx context.Context, metrics ...domain.Metric) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	now := time.Now()
 	for _, metric := range metrics {
-		r.metrics[metric.Name] = metric
+		r.set(metric, now)
 	}
 	return nil
 }
 
-func (r *inMemRepo) Get(ctx context.Context, name string) (domain.Metric, bool, error) {
+func (r *inMemRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result := r.accumulate(metrics)
+	now := time.Now()
+	for _, metric := range result {
+		r.set(metric, now)
+	}
+	return result, nil
+}
+
+// Delete removes all series of given metric names along with their history and rollups
+func (r *inMemRepo) Delete(ctx context.Context, names ...string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := make([]string, 0)
+	for key := range r.metrics {
+		if isSeriesOf(key, names) {
+			keys = append(keys, key)
+		}
+	}
+	r.deleteKeys(keys)
+	return len(keys), nil
+}
+
+// Expire removes series that were not stored since 'before', along with their history and rollups
+func (r *inMemRepo) Expire(ctx context.Context, before time.Time) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := r.staleKeys(before)
+	r.deleteKeys(keys)
+	return len(keys), nil
+}
+
+// set stores metric and records its sample, mutex must be held by caller
+func (r *inMemRepo) set(metric domain.Metric, now time.Time) {
+	key := metric.Key()
+	r.metrics[key] = metric
+	r.updated[key] = now
+	r.addSample(metric, now)
+}
+
+// restore puts back metric, which was last stored at 'updated' (e.g. read from a log), no sample is recorded,
+// mutex must be held by caller
+func (r *inMemRepo) restore(metric domain.Metric, updated time.Time) {
+	key := metric.Key()
+	r.metrics[key] = metric
+	r.updated[key] = updated
+}
+
+// staleKeys returns keys of series that were not stored since 'before', mutex must be held by caller
+func (r *inMemRepo) staleKeys(before time.Time) []string {
+	keys := make([]string, 0)
+	for key := range r.metrics {
+		if r.updated[key].Before(before) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// deleteKeys removes series with given keys, mutex must be held by caller
+func (r *inMemRepo) deleteKeys(keys []string) {
+	for _, key := range keys {
+		delete(r.metrics, key)
+		delete(r.updated, key)
+		delete(r.history, key)
+		for _, rollups := range r.rollups {
+			delete(rollups, key)
+		}
+	}
+}
+
+// accumulate applies metrics on top of stored ones, mutex must be held by caller
+func (r *inMemRepo) accumulate(metrics []domain.Metric) []domain.Metric {
+	result := make([]domain.Metric, 0, len(metrics))
+	for _, metric := range metrics {
+		if existing, ok := r.metrics[metric.Key()]; ok {
+			metric = domain.Accumulate(existing, metric)
+		}
+		result = append(result, metric)
+	}
+	return result
+}
+
+func (r *inMemRepo) History(
+	ctx context.Context,
+	name string,
+	labels domain.Labels,
+	from, to time.Time,
+) ([]domain.Sample, error) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
-	metric, ok := r.metrics[name]
-	return metric, ok, nil
+	ring, ok := r.history[domain.SeriesKey(name, labels)]
+	if !ok {
+		return make([]domain.Sample, 0), nil
+	}
+	return ring.between(from, to), nil
 }
 
-func (r *inMemRepo) List(ctx context.Context, filter *domain.MetricsFilter) ([]domain.Metric, error) {
+func (r *inMemRepo) addSample(metric domain.Metric, ts time.Time) {
+	if r.history == nil {
+		return
+	}
+	ring, ok := r.history[metric.Key()]
+	if !ok {
+		ring = newSampleRing(r.historyCapacity)
+		r.history[metric.Key()] = ring
+	}
+	ring.add(domain.Sample{Timestamp: ts, Value: metric.FloatValue()})
+}
+
+func (r *inMemRepo) Get(ctx context.Context, name string, labels domain.Labels) (domain.Metric, bool, error) {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
+	metric, ok := r.metrics[domain.SeriesKey(name, labels)]
+	return metric, ok, nil
+}
+
+func (r *inMemRepo) List(ctx context.Context, filter *domain.MetricsFilter) ([]domain.Metric, error) {
 	result := make([]domain.Metric, 0)
+	matches, err := filter.Matcher()
+	if err != nil {
+		return result, errors.Wrap(err, "[in-mem repo] invalid filter")
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 
 	for _, metric := range r.metrics {
-		if filter != nil && len(filter.Names) > 0 && !sliceContains(filter.Names, metric.Name) {
-			// Skip if metric name not in filter
-			continue
+		if matches(metric) {
+			result = append(result, metric)
 		}
-		result = append(result, metric)
 	}
 	return result, nil
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -12,80 +13,52 @@ import (
 )
 
 func TestStore(t *testing.T) {
-	mutex := &sync.RWMutex{}
 	tests := []struct {
-		name string
-		have inMemRepo
-		add  domain.Metric
-		want inMemRepo
+		name   string
+		stored []domain.Metric
+		add    domain.Metric
+		want   map[string]domain.Metric
 	}{
 		{
 			name: "add counter to empty repo",
-			have: inMemRepo{
-				metrics: map[string]domain.Metric{},
-				mutex:   mutex,
-			},
-			add: domain.NewCounter(domain.PollCount, 10),
-			want: inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.PollCount: domain.NewCounter(domain.PollCount, 10),
-				},
-				mutex: mutex,
+			add:  domain.NewCounter(domain.PollCount, 10),
+			want: map[string]domain.Metric{
+				domain.PollCount: domain.NewCounter(domain.PollCount, 10),
 			},
 		},
 		{
 			name: "add gauge to empty repo",
-			have: inMemRepo{
-				metrics: map[string]domain.Metric{},
-				mutex:   mutex,
-			},
-			add: domain.NewGauge(domain.Alloc, 10.333),
-			want: inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.Alloc: domain.NewGauge(domain.Alloc, 10.333),
-				},
-				mutex: mutex,
+			add:  domain.NewGauge(domain.Alloc, 10.333),
+			want: map[string]domain.Metric{
+				domain.Alloc: domain.NewGauge(domain.Alloc, 10.333),
 			},
 		},
 		{
-			name: "update counter",
-			have: inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.PollCount: domain.NewCounter(domain.PollCount, 10),
-				},
-				mutex: mutex,
-			},
-			add: domain.NewCounter(domain.PollCount, 15),
-			want: inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.PollCount: domain.NewCounter(domain.PollCount, 15),
-				},
-				mutex: mutex,
+			name:   "update counter",
+			stored: []domain.Metric{domain.NewCounter(domain.PollCount, 10)},
+			add:    domain.NewCounter(domain.PollCount, 15),
+			want: map[string]domain.Metric{
+				domain.PollCount: domain.NewCounter(domain.PollCount, 15),
 			},
 		},
 		{
-			name: "update gauge",
-			have: inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.Alloc: domain.NewGauge(domain.Alloc, 10.333),
-				},
-				mutex: mutex,
-			},
-			add: domain.NewGauge(domain.Alloc, 5.5),
-			want: inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.Alloc: domain.NewGauge(domain.Alloc, 5.5),
-				},
-				mutex: mutex,
+			name:   "update gauge",
+			stored: []domain.Metric{domain.NewGauge(domain.Alloc, 10.333)},
+			add:    domain.NewGauge(domain.Alloc, 5.5),
+			want: map[string]domain.Metric{
+				domain.Alloc: domain.NewGauge(domain.Alloc, 5.5),
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx := context.Background()
-			err := tt.have.Store(ctx, tt.add)
+			repo := NewInMemRepo()
+			require.NoError(t, repo.Store(ctx, tt.stored...))
+
+			err := repo.Store(ctx, tt.add)
 			require.NoError(t, err)
-			assert.EqualValues(t, tt.want, tt.have)
+			assert.Equal(t, tt.want, repo.metrics)
 		})
 	}
 }
@@ -107,27 +80,93 @@ func TestStoreWithRaceCondition(t *testing.T) {
 	}
 	wg.Wait()
 
-	result, found, err := repo.Get(ctx, metric.Name)
+	result, found, err := repo.Get(ctx, metric.Name, nil)
 	require.NoError(t, err)
 	assert.True(t, found)
 	assert.Equal(t, domain.Counter(1), result.Counter)
 }
 
+func TestIncrement(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemRepo()
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5), domain.NewGauge(domain.Alloc, 1)))
+
+	tests := []struct {
+		name string
+		add  domain.Metric
+		want domain.Metric
+	}{
+		{
+			name: "counter is accumulated",
+			add:  domain.NewCounter(domain.PollCount, 10),
+			want: domain.NewCounter(domain.PollCount, 15),
+		},
+		{
+			name: "gauge is replaced",
+			add:  domain.NewGauge(domain.Alloc, 2),
+			want: domain.NewGauge(domain.Alloc, 2),
+		},
+		{
+			name: "new counter is stored as is",
+			add:  domain.NewCounter(domain.RandomValue, 3),
+			want: domain.NewCounter(domain.RandomValue, 3),
+		},
+		{
+			name: "type change replaces metric",
+			add:  domain.NewGauge(domain.PollCount, 1.5),
+			want: domain.NewGauge(domain.PollCount, 1.5),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated, err := repo.Increment(ctx, tt.add)
+			require.NoError(t, err)
+			assert.Equal(t, []domain.Metric{tt.want}, updated)
+
+			stored, found, err := repo.Get(ctx, tt.add.Name, nil)
+			require.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, tt.want, stored)
+		})
+	}
+}
+
+func TestIncrementWithRaceCondition(t *testing.T) {
+	repo := NewInMemRepo()
+	metric := domain.NewCounter(domain.PollCount, 1)
+
+	count := 1000
+	wg := sync.WaitGroup{}
+	wg.Add(count)
+
+	ctx := context.Background()
+	for i := 0; i < count; i++ {
+		go func() {
+			_, _ = repo.Increment(ctx, metric)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	result, found, err := repo.Get(ctx, metric.Name, nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, domain.Counter(count), result.Counter)
+}
+
 func TestGet(t *testing.T) {
-	mutex := &sync.RWMutex{}
 	type Want struct {
 		metric domain.Metric
 		found  bool
 	}
 	tests := []struct {
-		name string
-		repo *inMemRepo
-		get  string
-		want Want
+		name   string
+		stored []domain.Metric
+		get    string
+		want   Want
 	}{
 		{
 			name: "get metric from empty repo",
-			repo: NewInMemRepo(),
 			get:  domain.PollCount,
 			want: Want{
 				metric: domain.Metric{},
@@ -136,12 +175,9 @@ func TestGet(t *testing.T) {
 		},
 		{
 			name: "get metric from non-empty repo (found)",
-			repo: &inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.Alloc:     domain.NewGauge(domain.Alloc, 10.333),
-					domain.PollCount: domain.NewCounter(domain.PollCount, 10),
-				},
-				mutex: mutex,
+			stored: []domain.Metric{
+				domain.NewGauge(domain.Alloc, 10.333),
+				domain.NewCounter(domain.PollCount, 10),
 			},
 			get: domain.PollCount,
 			want: Want{
@@ -151,12 +187,9 @@ func TestGet(t *testing.T) {
 		},
 		{
 			name: "get metric from non-empty repo (not found)",
-			repo: &inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.Alloc:     domain.NewGauge(domain.Alloc, 10.333),
-					domain.PollCount: domain.NewCounter(domain.PollCount, 10),
-				},
-				mutex: mutex,
+			stored: []domain.Metric{
+				domain.NewGauge(domain.Alloc, 10.333),
+				domain.NewCounter(domain.PollCount, 10),
 			},
 			get: domain.HeapSys,
 			want: Want{
@@ -168,7 +201,10 @@ func TestGet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx := context.Background()
-			metric, found, err := tt.repo.Get(ctx, tt.get)
+			repo := NewInMemRepo()
+			require.NoError(t, repo.Store(ctx, tt.stored...))
+
+			metric, found, err := repo.Get(ctx, tt.get, nil)
 			require.NoError(t, err)
 			assert.Equal(t, tt.want.found, found)
 			assert.Equal(t, tt.want.metric, metric)
@@ -177,26 +213,21 @@ func TestGet(t *testing.T) {
 }
 
 func TestList(t *testing.T) {
-	mutex := &sync.RWMutex{}
 	tests := []struct {
 		name   string
-		repo   *inMemRepo
+		stored []domain.Metric
 		filter *domain.MetricsFilter
 		want   []domain.Metric
 	}{
 		{
 			name: "get list from empty repo",
-			repo: NewInMemRepo(),
 			want: []domain.Metric{},
 		},
 		{
 			name: "get list from non-empty repo",
-			repo: &inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.Alloc:     domain.NewGauge(domain.Alloc, 10.333),
-					domain.PollCount: domain.NewCounter(domain.PollCount, 10),
-				},
-				mutex: mutex,
+			stored: []domain.Metric{
+				domain.NewGauge(domain.Alloc, 10.333),
+				domain.NewCounter(domain.PollCount, 10),
 			},
 			want: []domain.Metric{
 				domain.NewGauge(domain.Alloc, 10.333),
@@ -205,12 +236,9 @@ func TestList(t *testing.T) {
 		},
 		{
 			name: "get list from non-empty repo, different order",
-			repo: &inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.PollCount: domain.NewCounter(domain.PollCount, 10),
-					domain.Alloc:     domain.NewGauge(domain.Alloc, 10.333),
-				},
-				mutex: mutex,
+			stored: []domain.Metric{
+				domain.NewCounter(domain.PollCount, 10),
+				domain.NewGauge(domain.Alloc, 10.333),
 			},
 			want: []domain.Metric{
 				domain.NewCounter(domain.PollCount, 10),
@@ -219,12 +247,9 @@ func TestList(t *testing.T) {
 		},
 		{
 			name: "get list from non-empty repo, filter by name",
-			repo: &inMemRepo{
-				metrics: map[string]domain.Metric{
-					domain.PollCount: domain.NewCounter(domain.PollCount, 10),
-					domain.Alloc:     domain.NewGauge(domain.Alloc, 10.333),
-				},
-				mutex: mutex,
+			stored: []domain.Metric{
+				domain.NewCounter(domain.PollCount, 10),
+				domain.NewGauge(domain.Alloc, 10.333),
 			},
 			filter: &domain.MetricsFilter{
 				Names: []string{domain.PollCount},
@@ -233,13 +258,172 @@ func TestList(t *testing.T) {
 				domain.NewCounter(domain.PollCount, 10),
 			},
 		},
+		{
+			name: "get list from non-empty repo, filter by type and prefix",
+			stored: []domain.Metric{
+				domain.NewCounter(domain.PollCount, 10),
+				domain.NewGauge(domain.HeapSys, 1),
+				domain.NewGauge(domain.Alloc, 10.333),
+			},
+			filter: &domain.MetricsFilter{
+				Types:  []string{domain.TypeGauge},
+				Prefix: "Heap",
+			},
+			want: []domain.Metric{
+				domain.NewGauge(domain.HeapSys, 1),
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx := context.Background()
-			list, err := tt.repo.List(ctx, tt.filter)
+			repo := NewInMemRepo()
+			require.NoError(t, repo.Store(ctx, tt.stored...))
+
+			list, err := repo.List(ctx, tt.filter)
 			require.NoError(t, err)
 			assert.ElementsMatch(t, tt.want, list)
 		})
 	}
 }
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemRepoWithHistory(3)
+
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, domain.Counter(i))))
+	}
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 10.333)))
+	end := time.Now()
+
+	samples, err := repo.History(ctx, domain.PollCount, nil, start, end)
+	require.NoError(t, err)
+	// Only the last 3 samples are kept, oldest first
+	require.Len(t, samples, 3)
+	for i, sample := range samples {
+		assert.Equal(t, float64(i+3), sample.Value)
+	}
+
+	samples, err = repo.History(ctx, domain.Alloc, nil, start, end)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 10.333, samples[0].Value)
+
+	samples, err = repo.History(ctx, domain.PollCount, nil, end.Add(time.Second), end.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+
+	samples, err = repo.History(ctx, domain.HeapSys, nil, start, end)
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+}
+
+func TestRollups(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemRepo()
+	start := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	rollupAt := func(d time.Duration, last float64) domain.Rollup {
+		return domain.Rollup{Timestamp: start.Add(d), Last: last}
+	}
+	allocRollupAt := func(d time.Duration, last float64) domain.SeriesRollup {
+		return domain.SeriesRollup{Name: domain.Alloc, Rollup: rollupAt(d, last)}
+	}
+	labels := domain.Labels{"host": "a"}
+	labelledKey := domain.SeriesKey(domain.Alloc, labels)
+
+	lastRollups, err := repo.LastRollups(ctx, domain.ResolutionMinute)
+	require.NoError(t, err)
+	assert.Empty(t, lastRollups)
+
+	require.NoError(t, repo.StoreRollups(ctx, domain.ResolutionMinute,
+		allocRollupAt(2*time.Minute, 2), allocRollupAt(0, 0),
+		domain.SeriesRollup{Name: domain.Alloc, Labels: labels, Rollup: rollupAt(0, 10)}))
+	// Rollup of the same bucket is replaced, others are inserted in time order
+	require.NoError(t, repo.StoreRollups(ctx, domain.ResolutionMinute,
+		allocRollupAt(time.Minute, 1), allocRollupAt(2*time.Minute, 3)))
+
+	rollups, err := repo.Rollups(ctx, domain.Alloc, nil, domain.ResolutionMinute, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Rollup{rollupAt(0, 0), rollupAt(time.Minute, 1), rollupAt(2*time.Minute, 3)}, rollups)
+
+	lastRollups, err = repo.LastRollups(ctx, domain.ResolutionMinute)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]domain.Rollup{
+		domain.Alloc: rollupAt(2*time.Minute, 3),
+		labelledKey:  rollupAt(0, 10),
+	}, lastRollups)
+
+	// Each series is read since its own start
+	series, err := repo.SeriesRollups(ctx, []domain.SeriesRange{
+		{Name: domain.Alloc, From: start.Add(time.Minute)},
+		{Name: domain.Alloc, Labels: labels, From: start},
+		{Name: domain.PollCount, From: start},
+	}, domain.ResolutionMinute, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]domain.Rollup{
+		domain.Alloc: {rollupAt(time.Minute, 1), rollupAt(2*time.Minute, 3)},
+		labelledKey:  {rollupAt(0, 10)},
+	}, series)
+
+	rollups, err = repo.Rollups(ctx, domain.Alloc, nil, domain.ResolutionHour, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, rollups)
+
+	require.NoError(t, repo.DeleteRollups(ctx, []string{domain.Alloc}, domain.ResolutionMinute, start.Add(2*time.Minute)))
+	rollups, err = repo.Rollups(ctx, domain.Alloc, nil, domain.ResolutionMinute, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Rollup{rollupAt(2*time.Minute, 3)}, rollups)
+}
+
+func TestDeleteSamples(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemRepoWithHistory(5)
+
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 1)))
+	middle := time.Now()
+	for i := 2; i <= 6; i++ {
+		require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, domain.Gauge(i))))
+	}
+	require.NoError(t, repo.DeleteSamples(ctx, []string{domain.Alloc, domain.HeapSys}, middle))
+
+	samples, err := repo.History(ctx, domain.Alloc, nil, time.Time{}, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 5)
+	assert.Equal(t, 2.0, samples[0].Value)
+
+	// Ring keeps working after samples are dropped
+	require.NoError(t, repo.DeleteSamples(ctx, []string{domain.Alloc}, time.Now()))
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 7)))
+	samples, err = repo.History(ctx, domain.Alloc, nil, time.Time{}, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 7.0, samples[0].Value)
+}
+
+// Deleting and expiring series is covered by TestConformance, this checks that their history is removed too
+func TestDeleteAndExpireRemoveHistory(t *testing.T) {
+	ctx := context.Background()
+	repo := NewInMemRepoWithHistory(5)
+
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5), domain.NewGauge(domain.Alloc, 1)))
+	middle := time.Now()
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.HeapSys, 2)))
+
+	deleted, err := repo.Delete(ctx, domain.PollCount)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	expired, err := repo.Expire(ctx, middle)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	for _, name := range []string{domain.PollCount, domain.Alloc} {
+		samples, err := repo.History(ctx, name, nil, time.Time{}, time.Now())
+		require.NoError(t, err)
+		assert.Empty(t, samples)
+	}
+	samples, err := repo.History(ctx, domain.HeapSys, nil, time.Time{}, time.Now())
+	require.NoError(t, err)
+	assert.Len(t, samples, 1)
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// These are the interfaces required for repository decorators to work
+
+// BackingRepository is a repository with history and rollups, that can be pinged to check if it is available
+// Decorators (e.g. write-behind journal) wrap it and forward what they do not handle themselves
+type BackingRepository interface {
+	Ping(ctx context.Context) bool
+	Store(ctx context.Context, metrics ...domain.Metric) error
+	Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error)
+	Get(ctx context.Context, name string, labels domain.Labels) (m domain.Metric, found bool, err error)
+	List(ctx context.Context, filter *domain.MetricsFilter) ([]domain.Metric, error)
+	Delete(ctx context.Context, names ...string) (int, error)
+	Expire(ctx context.Context, before time.Time) (int, error)
+	History(ctx context.Context, name string, labels domain.Labels, from, to time.Time) ([]domain.Sample, error)
+	Rollups(
+		ctx context.Context,
+		name string,
+		labels domain.Labels,
+		resolution time.Duration,
+		from, to time.Time,
+	) ([]domain.Rollup, error)
+	LastRollups(ctx context.Context, resolution time.Duration) (map[string]domain.Rollup, error)
+	SeriesHistory(ctx context.Context, ranges []domain.SeriesRange, to time.Time) (map[string][]domain.Sample, error)
+	SeriesRollups(
+		ctx context.Context,
+		ranges []domain.SeriesRange,
+		resolution time.Duration,
+		to time.Time,
+	) (map[string][]domain.Rollup, error)
+	StoreRollups(ctx context.Context, resolution time.Duration, rollups ...domain.SeriesRollup) error
+	DeleteSamples(ctx context.Context, names []string, before time.Time) error
+	DeleteRollups(ctx context.Context, names []string, resolution time.Duration, before time.Time) error
+}
+
+// CacheInvalidator notifies other replicas about changed series (by their keys) and listens to their notifications
+// Listen blocks until ctx is done, invalidate is called with nil keys if notifications might have been missed
+type CacheInvalidator interface {
+	Notify(ctx context.Context, keys []string) error
+	Listen(ctx context.Context, invalidate func(keys []string))
+}
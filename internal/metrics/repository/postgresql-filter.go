@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// labelConditions are SQL conditions of label matchers, missing label is matched as an empty value
+// Regexes are anchored by the caller, so that the whole value is matched
+var labelConditions = map[string]string{
+	domain.LabelEqual:    "COALESCE(labels->>%s, '') = %s",
+	domain.LabelNotEqual: "COALESCE(labels->>%s, '') <> %s",
+	domain.LabelRegex:    "COALESCE(labels->>%s, '') ~ %s",
+	domain.LabelNotRegex: "COALESCE(labels->>%s, '') !~ %s",
+}
+
+// buildListQuery pushes filter predicates down into WHERE clause of the list query
+// Filter must be validated beforehand (see domain.MetricsFilter.Matcher)
+func buildListQuery(filter *domain.MetricsFilter) (string, []any) {
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(filter.Names) > 0 {
+		conditions = append(conditions, "name = ANY("+arg(filter.Names)+")")
+	}
+	if len(filter.Types) > 0 {
+		conditions = append(conditions, "type::text = ANY("+arg(filter.Types)+")")
+	}
+	if filter.Prefix != "" {
+		conditions = append(conditions, "name LIKE "+arg(escapeLike(filter.Prefix)+"%"))
+	}
+	if filter.Glob != "" {
+		conditions = append(conditions, "name ~ "+arg(globToRegex(filter.Glob)))
+	}
+	if filter.Regex != "" {
+		conditions = append(conditions, "name ~ "+arg(anchorRegex(filter.Regex)))
+	}
+	for _, matcher := range filter.Labels {
+		value := matcher.Value
+		if matcher.Operator == domain.LabelRegex || matcher.Operator == domain.LabelNotRegex {
+			value = anchorRegex(value)
+		}
+		conditions = append(conditions, fmt.Sprintf(labelConditions[matcher.Operator], arg(matcher.Key), arg(value)))
+	}
+
+	query := "SELECT name, type, counter, gauge, labels, histogram FROM metrics"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return query + " ORDER BY id desc", args
+}
+
+func anchorRegex(expr string) string {
+	return "^(?:" + expr + ")$"
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// globToRegex translates pattern of path.Match into an anchored regex, which can be matched by the database
+func globToRegex(glob string) string {
+	var builder strings.Builder
+	builder.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			builder.WriteString("[^/]*")
+		case '?':
+			builder.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				builder.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		case '[':
+			i = writeGlobClass(&builder, glob, i)
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	builder.WriteString("$")
+	return builder.String()
+}
+
+// writeGlobClass translates character class starting at glob[start], it returns index of the closing bracket
+// Glob must be valid, so that the class is terminated
+func writeGlobClass(builder *strings.Builder, glob string, start int) int {
+	builder.WriteByte('[')
+	i := start + 1
+	if i < len(glob) && glob[i] == '^' {
+		builder.WriteByte('^')
+		i++
+	}
+	for ; i < len(glob) && glob[i] != ']'; i++ {
+		c, escaped := glob[i], false
+		if c == '\\' && i+1 < len(glob) {
+			i++
+			c, escaped = glob[i], true
+		}
+		// Unescaped '-' is a range, other special characters are taken literally
+		if (escaped && strings.IndexByte(`]\-^[`, c) >= 0) || c == '[' || c == '^' {
+			builder.WriteByte('\\')
+		}
+		builder.WriteByte(c)
+	}
+	builder.WriteByte(']')
+	return i
+}
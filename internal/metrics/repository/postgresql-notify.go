@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/retry"
+)
+
+// Postgres limits notification payload to 8000 bytes, keys are split into several notifications to fit
+const maxNotificationPayload = 7000
+
+// changeNotification is the payload of notifications about changed series
+// Source identifies the repo that sent it, so that it can skip its own notifications
+type changeNotification struct {
+	Source string   `json:"source"`
+	Keys   []string `json:"keys"`
+}
+
+// Notify tells other replicas (listening to the same channel) that series with given keys have changed,
+// nil keys tell them to invalidate everything
+func (r *postgresRepo) Notify(ctx context.Context, keys []string) error {
+	if keys == nil {
+		return r.notify(ctx, nil)
+	}
+	batch := make([]string, 0)
+	size := 0
+	for i, key := range keys {
+		batch = append(batch, key)
+		size += len(key) + 3
+		if size < maxNotificationPayload && i < len(keys)-1 {
+			continue
+		}
+		if err := r.notify(ctx, batch); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		size = 0
+	}
+	return nil
+}
+
+func (r *postgresRepo) notify(ctx context.Context, keys []string) error {
+	payload, err := json.Marshal(changeNotification{Source: r.source, Keys: keys})
+	if err != nil {
+		return errors.Wrap(err, "[postgres repo] error when encoding notification")
+	}
+	if _, err = r.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", r.cfg.Cache.Channel, string(payload)); err != nil {
+		return errors.Wrap(err, "[postgres repo] error when sending notification")
+	}
+	return nil
+}
+
+// Listen waits for notifications of other replicas on a dedicated connection, reconnecting with backoff
+// Notifications sent while disconnected are lost, so everything is invalidated after every (re)connect
+func (r *postgresRepo) Listen(ctx context.Context, invalidate func(keys []string)) {
+	backoff := retry.NewPolicy(config.RetryConfig{
+		BaseDelay: r.cfg.ReconnectBaseDelay,
+		MaxDelay:  r.cfg.ReconnectMaxDelay,
+		Jitter:    0.2,
+	})
+	for attempt := 1; ; attempt++ {
+		listened, err := r.listen(ctx, invalidate)
+		if ctx.Err() != nil {
+			logger.New(ctx).Debugf("[postgres repo] context cancelled, stopped listening")
+			return
+		}
+		if listened {
+			attempt = 1
+		}
+		delay := backoff.Delay(attempt)
+		logger.New(ctx).Errorf("[postgres repo] listening failed: %s, reconnecting in %s", err.Error(), delay)
+
+		select {
+		case <-ctx.Done():
+			logger.New(ctx).Debugf("[postgres repo] context cancelled, stopped listening")
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// listen connects and handles notifications until an error occurs, it reports whether it got to listening
+func (r *postgresRepo) listen(ctx context.Context, invalidate func(keys []string)) (bool, error) {
+	conn, err := pgx.Connect(ctx, r.cfg.DSN)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{r.cfg.Cache.Channel}.Sanitize()); err != nil {
+		return false, err
+	}
+	logger.New(ctx).Infof("[postgres repo] listening to '%s'", r.cfg.Cache.Channel)
+	invalidate(nil)
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		var change changeNotification
+		if err = json.Unmarshal([]byte(notification.Payload), &change); err != nil {
+			logger.New(ctx).Errorf("[postgres repo] invalid notification payload, invalidating everything")
+			invalidate(nil)
+			continue
+		}
+		if change.Source != r.source {
+			invalidate(change.Keys)
+		}
+	}
+}
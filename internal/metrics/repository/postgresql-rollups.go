@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// deleteBatchSize is the max number of rows removed by a single DELETE statement
+const deleteBatchSize = 10000
+
+func (r *postgresRepo) Rollups(
+	ctx context.Context,
+	name string,
+	labels domain.Labels,
+	resolution time.Duration,
+	from, to time.Time,
+) ([]domain.Rollup, error) {
+	rollups := make([]domain.Rollup, 0)
+
+	encodedLabels, err := encodeLabels(labels)
+	if err != nil {
+		return rollups, err
+	}
+	rows, err := r.stmts[StmtListRollups].QueryContext(ctx, name, encodedLabels, resolutionSeconds(resolution), from, to)
+	if err != nil {
+		return rollups, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rollup domain.Rollup
+		err = rows.Scan(&rollup.Timestamp, &rollup.Min, &rollup.Max, &rollup.Sum,
+			&rollup.Count, &rollup.Last, &rollup.Delta)
+		if err != nil {
+			return rollups, err
+		}
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
+
+func (r *postgresRepo) LastRollups(ctx context.Context, resolution time.Duration) (map[string]domain.Rollup, error) {
+	result := make(map[string]domain.Rollup)
+
+	rows, err := r.stmts[StmtLastRollups].QueryContext(ctx, resolutionSeconds(resolution))
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rollup domain.Rollup
+		var name string
+		var labels []byte
+		err = rows.Scan(&name, &labels, &rollup.Timestamp, &rollup.Min, &rollup.Max, &rollup.Sum,
+			&rollup.Count, &rollup.Last, &rollup.Delta)
+		if err != nil {
+			return result, err
+		}
+		key, err := seriesKey(name, labels)
+		if err != nil {
+			return result, err
+		}
+		result[key] = rollup
+	}
+	return result, rows.Err()
+}
+
+func (r *postgresRepo) SeriesHistory(
+	ctx context.Context,
+	ranges []domain.SeriesRange,
+	to time.Time,
+) (map[string][]domain.Sample, error) {
+	result := make(map[string][]domain.Sample, len(ranges))
+
+	args, err := seriesRangesArgs(ranges)
+	if err != nil {
+		return result, err
+	}
+	rows, err := r.stmts[StmtListSeriesSamples].QueryContext(ctx, append(args, to)...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sample domain.Sample
+		var name string
+		var labels []byte
+		if err = rows.Scan(&name, &labels, &sample.Timestamp, &sample.Value); err != nil {
+			return result, err
+		}
+		key, err := seriesKey(name, labels)
+		if err != nil {
+			return result, err
+		}
+		result[key] = append(result[key], sample)
+	}
+	return result, rows.Err()
+}
+
+func (r *postgresRepo) SeriesRollups(
+	ctx context.Context,
+	ranges []domain.SeriesRange,
+	resolution time.Duration,
+	to time.Time,
+) (map[string][]domain.Rollup, error) {
+	result := make(map[string][]domain.Rollup, len(ranges))
+
+	args, err := seriesRangesArgs(ranges)
+	if err != nil {
+		return result, err
+	}
+	rows, err := r.stmts[StmtListSeriesRollups].QueryContext(ctx, append(args, resolutionSeconds(resolution), to)...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rollup domain.Rollup
+		var name string
+		var labels []byte
+		err = rows.Scan(&name, &labels, &rollup.Timestamp, &rollup.Min, &rollup.Max, &rollup.Sum,
+			&rollup.Count, &rollup.Last, &rollup.Delta)
+		if err != nil {
+			return result, err
+		}
+		key, err := seriesKey(name, labels)
+		if err != nil {
+			return result, err
+		}
+		result[key] = append(result[key], rollup)
+	}
+	return result, rows.Err()
+}
+
+// StoreRollups writes rollups of all series by a single statement, rollups are passed as arrays (one per column)
+func (r *postgresRepo) StoreRollups(ctx context.Context, resolution time.Duration, rollups ...domain.SeriesRollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	var (
+		names      = make([]string, len(rollups))
+		labels     = make([]string, len(rollups))
+		timestamps = make([]time.Time, len(rollups))
+		mins       = make([]float64, len(rollups))
+		maxes      = make([]float64, len(rollups))
+		sums       = make([]float64, len(rollups))
+		counts     = make([]int64, len(rollups))
+		lasts      = make([]float64, len(rollups))
+		deltas     = make([]float64, len(rollups))
+	)
+	for i, rollup := range rollups {
+		encodedLabels, err := encodeLabels(rollup.Labels)
+		if err != nil {
+			return err
+		}
+		names[i], labels[i], timestamps[i] = rollup.Name, encodedLabels, rollup.Timestamp
+		mins[i], maxes[i], sums[i], counts[i] = rollup.Min, rollup.Max, rollup.Sum, rollup.Count
+		lasts[i], deltas[i] = rollup.Last, rollup.Delta
+	}
+	_, err := r.stmts[StmtStoreRollups].ExecContext(ctx, names, labels, resolutionSeconds(resolution),
+		timestamps, mins, maxes, sums, counts, lasts, deltas)
+	return err
+}
+
+func (r *postgresRepo) DeleteSamples(ctx context.Context, names []string, before time.Time) error {
+	return deleteInBatches(ctx, r.stmts[StmtDeleteSamples], names, before)
+}
+
+func (r *postgresRepo) DeleteRollups(
+	ctx context.Context,
+	names []string,
+	resolution time.Duration,
+	before time.Time,
+) error {
+	return deleteInBatches(ctx, r.stmts[StmtDeleteRollups], names, resolutionSeconds(resolution), before)
+}
+
+// deleteInBatches runs a batched DELETE statement until it removes less than a full batch
+// Batch size is passed as the last statement argument
+func deleteInBatches(ctx context.Context, stmt *sql.Stmt, args ...any) error {
+	args = append(args, deleteBatchSize)
+	for {
+		result, err := stmt.ExecContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected < deleteBatchSize {
+			return nil
+		}
+	}
+}
+
+// seriesRangesArgs encodes series ranges as statement arguments: arrays of names, labels and range starts
+func seriesRangesArgs(ranges []domain.SeriesRange) ([]any, error) {
+	names := make([]string, len(ranges))
+	labels := make([]string, len(ranges))
+	froms := make([]time.Time, len(ranges))
+	for i, series := range ranges {
+		encodedLabels, err := encodeLabels(series.Labels)
+		if err != nil {
+			return nil, err
+		}
+		names[i], labels[i], froms[i] = series.Name, encodedLabels, series.From
+	}
+	return []any{names, labels, froms}, nil
+}
+
+// seriesKey returns key of series, which labels are read from jsonb column
+func seriesKey(name string, labels []byte) (string, error) {
+	decoded, err := decodeLabels(labels)
+	if err != nil {
+		return "", err
+	}
+	return domain.SeriesKey(name, decoded), nil
+}
+
+func resolutionSeconds(resolution time.Duration) int {
+	return int(resolution / time.Second)
+}
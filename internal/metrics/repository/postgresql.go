@@ -2,8 +2,13 @@ package repository
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	// postgres init
@@ -19,14 +24,40 @@ import (
 
 const (
 	StmtStoreMetrics = iota
+	StmtIncrementMetrics
+	StmtEnsureMetric
+	StmtLockMetric
 	StmtGetMetrics
 	StmtListMetrics
+	StmtListMetricsByNames
+	StmtStoreSamples
+	StmtListSamples
+	StmtStoreRollups
+	StmtListRollups
+	StmtLastRollups
+	StmtListSeriesSamples
+	StmtListSeriesRollups
+	StmtDeleteSamples
+	StmtDeleteRollups
+	StmtDeleteMetrics
+	StmtDeleteAllSamples
+	StmtDeleteAllRollups
+	StmtExpireMetrics
+	StmtDeleteSeriesSamples
+	StmtDeleteSeriesRollups
 )
 
+// metricsBatchSelect turns column arrays into rows, see metricsBatch
+const metricsBatchSelect = "SELECT name, type::metric_type, counter, gauge, labels::jsonb, histogram::jsonb" +
+	" FROM unnest($1::text[], $2::text[], $3::bigint[], $4::double precision[], $5::text[], $6::text[])" +
+	" AS batch (name, type, counter, gauge, labels, histogram)"
+
 type postgresRepo struct {
 	db    *sql.DB
 	cfg   config.DatabaseConfig
 	stmts map[int]*sql.Stmt
+	// source is a random ID of this repo, it is sent with notifications about changed series
+	source string
 }
 
 func NewPostgresRepo(ctx context.Context, cfg config.DatabaseConfig) (*postgresRepo, error) {
@@ -67,36 +98,206 @@ func NewPostgresRepo(ctx context.Context, cfg config.DatabaseConfig) (*postgresR
 		return nil, err
 	}
 
+	source := make([]byte, 8)
+	if _, err = rand.Read(source); err != nil {
+		return nil, err
+	}
+
 	return &postgresRepo{
-		db:    db,
-		cfg:   cfg,
-		stmts: stmts,
+		db:     db,
+		cfg:    cfg,
+		stmts:  stmts,
+		source: hex.EncodeToString(source),
 	}, nil
 }
 
 func initStatements(ctx context.Context, db *sql.DB) (map[int]*sql.Stmt, error) {
 	stmts := make(map[int]*sql.Stmt, 0)
 
+	// Batches are passed as arrays (one per column), so that any number of metrics is written by a single statement
 	storeMetrics, err := db.PrepareContext(ctx,
-		"INSERT INTO metrics (name, type, counter, gauge) VALUES ($1, $2, $3, $4)"+
-			" ON CONFLICT (name) DO UPDATE SET counter = excluded.counter, gauge = excluded.gauge")
+		"INSERT INTO metrics (name, type, counter, gauge, labels, histogram) "+metricsBatchSelect+
+			" ON CONFLICT (name, labels) DO UPDATE SET counter = excluded.counter, gauge = excluded.gauge,"+
+			" histogram = excluded.histogram, updated_at = now()")
 	if err != nil {
 		return nil, err
 	}
 	stmts[StmtStoreMetrics] = storeMetrics
 
-	getMetrics, err := db.PrepareContext(ctx, "SELECT name, type, counter, gauge FROM metrics WHERE name = $1")
+	// Counters are summed up by the database itself, so concurrent increments (e.g. from replicas) are not lost
+	incrementMetrics, err := db.PrepareContext(ctx,
+		"INSERT INTO metrics AS m (name, type, counter, gauge, labels, histogram) "+metricsBatchSelect+
+			" ON CONFLICT (name, labels) DO UPDATE SET"+
+			" counter = CASE WHEN m.type = 'counter' AND excluded.type = 'counter'"+
+			" THEN m.counter + excluded.counter ELSE excluded.counter END,"+
+			" type = excluded.type, gauge = excluded.gauge, histogram = excluded.histogram, updated_at = now()"+
+			" RETURNING name, type, counter, gauge, labels, histogram")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtIncrementMetrics] = incrementMetrics
+
+	// Histograms are merged in Go, their rows are created (if missing) and locked first
+	ensureMetric, err := db.PrepareContext(ctx,
+		"INSERT INTO metrics (name, type, counter, gauge, labels) VALUES ($1, $2, 0, 0, $3)"+
+			" ON CONFLICT (name, labels) DO NOTHING")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtEnsureMetric] = ensureMetric
+
+	lockMetric, err := db.PrepareContext(ctx,
+		"SELECT name, type, counter, gauge, labels, histogram FROM metrics WHERE name = $1 AND labels = $2 FOR UPDATE")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtLockMetric] = lockMetric
+
+	getMetrics, err := db.PrepareContext(ctx,
+		"SELECT name, type, counter, gauge, labels, histogram FROM metrics WHERE name = $1 AND labels = $2")
 	if err != nil {
 		return nil, err
 	}
 	stmts[StmtGetMetrics] = getMetrics
 
-	listMetrics, err := db.PrepareContext(ctx, "SELECT name, type, counter, gauge FROM metrics ORDER BY id desc")
+	listMetrics, err := db.PrepareContext(ctx,
+		"SELECT name, type, counter, gauge, labels, histogram FROM metrics ORDER BY id desc")
 	if err != nil {
 		return nil, err
 	}
 	stmts[StmtListMetrics] = listMetrics
 
+	listMetricsByNames, err := db.PrepareContext(ctx,
+		"SELECT name, type, counter, gauge, labels, histogram FROM metrics WHERE name = ANY($1) ORDER BY id desc")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtListMetricsByNames] = listMetricsByNames
+
+	storeSamples, err := db.PrepareContext(ctx,
+		"INSERT INTO metric_samples (name, labels, ts, value) SELECT name, labels::jsonb, $3::timestamptz, value"+
+			" FROM unnest($1::text[], $2::text[], $4::double precision[]) AS batch (name, labels, value)")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtStoreSamples] = storeSamples
+
+	listSamples, err := db.PrepareContext(ctx,
+		"SELECT ts, value FROM metric_samples WHERE name = $1 AND labels = $2 AND ts >= $3 AND ts <= $4 ORDER BY ts")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtListSamples] = listSamples
+
+	storeRollups, err := db.PrepareContext(ctx,
+		"INSERT INTO metric_rollups (name, labels, resolution, ts, min, max, sum, count, last, delta)"+
+			" SELECT name, labels::jsonb, $3::integer, ts, min, max, sum, count, last, delta"+
+			" FROM unnest($1::text[], $2::text[], $4::timestamptz[], $5::double precision[], $6::double precision[],"+
+			" $7::double precision[], $8::bigint[], $9::double precision[], $10::double precision[])"+
+			" AS batch (name, labels, ts, min, max, sum, count, last, delta)"+
+			" ON CONFLICT (name, labels, resolution, ts) DO UPDATE SET min = excluded.min, max = excluded.max,"+
+			" sum = excluded.sum, count = excluded.count, last = excluded.last, delta = excluded.delta")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtStoreRollups] = storeRollups
+
+	listRollups, err := db.PrepareContext(ctx,
+		"SELECT ts, min, max, sum, count, last, delta FROM metric_rollups"+
+			" WHERE name = $1 AND labels = $2 AND resolution = $3 AND ts >= $4 AND ts <= $5 ORDER BY ts")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtListRollups] = listRollups
+
+	lastRollups, err := db.PrepareContext(ctx,
+		"SELECT DISTINCT ON (name, labels) name, labels, ts, min, max, sum, count, last, delta FROM metric_rollups"+
+			" WHERE resolution = $1 ORDER BY name, labels, ts DESC")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtLastRollups] = lastRollups
+
+	// History of many series is selected by joining series ranges (passed as arrays) with the history tables
+	listSeriesSamples, err := db.PrepareContext(ctx,
+		"SELECT s.name, s.labels, s.ts, s.value FROM metric_samples s"+
+			" JOIN unnest($1::text[], $2::text[], $3::timestamptz[]) AS batch (name, labels, since)"+
+			" ON s.name = batch.name AND s.labels = batch.labels::jsonb"+
+			" WHERE s.ts >= batch.since AND s.ts <= $4 ORDER BY s.ts")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtListSeriesSamples] = listSeriesSamples
+
+	listSeriesRollups, err := db.PrepareContext(ctx,
+		"SELECT r.name, r.labels, r.ts, r.min, r.max, r.sum, r.count, r.last, r.delta FROM metric_rollups r"+
+			" JOIN unnest($1::text[], $2::text[], $3::timestamptz[]) AS batch (name, labels, since)"+
+			" ON r.name = batch.name AND r.labels = batch.labels::jsonb"+
+			" WHERE r.resolution = $4 AND r.ts >= batch.since AND r.ts <= $5 ORDER BY r.ts")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtListSeriesRollups] = listSeriesRollups
+
+	// Deletes are done in batches, so that a large backlog of expired rows does not hold locks for long
+	deleteSamples, err := db.PrepareContext(ctx,
+		"DELETE FROM metric_samples WHERE ctid IN"+
+			" (SELECT ctid FROM metric_samples WHERE name = ANY($1) AND ts < $2 LIMIT $3)")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtDeleteSamples] = deleteSamples
+
+	deleteRollups, err := db.PrepareContext(ctx,
+		"DELETE FROM metric_rollups WHERE ctid IN"+
+			" (SELECT ctid FROM metric_rollups WHERE name = ANY($1) AND resolution = $2 AND ts < $3 LIMIT $4)")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtDeleteRollups] = deleteRollups
+
+	deleteMetrics, err := db.PrepareContext(ctx, "DELETE FROM metrics WHERE name = ANY($1)")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtDeleteMetrics] = deleteMetrics
+
+	deleteAllSamples, err := db.PrepareContext(ctx, "DELETE FROM metric_samples WHERE name = ANY($1)")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtDeleteAllSamples] = deleteAllSamples
+
+	deleteAllRollups, err := db.PrepareContext(ctx, "DELETE FROM metric_rollups WHERE name = ANY($1)")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtDeleteAllRollups] = deleteAllRollups
+
+	expireMetrics, err := db.PrepareContext(ctx,
+		"DELETE FROM metrics WHERE updated_at < $1 RETURNING name, labels::text")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtExpireMetrics] = expireMetrics
+
+	// History of expired series is deleted by joining their names and labels (passed as arrays)
+	deleteSeriesSamples, err := db.PrepareContext(ctx,
+		"DELETE FROM metric_samples s USING unnest($1::text[], $2::text[]) AS series (name, labels)"+
+			" WHERE s.name = series.name AND s.labels = series.labels::jsonb")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtDeleteSeriesSamples] = deleteSeriesSamples
+
+	deleteSeriesRollups, err := db.PrepareContext(ctx,
+		"DELETE FROM metric_rollups r USING unnest($1::text[], $2::text[]) AS series (name, labels)"+
+			" WHERE r.name = series.name AND r.labels = series.labels::jsonb")
+	if err != nil {
+		return nil, err
+	}
+	stmts[StmtDeleteSeriesRollups] = deleteSeriesRollups
+
 	return stmts, nil
 }
 
@@ -113,6 +314,15 @@ func (r *postgresRepo) Ping(ctx context.Context) bool {
 }
 
 func (r *postgresRepo) Store(ctx context.Context, metrics ...domain.Metric) error {
+	// The same row cannot be updated twice by one statement, the last value wins like with separate updates
+	metrics = dedupMetrics(metrics, func(_, metric domain.Metric) domain.Metric {
+		return metric
+	})
+	batch, err := newMetricsBatch(metrics)
+	if err != nil {
+		return err
+	}
+
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -121,21 +331,212 @@ func (r *postgresRepo) Store(ctx context.Context, metrics ...domain.Metric) erro
 		_ = tx.Rollback()
 	}()
 
-	stmt := tx.StmtContext(ctx, r.stmts[StmtStoreMetrics])
+	if _, err = tx.StmtContext(ctx, r.stmts[StmtStoreMetrics]).ExecContext(ctx, batch.args()...); err != nil {
+		return err
+	}
+	if err = r.storeSamples(ctx, tx, batch, metrics); err != nil {
+		return err
+	}
+	return commit(tx)
+}
 
-	for _, metric := range metrics {
-		if _, err := stmt.ExecContext(ctx, metric.Name, metric.Type, metric.Counter, metric.Gauge); err != nil {
-			return err
+func (r *postgresRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	// Duplicates in a batch are accumulated in advance, as the same row cannot be updated twice by one statement
+	merged := dedupMetrics(metrics, domain.Accumulate)
+	// Rows are always locked in the same order, so that concurrent batches do not deadlock
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Key() < merged[j].Key()
+	})
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	ensureStmt := tx.StmtContext(ctx, r.stmts[StmtEnsureMetric])
+	lockStmt := tx.StmtContext(ctx, r.stmts[StmtLockMetric])
+	for i, metric := range merged {
+		if !metric.IsHistogram() {
+			continue
+		}
+		labels, err := encodeLabels(metric.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = ensureStmt.ExecContext(ctx, metric.Name, metric.Type, labels); err != nil {
+			return nil, err
+		}
+		existing, err := scanMetric(lockStmt.QueryRowContext(ctx, metric.Name, labels))
+		if err != nil {
+			return nil, err
+		}
+		merged[i] = domain.Accumulate(existing, metric)
+	}
+
+	batch, err := newMetricsBatch(merged)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.StmtContext(ctx, r.stmts[StmtIncrementMetrics]).QueryContext(ctx, batch.args()...)
+	if err != nil {
+		return nil, err
+	}
+	updated := make(map[string]domain.Metric, len(merged))
+	for rows.Next() {
+		metric, err := scanMetric(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
 		}
+		// Returned rows are in no particular order
+		updated[metric.Key()] = metric
 	}
-	return tx.Commit()
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, metric := range merged {
+		merged[i] = updated[metric.Key()]
+	}
+	if err = r.storeSamples(ctx, tx, batch, merged); err != nil {
+		return nil, err
+	}
+	if err = commit(tx); err != nil {
+		return nil, err
+	}
+
+	result := make([]domain.Metric, len(metrics))
+	for i, metric := range metrics {
+		result[i] = updated[metric.Key()]
+	}
+	return result, nil
+}
+
+// Delete removes all series of given metric names along with their history and rollups
+func (r *postgresRepo) Delete(ctx context.Context, names ...string) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	result, err := tx.StmtContext(ctx, r.stmts[StmtDeleteMetrics]).ExecContext(ctx, names)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tx.StmtContext(ctx, r.stmts[StmtDeleteAllSamples]).ExecContext(ctx, names); err != nil {
+		return 0, err
+	}
+	if _, err = tx.StmtContext(ctx, r.stmts[StmtDeleteAllRollups]).ExecContext(ctx, names); err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(deleted), tx.Commit()
+}
+
+// Expire removes series that were not updated since 'before' along with their history and rollups
+func (r *postgresRepo) Expire(ctx context.Context, before time.Time) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.StmtContext(ctx, r.stmts[StmtExpireMetrics]).QueryContext(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+	names, labels := make([]string, 0), make([]string, 0)
+	for rows.Next() {
+		var name, encodedLabels string
+		if err = rows.Scan(&name, &encodedLabels); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		names = append(names, name)
+		labels = append(labels, encodedLabels)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+
+	if _, err = tx.StmtContext(ctx, r.stmts[StmtDeleteSeriesSamples]).ExecContext(ctx, names, labels); err != nil {
+		return 0, err
+	}
+	if _, err = tx.StmtContext(ctx, r.stmts[StmtDeleteSeriesRollups]).ExecContext(ctx, names, labels); err != nil {
+		return 0, err
+	}
+	return len(names), tx.Commit()
+}
+
+// storeSamples adds current values of metrics (already encoded in batch) to history
+func (r *postgresRepo) storeSamples(
+	ctx context.Context,
+	tx *sql.Tx,
+	batch metricsBatch,
+	metrics []domain.Metric,
+) error {
+	values := make([]float64, len(metrics))
+	for i, metric := range metrics {
+		values[i] = metric.FloatValue()
+	}
+	_, err := tx.StmtContext(ctx, r.stmts[StmtStoreSamples]).ExecContext(ctx,
+		batch.names, batch.labels, time.Now(), values)
+	return err
+}
+
+func (r *postgresRepo) History(
+	ctx context.Context,
+	name string,
+	labels domain.Labels,
+	from, to time.Time,
+) ([]domain.Sample, error) {
+	samples := make([]domain.Sample, 0)
+
+	encodedLabels, err := encodeLabels(labels)
+	if err != nil {
+		return samples, err
+	}
+	rows, err := r.stmts[StmtListSamples].QueryContext(ctx, name, encodedLabels, from, to)
+	if err != nil {
+		return samples, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sample domain.Sample
+		err = rows.Scan(&sample.Timestamp, &sample.Value)
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
 }
 
-func (r *postgresRepo) Get(ctx context.Context, name string) (domain.Metric, bool, error) {
+func (r *postgresRepo) Get(ctx context.Context, name string, labels domain.Labels) (domain.Metric, bool, error) {
 	var metric domain.Metric
 
-	err := r.stmts[StmtGetMetrics].QueryRowContext(ctx, name).
-		Scan(&metric.Name, &metric.Type, &metric.Counter, &metric.Gauge)
+	encodedLabels, err := encodeLabels(labels)
+	if err != nil {
+		return metric, false, err
+	}
+	metric, err = scanMetric(r.stmts[StmtGetMetrics].QueryRowContext(ctx, name, encodedLabels))
 
 	if err != nil && errors.Is(err, sql.ErrNoRows) {
 		return metric, false, nil
@@ -143,28 +544,24 @@ func (r *postgresRepo) Get(ctx context.Context, name string) (domain.Metric, boo
 	return metric, err == nil, err
 }
 
+// List pushes filter predicates down into SQL, unfiltered and by-name lists use prepared statements
 func (r *postgresRepo) List(ctx context.Context, filter *domain.MetricsFilter) ([]domain.Metric, error) {
 	metrics := make([]domain.Metric, 0)
+	if _, err := filter.Matcher(); err != nil {
+		return metrics, errors.Wrap(err, "[postgres repo] invalid filter")
+	}
 
 	var rows *sql.Rows
 	var err error
 
-	if filter != nil && len(filter.Names) > 0 {
-		// Prepare args array (must have '[]any' type)
-		args := make([]any, 0)
-		// Build query with dynamic number of arguments
-		query := "SELECT name, type, counter, gauge FROM metrics WHERE name IN ("
-		for i, name := range filter.Names {
-			if i > 0 {
-				query += ","
-			}
-			query += fmt.Sprintf("$%d", i+1)
-			args = append(args, name)
-		}
-		query += ")"
-		rows, err = r.db.QueryContext(ctx, query, args...)
-	} else {
+	switch {
+	case filter.IsEmpty():
 		rows, err = r.stmts[StmtListMetrics].QueryContext(ctx)
+	case filter.OnlyNames():
+		rows, err = r.stmts[StmtListMetricsByNames].QueryContext(ctx, filter.Names)
+	default:
+		query, args := buildListQuery(filter)
+		rows, err = r.db.QueryContext(ctx, query, args...)
 	}
 
 	if err != nil {
@@ -173,8 +570,7 @@ func (r *postgresRepo) List(ctx context.Context, filter *domain.MetricsFilter) (
 	defer rows.Close()
 
 	for rows.Next() {
-		var metric domain.Metric
-		err = rows.Scan(&metric.Name, &metric.Type, &metric.Counter, &metric.Gauge)
+		metric, err := scanMetric(rows)
 		if err != nil {
 			return metrics, err
 		}
@@ -182,3 +578,141 @@ func (r *postgresRepo) List(ctx context.Context, filter *domain.MetricsFilter) (
 	}
 	return metrics, rows.Err()
 }
+
+// metricsBatch holds metrics as column arrays, which are passed to statements as parameters and unnested
+// Labels and histograms are passed as text and cast to jsonb by the statement
+type metricsBatch struct {
+	names      []string
+	types      []string
+	counters   []int64
+	gauges     []float64
+	labels     []string
+	histograms []*string
+}
+
+func newMetricsBatch(metrics []domain.Metric) (metricsBatch, error) {
+	batch := metricsBatch{
+		names:      make([]string, len(metrics)),
+		types:      make([]string, len(metrics)),
+		counters:   make([]int64, len(metrics)),
+		gauges:     make([]float64, len(metrics)),
+		labels:     make([]string, len(metrics)),
+		histograms: make([]*string, len(metrics)),
+	}
+	for i, metric := range metrics {
+		labels, err := encodeLabels(metric.Labels)
+		if err != nil {
+			return batch, err
+		}
+		histogram, err := encodeHistogram(metric.Histogram)
+		if err != nil {
+			return batch, err
+		}
+		batch.names[i] = metric.Name
+		batch.types[i] = metric.Type
+		batch.counters[i] = int64(metric.Counter)
+		batch.gauges[i] = float64(metric.Gauge)
+		batch.labels[i] = labels
+		if encoded, ok := histogram.(string); ok {
+			batch.histograms[i] = &encoded
+		}
+	}
+	return batch, nil
+}
+
+func (b metricsBatch) args() []any {
+	return []any{b.names, b.types, b.counters, b.gauges, b.labels, b.histograms}
+}
+
+// commit wraps commit errors with ErrWriteUnconfirmed, as the transaction might have been committed anyway
+// (unless it was already rolled back, e.g. because ctx was cancelled)
+func commit(tx *sql.Tx) error {
+	err := tx.Commit()
+	if err != nil && !errors.Is(err, sql.ErrTxDone) {
+		return errors.Wrapf(ErrWriteUnconfirmed, "[postgres repo] commit failed: %s", err.Error())
+	}
+	return err
+}
+
+// dedupMetrics combines metrics of the same series into one, keeping the position of the first one
+func dedupMetrics(metrics []domain.Metric, combine func(existing, metric domain.Metric) domain.Metric) []domain.Metric {
+	result := make([]domain.Metric, 0, len(metrics))
+	positions := make(map[string]int, len(metrics))
+	for _, metric := range metrics {
+		key := metric.Key()
+		if i, ok := positions[key]; ok {
+			result[i] = combine(result[i], metric)
+			continue
+		}
+		positions[key] = len(result)
+		result = append(result, metric)
+	}
+	return result
+}
+
+// rowScanner is either *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMetric(row rowScanner) (domain.Metric, error) {
+	var metric domain.Metric
+	var labels, histogram []byte
+
+	err := row.Scan(&metric.Name, &metric.Type, &metric.Counter, &metric.Gauge, &labels, &histogram)
+	if err != nil {
+		return metric, err
+	}
+	if metric.Labels, err = decodeLabels(labels); err != nil {
+		return metric, err
+	}
+	metric.Histogram, err = decodeHistogram(histogram)
+	return metric, err
+}
+
+// encodeLabels encodes labels as JSON object for jsonb columns, series without labels have '{}'
+// jsonb normalizes key order, so the same labels always compare equal
+func encodeLabels(labels domain.Labels) (string, error) {
+	if len(labels) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return "", errors.Wrap(err, "[postgres repo] error when encoding labels")
+	}
+	return string(data), nil
+}
+
+// encodeHistogram encodes histogram as JSON object for jsonb column, metrics of other types have NULL
+func encodeHistogram(histogram *domain.Histogram) (any, error) {
+	if histogram == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(histogram)
+	if err != nil {
+		return nil, errors.Wrap(err, "[postgres repo] error when encoding histogram")
+	}
+	return string(data), nil
+}
+
+func decodeHistogram(data []byte) (*domain.Histogram, error) {
+	if data == nil {
+		return nil, nil
+	}
+	histogram := &domain.Histogram{}
+	if err := json.Unmarshal(data, histogram); err != nil {
+		return nil, errors.Wrap(err, "[postgres repo] error when decoding histogram")
+	}
+	return histogram, nil
+}
+
+func decodeLabels(data []byte) (domain.Labels, error) {
+	var labels domain.Labels
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, errors.Wrap(err, "[postgres repo] error when decoding labels")
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, nil
+}
@@ -0,0 +1,454 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// Hash fields of a series, histogram buckets are kept in 'b0'..'bN' fields, so they can be incremented one by one
+const (
+	redisFieldName      = "name"
+	redisFieldType      = "type"
+	redisFieldLabels    = "labels"
+	redisFieldCounter   = "counter"
+	redisFieldGauge     = "gauge"
+	redisFieldBounds    = "bounds"
+	redisFieldSum       = "sum"
+	redisFieldCount     = "count"
+	redisFieldUpdated   = "updated"
+	redisFieldBucketPfx = "b"
+)
+
+// redisIncrementScript applies metric on top of the stored series and returns the whole series hash
+// KEYS: series hash, index of all series, index of series of the same name
+// ARGV: series key, name, type, labels, counter, gauge, bounds, sum, count, updated, bucket counts...
+// Series is reset if its type changes, histogram is reset if its bounds change (buckets were reconfigured)
+var redisIncrementScript = redis.NewScript(`
+local key = KEYS[1]
+redis.call('SADD', KEYS[2], ARGV[1])
+redis.call('SADD', KEYS[3], ARGV[1])
+
+local mtype = ARGV[3]
+local reset = redis.call('HGET', key, 'type') ~= mtype
+if mtype == 'histogram' and redis.call('HGET', key, 'bounds') ~= ARGV[7] then
+	reset = true
+end
+if reset then
+	redis.call('DEL', key)
+	redis.call('HSET', key, 'name', ARGV[2], 'type', mtype, 'labels', ARGV[4])
+end
+redis.call('HSET', key, 'updated', ARGV[10])
+
+if mtype == 'counter' then
+	redis.call('HINCRBY', key, 'counter', ARGV[5])
+elseif mtype == 'gauge' then
+	redis.call('HSET', key, 'gauge', ARGV[6])
+elseif mtype == 'histogram' then
+	redis.call('HSET', key, 'bounds', ARGV[7])
+	redis.call('HINCRBYFLOAT', key, 'sum', ARGV[8])
+	redis.call('HINCRBY', key, 'count', ARGV[9])
+	for i = 11, #ARGV do
+		redis.call('HINCRBY', key, 'b' .. (i - 11), ARGV[i])
+	end
+end
+return redis.call('HGETALL', key)
+`)
+
+// redisRepo stores every series in a hash, counters and histograms are accumulated atomically by Redis itself,
+// so that several server replicas can share it
+// Every hash also holds time of the last update (in unix milliseconds), which is used to expire stale series
+type redisRepo struct {
+	client *redis.Client
+	cfg    config.RedisConfig
+}
+
+func NewRedisRepo(ctx context.Context, cfg config.RedisConfig) (*redisRepo, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         cfg.Address,
+		Password:     cfg.Password,
+		DB:           cfg.DB,
+		DialTimeout:  cfg.Timeout,
+		ReadTimeout:  cfg.Timeout,
+		WriteTimeout: cfg.Timeout,
+	})
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	if err := client.Ping(timeoutCtx).Err(); err != nil {
+		return nil, errors.Wrap(err, "[redis repo] error when connecting to redis")
+	}
+	if err := redisIncrementScript.Load(timeoutCtx, client).Err(); err != nil {
+		return nil, errors.Wrap(err, "[redis repo] error when loading increment script")
+	}
+	logger.New(ctx).Infof("[redis repo] connected to redis")
+
+	return &redisRepo{
+		client: client,
+		cfg:    cfg,
+	}, nil
+}
+
+func (r *redisRepo) Ping(ctx context.Context) bool {
+	timeoutCtx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+
+	err := r.client.Ping(timeoutCtx).Err()
+	if err != nil {
+		logger.New(ctx).Errorf("[redis repo] error on ping: %s", err.Error())
+		return false
+	}
+	return true
+}
+
+// Store overwrites series with given metrics
+func (r *redisRepo) Store(ctx context.Context, metrics ...domain.Metric) error {
+	now := time.Now().UnixMilli()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, metric := range metrics {
+			fields, err := encodeRedisFields(metric)
+			if err != nil {
+				return err
+			}
+			fields[redisFieldUpdated] = now
+			key := r.seriesKey(metric.Key())
+			pipe.Del(ctx, key)
+			pipe.HSet(ctx, key, fields)
+			pipe.SAdd(ctx, r.indexKey(), metric.Key())
+			pipe.SAdd(ctx, r.nameIndexKey(metric.Name), metric.Key())
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "[redis repo] error when storing metrics")
+	}
+	return nil
+}
+
+func (r *redisRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	cmds := make([]*redis.Cmd, 0, len(metrics))
+
+	now := time.Now().UnixMilli()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, metric := range metrics {
+			args, err := encodeRedisIncrementArgs(metric, now)
+			if err != nil {
+				return err
+			}
+			keys := []string{r.seriesKey(metric.Key()), r.indexKey(), r.nameIndexKey(metric.Name)}
+			cmds = append(cmds, redisIncrementScript.EvalSha(ctx, pipe, keys, args...))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "[redis repo] error when incrementing metrics")
+	}
+
+	result := make([]domain.Metric, 0, len(metrics))
+	for _, cmd := range cmds {
+		values, err := cmd.StringSlice()
+		if err != nil {
+			return nil, errors.Wrap(err, "[redis repo] error when reading incremented metric")
+		}
+		fields := make(map[string]string, len(values)/2)
+		for i := 0; i+1 < len(values); i += 2 {
+			fields[values[i]] = values[i+1]
+		}
+		metric, err := decodeRedisFields(fields)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, metric)
+	}
+	return result, nil
+}
+
+func (r *redisRepo) Get(ctx context.Context, name string, labels domain.Labels) (domain.Metric, bool, error) {
+	fields, err := r.client.HGetAll(ctx, r.seriesKey(domain.SeriesKey(name, labels))).Result()
+	if err != nil {
+		return domain.Metric{}, false, errors.Wrap(err, "[redis repo] error when getting metric")
+	}
+	if len(fields) == 0 {
+		return domain.Metric{}, false, nil
+	}
+	metric, err := decodeRedisFields(fields)
+	return metric, err == nil, err
+}
+
+// List reads series of filtered names by the name index, other predicates are matched after reading
+func (r *redisRepo) List(ctx context.Context, filter *domain.MetricsFilter) ([]domain.Metric, error) {
+	metrics := make([]domain.Metric, 0)
+	matches, err := filter.Matcher()
+	if err != nil {
+		return metrics, errors.Wrap(err, "[redis repo] invalid filter")
+	}
+
+	var keys []string
+	if filter != nil && len(filter.Names) > 0 {
+		indexes := make([]string, 0, len(filter.Names))
+		for _, name := range filter.Names {
+			indexes = append(indexes, r.nameIndexKey(name))
+		}
+		keys, err = r.client.SUnion(ctx, indexes...).Result()
+	} else {
+		keys, err = r.client.SMembers(ctx, r.indexKey()).Result()
+	}
+	if err != nil {
+		return metrics, errors.Wrap(err, "[redis repo] error when listing series")
+	}
+
+	cmds := make([]*redis.StringStringMapCmd, 0, len(keys))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds = append(cmds, pipe.HGetAll(ctx, r.seriesKey(key)))
+		}
+		return nil
+	})
+	if err != nil {
+		return metrics, errors.Wrap(err, "[redis repo] error when listing metrics")
+	}
+
+	for _, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			// Series is in the index, but its hash is gone (e.g. removed by hand)
+			continue
+		}
+		metric, err := decodeRedisFields(fields)
+		if err != nil {
+			return metrics, err
+		}
+		if matches(metric) {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
+
+// Delete removes all series of given metric names
+func (r *redisRepo) Delete(ctx context.Context, names ...string) (int, error) {
+	deleted := 0
+	for _, name := range names {
+		keys, err := r.client.SMembers(ctx, r.nameIndexKey(name)).Result()
+		if err != nil {
+			return deleted, errors.Wrap(err, "[redis repo] error when listing series")
+		}
+		if err = r.delete(ctx, name, keys...); err != nil {
+			return deleted, err
+		}
+		deleted += len(keys)
+	}
+	return deleted, nil
+}
+
+// Expire removes series that were not updated since 'before'
+// Series stored without time of the last update are kept until they are updated again
+func (r *redisRepo) Expire(ctx context.Context, before time.Time) (int, error) {
+	keys, err := r.client.SMembers(ctx, r.indexKey()).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "[redis repo] error when listing series")
+	}
+
+	cmds := make([]*redis.SliceCmd, 0, len(keys))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds = append(cmds, pipe.HMGet(ctx, r.seriesKey(key), redisFieldName, redisFieldUpdated))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "[redis repo] error when reading update times")
+	}
+
+	deleted := 0
+	for i, cmd := range cmds {
+		values := cmd.Val()
+		name, _ := values[0].(string)
+		updatedText, _ := values[1].(string)
+		updated, parseErr := strconv.ParseInt(updatedText, 10, 64)
+		if parseErr != nil || updated >= before.UnixMilli() {
+			continue
+		}
+		if err = r.delete(ctx, name, keys[i]); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// delete removes series of the same metric name by their keys
+func (r *redisRepo) delete(ctx context.Context, name string, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, r.seriesKey(key))
+			pipe.SRem(ctx, r.indexKey(), key)
+			pipe.SRem(ctx, r.nameIndexKey(name), key)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "[redis repo] error when deleting series")
+	}
+	return nil
+}
+
+// Close closes connections to redis
+func (r *redisRepo) Close() error {
+	if err := r.client.Close(); err != nil {
+		return errors.Wrap(err, "[redis repo] error when closing client")
+	}
+	return nil
+}
+
+func (r *redisRepo) seriesKey(key string) string {
+	return r.cfg.Prefix + ":series:" + key
+}
+
+func (r *redisRepo) indexKey() string {
+	return r.cfg.Prefix + ":index"
+}
+
+func (r *redisRepo) nameIndexKey(name string) string {
+	return r.cfg.Prefix + ":index:" + name
+}
+
+func encodeRedisFields(metric domain.Metric) (map[string]any, error) {
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]any{
+		redisFieldName:   metric.Name,
+		redisFieldType:   metric.Type,
+		redisFieldLabels: labels,
+	}
+	switch metric.Type {
+	case domain.TypeCounter:
+		fields[redisFieldCounter] = int64(metric.Counter)
+	case domain.TypeGauge:
+		fields[redisFieldGauge] = formatRedisFloat(float64(metric.Gauge))
+	case domain.TypeHistogram:
+		if metric.Histogram == nil {
+			break
+		}
+		bounds, err := json.Marshal(metric.Histogram.Bounds)
+		if err != nil {
+			return nil, errors.Wrap(err, "[redis repo] error when encoding histogram bounds")
+		}
+		fields[redisFieldBounds] = string(bounds)
+		fields[redisFieldSum] = formatRedisFloat(metric.Histogram.Sum)
+		fields[redisFieldCount] = metric.Histogram.Count
+		for i, count := range metric.Histogram.Counts {
+			fields[redisFieldBucketPfx+strconv.Itoa(i)] = count
+		}
+	}
+	return fields, nil
+}
+
+func encodeRedisIncrementArgs(metric domain.Metric, updated int64) ([]any, error) {
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		return nil, err
+	}
+	args := []any{
+		metric.Key(),
+		metric.Name,
+		metric.Type,
+		labels,
+		int64(metric.Counter),
+		formatRedisFloat(float64(metric.Gauge)),
+	}
+	if metric.Histogram == nil {
+		return append(args, "", 0, 0, updated), nil
+	}
+	bounds, err := json.Marshal(metric.Histogram.Bounds)
+	if err != nil {
+		return nil, errors.Wrap(err, "[redis repo] error when encoding histogram bounds")
+	}
+	args = append(args, string(bounds), formatRedisFloat(metric.Histogram.Sum), metric.Histogram.Count, updated)
+	for _, count := range metric.Histogram.Counts {
+		args = append(args, count)
+	}
+	return args, nil
+}
+
+func decodeRedisFields(fields map[string]string) (domain.Metric, error) {
+	metric := domain.Metric{
+		Name: fields[redisFieldName],
+		Type: fields[redisFieldType],
+	}
+	labels, err := decodeLabels([]byte(fields[redisFieldLabels]))
+	if err != nil {
+		return metric, err
+	}
+	metric.Labels = labels
+
+	switch metric.Type {
+	case domain.TypeCounter:
+		counter, err := strconv.ParseInt(fields[redisFieldCounter], 10, 64)
+		if err != nil {
+			return metric, errors.Wrap(err, "[redis repo] error when decoding counter")
+		}
+		metric.Counter = domain.Counter(counter)
+	case domain.TypeGauge:
+		gauge, err := strconv.ParseFloat(fields[redisFieldGauge], 64)
+		if err != nil {
+			return metric, errors.Wrap(err, "[redis repo] error when decoding gauge")
+		}
+		metric.Gauge = domain.Gauge(gauge)
+	case domain.TypeHistogram:
+		histogram, err := decodeRedisHistogram(fields)
+		if err != nil {
+			return metric, errors.Wrap(err, "[redis repo] error when decoding histogram")
+		}
+		metric.Histogram = histogram
+	}
+	return metric, nil
+}
+
+func decodeRedisHistogram(fields map[string]string) (*domain.Histogram, error) {
+	var bounds []float64
+	if err := json.Unmarshal([]byte(fields[redisFieldBounds]), &bounds); err != nil {
+		return nil, err
+	}
+	histogram := domain.NewHistogramValue(bounds)
+
+	var err error
+	if histogram.Sum, err = strconv.ParseFloat(fields[redisFieldSum], 64); err != nil {
+		return nil, err
+	}
+	if histogram.Count, err = strconv.ParseUint(fields[redisFieldCount], 10, 64); err != nil {
+		return nil, err
+	}
+	for field, value := range fields {
+		if !strings.HasPrefix(field, redisFieldBucketPfx) {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimPrefix(field, redisFieldBucketPfx))
+		if err != nil || i < 0 || i >= len(histogram.Counts) {
+			continue
+		}
+		if histogram.Counts[i], err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return histogram, nil
+}
+
+func formatRedisFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
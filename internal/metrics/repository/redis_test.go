@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+func openTestRedis(t *testing.T) (*redisRepo, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	repo, err := NewRedisRepo(context.Background(), config.RedisConfig{
+		Address: server.Addr(),
+		Prefix:  "test",
+		Timeout: time.Second,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo, server
+}
+
+func TestRedisIncrementWithRaceCondition(t *testing.T) {
+	ctx := context.Background()
+	repo, server := openTestRedis(t)
+
+	// Two replicas share the same redis
+	replica, err := NewRedisRepo(ctx, config.RedisConfig{Address: server.Addr(), Prefix: "test", Timeout: time.Second})
+	require.NoError(t, err)
+	defer replica.Close()
+
+	wg := &sync.WaitGroup{}
+	for _, r := range []*redisRepo{repo, replica} {
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(r *redisRepo) {
+				defer wg.Done()
+				_, incErr := r.Increment(ctx, domain.NewCounter(domain.PollCount, 1))
+				assert.NoError(t, incErr)
+			}(r)
+		}
+	}
+	wg.Wait()
+
+	metric, found, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(100), metric.Counter)
+}
+
+func TestRedisPing(t *testing.T) {
+	ctx := context.Background()
+	repo, server := openTestRedis(t)
+
+	assert.True(t, repo.Ping(ctx))
+	server.Close()
+	assert.False(t, repo.Ping(ctx))
+}
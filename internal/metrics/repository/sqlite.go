@@ -0,0 +1,351 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-migrate/migrate/v4"
+	// sqlite init
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+const (
+	sqliteStmtStoreMetrics = iota
+	sqliteStmtIncrementMetrics
+	sqliteStmtEnsureMetric
+	sqliteStmtGetMetrics
+	sqliteStmtListMetrics
+	sqliteStmtExpireMetrics
+)
+
+// sqliteRepo stores metrics in an embedded SQLite database file, it keeps current values only (no history)
+type sqliteRepo struct {
+	db    *sql.DB
+	cfg   config.SQLiteConfig
+	stmts map[int]*sql.Stmt
+}
+
+func NewSQLiteRepo(ctx context.Context, cfg config.SQLiteConfig) (*sqliteRepo, error) {
+	db, err := sql.Open("sqlite", sqliteDSN(cfg))
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer at a time, so a single connection avoids 'database is locked' errors
+	db.SetMaxOpenConns(1)
+	if err = db.PingContext(ctx); err != nil {
+		return nil, errors.Wrap(err, "[sqlite repo] error when opening database")
+	}
+	logger.New(ctx).Infof("[sqlite repo] opened database %s", cfg.Path)
+
+	if cfg.MigrationsDir != "" {
+		// Run migrations
+		m, migrateErr := migrate.New(fmt.Sprintf("file://%s", cfg.MigrationsDir), fmt.Sprintf("sqlite://%s", cfg.Path))
+		if migrateErr != nil {
+			return nil, migrateErr
+		}
+
+		migrateErr = m.Up()
+		switch {
+		case migrateErr == nil:
+			logger.New(ctx).Infof("[sqlite repo] migrations successfully applied")
+		case errors.Is(migrateErr, migrate.ErrNoChange):
+			logger.New(ctx).Infof("[sqlite repo] migrations: no change")
+		default:
+			return nil, migrateErr
+		}
+		if sourceErr, dbErr := m.Close(); sourceErr != nil || dbErr != nil {
+			return nil, errors.Errorf("[sqlite repo] error when closing migrations: %v, %v", sourceErr, dbErr)
+		}
+	}
+
+	stmts, err := initSQLiteStatements(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteRepo{
+		db:    db,
+		cfg:   cfg,
+		stmts: stmts,
+	}, nil
+}
+
+// sqliteDSN adds pragmas to database path, they are per-connection settings, so they are applied by the driver
+// to every connection it opens (e.g. after the connection is recycled by database/sql)
+func sqliteDSN(cfg config.SQLiteConfig) string {
+	pragmas := url.Values{"_pragma": {
+		fmt.Sprintf("busy_timeout(%d)", cfg.Timeout.Milliseconds()),
+		"journal_mode(WAL)",
+		"synchronous(FULL)",
+	}}
+	return "file:" + cfg.Path + "?" + pragmas.Encode()
+}
+
+func initSQLiteStatements(ctx context.Context, db *sql.DB) (map[int]*sql.Stmt, error) {
+	stmts := make(map[int]*sql.Stmt, 0)
+
+	storeMetrics, err := db.PrepareContext(ctx,
+		"INSERT INTO metrics (name, type, counter, gauge, labels, histogram, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)"+
+			" ON CONFLICT (name, labels) DO UPDATE SET counter = excluded.counter, gauge = excluded.gauge,"+
+			" histogram = excluded.histogram, updated_at = excluded.updated_at")
+	if err != nil {
+		return nil, err
+	}
+	stmts[sqliteStmtStoreMetrics] = storeMetrics
+
+	incrementMetrics, err := db.PrepareContext(ctx,
+		"INSERT INTO metrics AS m (name, type, counter, gauge, labels, histogram, updated_at)"+
+			" VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (name, labels) DO UPDATE SET"+
+			" counter = CASE WHEN m.type = 'counter' AND excluded.type = 'counter'"+
+			" THEN m.counter + excluded.counter ELSE excluded.counter END, type = excluded.type,"+
+			" gauge = excluded.gauge, histogram = excluded.histogram, updated_at = excluded.updated_at"+
+			" RETURNING name, type, counter, gauge, labels, histogram")
+	if err != nil {
+		return nil, err
+	}
+	stmts[sqliteStmtIncrementMetrics] = incrementMetrics
+
+	// Inserting a row takes the database write lock, so a histogram cannot change between reading and merging it
+	ensureMetric, err := db.PrepareContext(ctx,
+		"INSERT INTO metrics (name, type, counter, gauge, labels) VALUES (?, ?, 0, 0, ?)"+
+			" ON CONFLICT (name, labels) DO NOTHING")
+	if err != nil {
+		return nil, err
+	}
+	stmts[sqliteStmtEnsureMetric] = ensureMetric
+
+	getMetrics, err := db.PrepareContext(ctx,
+		"SELECT name, type, counter, gauge, labels, histogram FROM metrics WHERE name = ? AND labels = ?")
+	if err != nil {
+		return nil, err
+	}
+	stmts[sqliteStmtGetMetrics] = getMetrics
+
+	listMetrics, err := db.PrepareContext(ctx,
+		"SELECT name, type, counter, gauge, labels, histogram FROM metrics ORDER BY id desc")
+	if err != nil {
+		return nil, err
+	}
+	stmts[sqliteStmtListMetrics] = listMetrics
+
+	expireMetrics, err := db.PrepareContext(ctx, "DELETE FROM metrics WHERE updated_at < ?")
+	if err != nil {
+		return nil, err
+	}
+	stmts[sqliteStmtExpireMetrics] = expireMetrics
+
+	return stmts, nil
+}
+
+func (r *sqliteRepo) Ping(ctx context.Context) bool {
+	timeoutCtx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+
+	err := r.db.PingContext(timeoutCtx)
+	if err != nil {
+		logger.New(ctx).Errorf("[sqlite repo] error on ping: %s", err.Error())
+		return false
+	}
+	return true
+}
+
+func (r *sqliteRepo) Store(ctx context.Context, metrics ...domain.Metric) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := tx.StmtContext(ctx, r.stmts[sqliteStmtStoreMetrics])
+
+	// Time is kept as unix milliseconds
+	now := time.Now().UnixMilli()
+	for _, metric := range metrics {
+		// Labels are encoded with sorted keys, so the same labels always compare equal as text
+		labels, err := encodeLabels(metric.Labels)
+		if err != nil {
+			return err
+		}
+		histogram, err := encodeHistogram(metric.Histogram)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx, metric.Name, metric.Type, metric.Counter, metric.Gauge, labels, histogram, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *sqliteRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	incrementStmt := tx.StmtContext(ctx, r.stmts[sqliteStmtIncrementMetrics])
+	ensureStmt := tx.StmtContext(ctx, r.stmts[sqliteStmtEnsureMetric])
+	getStmt := tx.StmtContext(ctx, r.stmts[sqliteStmtGetMetrics])
+
+	result := make([]domain.Metric, len(metrics))
+	now := time.Now().UnixMilli()
+	for i, metric := range metrics {
+		labels, err := encodeLabels(metric.Labels)
+		if err != nil {
+			return nil, err
+		}
+
+		if metric.IsHistogram() {
+			if _, err = ensureStmt.ExecContext(ctx, metric.Name, metric.Type, labels); err != nil {
+				return nil, err
+			}
+			existing, err := scanMetric(getStmt.QueryRowContext(ctx, metric.Name, labels))
+			if err != nil {
+				return nil, err
+			}
+			metric = domain.Accumulate(existing, metric)
+		}
+
+		histogram, err := encodeHistogram(metric.Histogram)
+		if err != nil {
+			return nil, err
+		}
+		row := incrementStmt.QueryRowContext(ctx,
+			metric.Name, metric.Type, metric.Counter, metric.Gauge, labels, histogram, now)
+		if result[i], err = scanMetric(row); err != nil {
+			return nil, err
+		}
+	}
+	return result, tx.Commit()
+}
+
+func (r *sqliteRepo) Get(ctx context.Context, name string, labels domain.Labels) (domain.Metric, bool, error) {
+	var metric domain.Metric
+
+	encodedLabels, err := encodeLabels(labels)
+	if err != nil {
+		return metric, false, err
+	}
+	metric, err = scanMetric(r.stmts[sqliteStmtGetMetrics].QueryRowContext(ctx, name, encodedLabels))
+
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return metric, false, nil
+	}
+	return metric, err == nil, err
+}
+
+// List pushes names, types and prefix down into SQL, patterns and labels are matched after reading
+func (r *sqliteRepo) List(ctx context.Context, filter *domain.MetricsFilter) ([]domain.Metric, error) {
+	metrics := make([]domain.Metric, 0)
+	matches, err := filter.Matcher()
+	if err != nil {
+		return metrics, errors.Wrap(err, "[sqlite repo] invalid filter")
+	}
+
+	var rows *sql.Rows
+	if filter.IsEmpty() {
+		rows, err = r.stmts[sqliteStmtListMetrics].QueryContext(ctx)
+	} else {
+		query, args := buildSQLiteListQuery(filter)
+		rows, err = r.db.QueryContext(ctx, query, args...)
+	}
+
+	if err != nil {
+		return metrics, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		metric, err := scanMetric(rows)
+		if err != nil {
+			return metrics, err
+		}
+		if matches(metric) {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, rows.Err()
+}
+
+func buildSQLiteListQuery(filter *domain.MetricsFilter) (string, []any) {
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+	in := func(column string, values []string) {
+		for _, value := range values {
+			args = append(args, value)
+		}
+		conditions = append(conditions, column+" IN ("+strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")+")")
+	}
+
+	if len(filter.Names) > 0 {
+		in("name", filter.Names)
+	}
+	if len(filter.Types) > 0 {
+		in("type", filter.Types)
+	}
+	if filter.Prefix != "" {
+		conditions = append(conditions, "substr(name, 1, ?) = ?")
+		// substr counts characters, not bytes
+		args = append(args, utf8.RuneCountInString(filter.Prefix), filter.Prefix)
+	}
+
+	query := "SELECT name, type, counter, gauge, labels, histogram FROM metrics"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return query + " ORDER BY id desc", args
+}
+
+// Delete removes all series of given metric names
+func (r *sqliteRepo) Delete(ctx context.Context, names ...string) (int, error) {
+	if len(names) == 0 {
+		return 0, nil
+	}
+	args := make([]any, 0, len(names))
+	for _, name := range names {
+		args = append(args, name)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(names)), ",")
+	result, err := r.db.ExecContext(ctx, "DELETE FROM metrics WHERE name IN ("+placeholders+")", args...)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+// Expire removes series that were not updated since 'before'
+func (r *sqliteRepo) Expire(ctx context.Context, before time.Time) (int, error) {
+	result, err := r.stmts[sqliteStmtExpireMetrics].ExecContext(ctx, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+// Close closes database, it should be called after the last Store
+func (r *sqliteRepo) Close() error {
+	if err := r.db.Close(); err != nil {
+		return errors.Wrap(err, "[sqlite repo] error when closing database")
+	}
+	return nil
+}
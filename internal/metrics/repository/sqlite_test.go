@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+func openTestSQLite(t *testing.T, path string) *sqliteRepo {
+	repo, err := NewSQLiteRepo(context.Background(), config.SQLiteConfig{
+		Path:          path,
+		MigrationsDir: "../../../migrations/sqlite",
+		Timeout:       time.Second,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
+
+func TestSQLiteReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.db")
+
+	repo := openTestSQLite(t, path)
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5)))
+	assert.True(t, repo.Ping(ctx))
+	require.NoError(t, repo.Close())
+
+	// Migrations are not applied twice, data is kept
+	repo = openTestSQLite(t, path)
+	metric, found, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(5), metric.Counter)
+}
+
+func TestSQLitePragmasOnNewConnections(t *testing.T) {
+	ctx := context.Background()
+	repo := openTestSQLite(t, filepath.Join(t.TempDir(), "metrics.db"))
+	// Connection is closed after every use, so every query runs on a new one
+	repo.db.SetMaxIdleConns(0)
+
+	for i := 0; i < 2; i++ {
+		var busyTimeout, synchronous int
+		var journalMode string
+		require.NoError(t, repo.db.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout))
+		require.NoError(t, repo.db.QueryRowContext(ctx, "PRAGMA synchronous").Scan(&synchronous))
+		require.NoError(t, repo.db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode))
+		assert.Equal(t, 1000, busyTimeout)
+		assert.Equal(t, 2, synchronous, "FULL")
+		assert.Equal(t, "wal", journalMode)
+	}
+}
@@ -0,0 +1,405 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+const (
+	walLogFile      = "wal.log"
+	walSnapshotFile = "snapshot.json"
+)
+
+var (
+	ErrWALClosed = errors.New("[wal repo] repository is closed")
+	ErrWALFailed = errors.New("[wal repo] log could not be restored after failed write, repository is read-only")
+)
+
+// walFile is the log file, so that failing writes can be simulated in tests
+type walFile interface {
+	io.WriteSeeker
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// walRepo keeps metrics in memory and makes every Store durable by appending it to a write-ahead log first
+// Log is compacted by checkpoints: a snapshot of all metrics is written and the log is truncated
+// Every record has a sequence number, so that records already covered by the snapshot are skipped on replay
+// History is not logged, so it is not kept (and served) by this repo
+type walRepo struct {
+	mem *inMemRepo
+	dir string
+	log walFile
+	seq uint64
+	// failed is set if a partially written record could not be removed from the log,
+	// records appended after it would be lost on replay, so writes are refused
+	failed bool
+	closed bool
+	mutex  *sync.Mutex
+}
+
+// walRecord is a single Store call, metrics hold values after update (i.e. accumulated counters),
+// so replaying a record twice gives the same result
+// Timestamp is when metrics were stored, so that their update times (used for expiry) survive restarts
+// Deletes (and expiry) are logged as keys of deleted series
+type walRecord struct {
+	Seq       uint64          `json:"seq"`
+	Timestamp time.Time       `json:"ts"`
+	Metrics   []domain.Metric `json:"metrics"`
+	Deleted   []string        `json:"deleted,omitempty"`
+}
+
+// walSnapshot holds all metrics as of log record with sequence number Seq, along with their update times
+// (by series key)
+type walSnapshot struct {
+	Seq       uint64               `json:"seq"`
+	Timestamp time.Time            `json:"timestamp"`
+	Metrics   []domain.Metric      `json:"metrics"`
+	Updated   map[string]time.Time `json:"updated"`
+}
+
+func NewWALRepo(ctx context.Context, cfg config.WALConfig) (*walRepo, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "[wal repo] error when creating WAL directory")
+	}
+	r := &walRepo{
+		mem:   NewInMemRepoWithHistory(0),
+		dir:   cfg.Dir,
+		mutex: &sync.Mutex{},
+	}
+	if err := r.replay(ctx); err != nil {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(filepath.Join(r.dir, walLogFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "[wal repo] error when opening log")
+	}
+	r.log = log
+
+	if cfg.CheckpointInterval > 0 {
+		go r.startCheckpointing(ctx, cfg.CheckpointInterval)
+	}
+	logger.New(ctx).Infof("[wal repo] logging to %s", cfg.Dir)
+	return r, nil
+}
+
+func (r *walRepo) Store(ctx context.Context, metrics ...domain.Metric) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	if err := r.append(walRecord{Timestamp: now, Metrics: metrics}); err != nil {
+		return err
+	}
+	// Record is durable, now it can be applied
+	r.apply(metrics, now)
+	return nil
+}
+
+func (r *walRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Writes are serialized by WAL mutex, so stored values cannot change between reading and writing them
+	r.mem.mutex.RLock()
+	result := r.mem.accumulate(metrics)
+	r.mem.mutex.RUnlock()
+
+	// Resulting values are logged (not deltas), so that replaying a record twice gives the same result
+	now := time.Now()
+	if err := r.append(walRecord{Timestamp: now, Metrics: result}); err != nil {
+		return nil, err
+	}
+	r.apply(result, now)
+	return result, nil
+}
+
+func (r *walRepo) Get(ctx context.Context, name string, labels domain.Labels) (domain.Metric, bool, error) {
+	return r.mem.Get(ctx, name, labels)
+}
+
+func (r *walRepo) List(ctx context.Context, filter *domain.MetricsFilter) ([]domain.Metric, error) {
+	return r.mem.List(ctx, filter)
+}
+
+func (r *walRepo) Delete(ctx context.Context, names ...string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.mem.mutex.RLock()
+	keys := make([]string, 0)
+	for key := range r.mem.metrics {
+		if isSeriesOf(key, names) {
+			keys = append(keys, key)
+		}
+	}
+	r.mem.mutex.RUnlock()
+
+	return len(keys), r.delete(keys)
+}
+
+func (r *walRepo) Expire(ctx context.Context, before time.Time) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.mem.mutex.RLock()
+	keys := r.mem.staleKeys(before)
+	r.mem.mutex.RUnlock()
+
+	return len(keys), r.delete(keys)
+}
+
+// delete logs deletion of series with given keys and applies it, mutex must be held by caller
+func (r *walRepo) delete(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := r.append(walRecord{Deleted: keys}); err != nil {
+		return err
+	}
+	r.mem.mutex.Lock()
+	r.mem.deleteKeys(keys)
+	r.mem.mutex.Unlock()
+	return nil
+}
+
+// apply stores metrics as updated at given time (without recording samples, as history is not kept)
+func (r *walRepo) apply(metrics []domain.Metric, updated time.Time) {
+	r.mem.mutex.Lock()
+	defer r.mem.mutex.Unlock()
+
+	for _, metric := range metrics {
+		r.mem.restore(metric, updated)
+	}
+}
+
+// append writes a record (with the next sequence number) to the log and syncs it to disk, mutex must be held by caller
+// If the record cannot be written, the log is truncated back, so that replay does not stop at the partial record
+func (r *walRepo) append(record walRecord) error {
+	if r.closed {
+		return ErrWALClosed
+	}
+	if r.failed {
+		return ErrWALFailed
+	}
+
+	record.Seq = r.seq + 1
+	line, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "[wal repo] error when encoding record")
+	}
+	line = append(line, '\n')
+
+	offset, err := r.log.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrap(err, "[wal repo] error when seeking end of log")
+	}
+	if _, err = r.log.Write(line); err != nil {
+		return r.rollback(offset, errors.Wrap(err, "[wal repo] error when writing record"))
+	}
+	if err = r.log.Sync(); err != nil {
+		return r.rollback(offset, errors.Wrap(err, "[wal repo] error when syncing log"))
+	}
+	r.seq++
+	return nil
+}
+
+// rollback truncates the log back to offset after a failed append, the repo is failed if it cannot be done
+func (r *walRepo) rollback(offset int64, err error) error {
+	if truncateErr := r.log.Truncate(offset); truncateErr != nil {
+		r.failed = true
+		return errors.Wrapf(err, "log is left with partial record (%s)", truncateErr.Error())
+	}
+	return err
+}
+
+// Checkpoint writes a snapshot of all metrics and truncates the log
+func (r *walRepo) Checkpoint(ctx context.Context) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return ErrWALClosed
+	}
+	return r.checkpoint(ctx)
+}
+
+// Close takes the final checkpoint and closes the log, it should be called after the last Store
+func (r *walRepo) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	err := r.checkpoint(context.Background())
+	if closeErr := r.log.Close(); closeErr != nil && err == nil {
+		err = errors.Wrap(closeErr, "[wal repo] error when closing log")
+	}
+	return err
+}
+
+func (r *walRepo) checkpoint(ctx context.Context) error {
+	r.mem.mutex.RLock()
+	snapshot := walSnapshot{
+		Seq:       r.seq,
+		Timestamp: time.Now(),
+		Metrics:   make([]domain.Metric, 0, len(r.mem.metrics)),
+		Updated:   make(map[string]time.Time, len(r.mem.updated)),
+	}
+	for key, metric := range r.mem.metrics {
+		snapshot.Metrics = append(snapshot.Metrics, metric)
+		snapshot.Updated[key] = r.mem.updated[key]
+	}
+	r.mem.mutex.RUnlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.Wrap(err, "[wal repo] error when encoding snapshot")
+	}
+
+	// Write snapshot to temp file and rename, so that a crash never leaves a half-written snapshot
+	tmp := filepath.Join(r.dir, walSnapshotFile+".tmp")
+	if err = writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(r.dir, walSnapshotFile)); err != nil {
+		return errors.Wrap(err, "[wal repo] error when moving snapshot into place")
+	}
+
+	// All records are in the snapshot now, if the log is not truncated (e.g. crash right here),
+	// they are skipped on replay by their sequence numbers
+	if err = r.log.Truncate(0); err != nil {
+		return errors.Wrap(err, "[wal repo] error when truncating log")
+	}
+	if err = r.log.Sync(); err != nil {
+		return errors.Wrap(err, "[wal repo] error when syncing log")
+	}
+	logger.New(ctx).Debugf("[wal repo] checkpoint at seq %d, metrics count = %d", r.seq, len(snapshot.Metrics))
+	return nil
+}
+
+func (r *walRepo) startCheckpointing(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			err := r.Checkpoint(ctx)
+			if errors.Is(err, ErrWALClosed) {
+				return
+			}
+			if err != nil {
+				logger.New(ctx).Errorf("[wal repo] checkpoint failed, error: %s", err.Error())
+			}
+		case <-ctx.Done():
+			logger.New(ctx).Debugf("[wal repo] context cancelled, stopped checkpointing")
+			return
+		}
+	}
+}
+
+// replay loads the last snapshot and applies log records after it, series keep their update times
+// Snapshots and records written before update times were logged are restored as updated at startup
+func (r *walRepo) replay(ctx context.Context) error {
+	startup := time.Now()
+
+	data, err := os.ReadFile(filepath.Join(r.dir, walSnapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "[wal repo] error when reading snapshot")
+	}
+	if err == nil {
+		var snapshot walSnapshot
+		if err = json.Unmarshal(data, &snapshot); err != nil {
+			return errors.Wrap(err, "[wal repo] error when decoding snapshot")
+		}
+		for _, metric := range snapshot.Metrics {
+			updated, ok := snapshot.Updated[metric.Key()]
+			if !ok {
+				updated = startup
+			}
+			r.mem.restore(metric, updated)
+		}
+		r.seq = snapshot.Seq
+	}
+
+	path := filepath.Join(r.dir, walLogFile)
+	data, err = os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "[wal repo] error when reading log")
+	}
+
+	replayed := 0
+	var valid int64
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if errors.Is(readErr, io.EOF) {
+			// Either end of log or a torn record, which was never acknowledged
+			break
+		}
+		var record walRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			// Nothing after a corrupt record can be trusted to be in order
+			logger.New(ctx).Errorf("[wal repo] corrupt record after seq %d, dropping the rest of log", r.seq)
+			break
+		}
+		valid += int64(len(line))
+		if record.Seq <= r.seq {
+			// Record is already in the snapshot
+			continue
+		}
+		if record.Timestamp.IsZero() {
+			record.Timestamp = startup
+		}
+		for _, metric := range record.Metrics {
+			r.mem.restore(metric, record.Timestamp)
+		}
+		r.mem.deleteKeys(record.Deleted)
+		r.seq = record.Seq
+		replayed++
+	}
+
+	if valid < int64(len(data)) {
+		if err = os.Truncate(path, valid); err != nil {
+			return errors.Wrap(err, "[wal repo] error when truncating log")
+		}
+	}
+	logger.New(ctx).Infof("[wal repo] replayed %d records, last seq %d", replayed, r.seq)
+	return nil
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "[wal repo] error when creating snapshot")
+	}
+	defer file.Close()
+
+	if _, err = file.Write(data); err != nil {
+		return errors.Wrap(err, "[wal repo] error when writing snapshot")
+	}
+	if err = file.Sync(); err != nil {
+		return errors.Wrap(err, "[wal repo] error when syncing snapshot")
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+func openTestWAL(t *testing.T, dir string) *walRepo {
+	repo, err := NewWALRepo(context.Background(), config.WALConfig{Dir: dir})
+	require.NoError(t, err)
+	return repo
+}
+
+func TestWALReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo := openTestWAL(t, dir)
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5), domain.NewGauge(domain.Alloc, 1)))
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 10)))
+	// Simulate crash: log is not checkpointed, nor closed
+	require.NoError(t, repo.log.Close())
+
+	repo = openTestWAL(t, dir)
+	list, err := repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 10),
+		domain.NewGauge(domain.Alloc, 1),
+	}, list)
+	assert.Equal(t, uint64(2), repo.seq)
+}
+
+func TestWALIncrementReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo := openTestWAL(t, dir)
+	for i := 0; i < 3; i++ {
+		_, err := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 5))
+		require.NoError(t, err)
+	}
+	// Simulate crash: log is not checkpointed, nor closed
+	require.NoError(t, repo.log.Close())
+
+	// Log holds accumulated values, so replay does not sum them up again
+	repo = openTestWAL(t, dir)
+	metric, found, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(15), metric.Counter)
+
+	updated, err := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{domain.NewCounter(domain.PollCount, 16)}, updated)
+}
+
+func TestWALDeleteReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo := openTestWAL(t, dir)
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5), domain.NewGauge(domain.Alloc, 1)))
+	deleted, err := repo.Delete(ctx, domain.PollCount)
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	// Simulate crash: log is not checkpointed, nor closed
+	require.NoError(t, repo.log.Close())
+
+	repo = openTestWAL(t, dir)
+	list, err := repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{domain.NewGauge(domain.Alloc, 1)}, list)
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo := openTestWAL(t, dir)
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5)))
+	require.NoError(t, repo.Checkpoint(ctx))
+
+	// Log is compacted
+	stat, err := os.Stat(filepath.Join(dir, walLogFile))
+	require.NoError(t, err)
+	assert.Zero(t, stat.Size())
+
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 2)))
+	require.NoError(t, repo.Close())
+	assert.ErrorIs(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 3)), ErrWALClosed)
+
+	repo = openTestWAL(t, dir)
+	list, err := repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 5),
+		domain.NewGauge(domain.Alloc, 2),
+	}, list)
+}
+
+func TestWALSkipsRecordsInSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo := openTestWAL(t, dir)
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5)))
+	log, err := os.ReadFile(filepath.Join(dir, walLogFile))
+	require.NoError(t, err)
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 7)))
+	require.NoError(t, repo.Close())
+
+	// Simulate crash after snapshot is written, but before log is truncated
+	require.NoError(t, os.WriteFile(filepath.Join(dir, walLogFile), log, 0o644))
+
+	repo = openTestWAL(t, dir)
+	metric, found, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(7), metric.Counter)
+}
+
+func TestWALTornRecord(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo := openTestWAL(t, dir)
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5)))
+	require.NoError(t, repo.log.Close())
+
+	// Simulate crash in the middle of writing the next record
+	path := filepath.Join(dir, walLogFile)
+	log, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = log.WriteString(`{"seq":2,"metrics":[{"Name":"Poll`)
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+
+	repo = openTestWAL(t, dir)
+	metric, found, err := repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(5), metric.Counter)
+
+	// Torn record is dropped, so that new records are appended after the last valid one
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 6)))
+	require.NoError(t, repo.log.Close())
+	repo = openTestWAL(t, dir)
+	metric, _, err = repo.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	assert.Equal(t, domain.Counter(6), metric.Counter)
+}
+
+var errTestDiskFull = errors.New("no space left on device")
+
+// failingFile writes only a part of the data and fails, like a full disk, while fail is set
+// If truncate fails as well, the log cannot be restored
+type failingFile struct {
+	*os.File
+	fail         bool
+	failTruncate bool
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if f.fail {
+		n, _ := f.File.Write(p[:len(p)/2])
+		return n, errTestDiskFull
+	}
+	return f.File.Write(p)
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.failTruncate {
+		return errTestDiskFull
+	}
+	return f.File.Truncate(size)
+}
+
+func TestWALFailedWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo := openTestWAL(t, dir)
+	log := &failingFile{File: repo.log.(*os.File)}
+	repo.log = log
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5)))
+
+	log.fail = true
+	assert.ErrorIs(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 1)), errTestDiskFull)
+
+	// Partial record is removed, so that records written after it are replayed
+	log.fail = false
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.HeapSys, 2)))
+	require.NoError(t, log.Close())
+
+	repo = openTestWAL(t, dir)
+	list, err := repo.List(ctx, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 5),
+		domain.NewGauge(domain.HeapSys, 2),
+	}, list)
+
+	// Writes are refused if the partial record cannot be removed, as they would be lost on replay
+	log = &failingFile{File: repo.log.(*os.File), fail: true, failTruncate: true}
+	repo.log = log
+	assert.ErrorIs(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 1)), errTestDiskFull)
+	log.fail = false
+	assert.ErrorIs(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 1)), ErrWALFailed)
+}
+
+func TestWALKeepsUpdateTimes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo := openTestWAL(t, dir)
+	require.NoError(t, repo.Store(ctx, domain.NewCounter(domain.PollCount, 5)))
+	require.NoError(t, repo.Checkpoint(ctx))
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 1)))
+	stored := time.Now()
+	// Simulate crash: the second store is only in the log
+	require.NoError(t, repo.log.Close())
+
+	// Restart does not make series look recently updated, so they still expire
+	repo = openTestWAL(t, dir)
+	expired, err := repo.Expire(ctx, stored.Add(time.Nanosecond))
+	require.NoError(t, err)
+	assert.Equal(t, 2, expired)
+
+	// History is not logged, so it is not offered at all
+	_, ok := any(repo).(interface {
+		History(ctx context.Context, name string, labels domain.Labels, from, to time.Time) ([]domain.Sample, error)
+	})
+	assert.False(t, ok)
+}
@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/retry"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+var (
+	ErrJournalFull    = errors.New("[write-behind repo] database is unavailable and journal is full")
+	ErrJournalPending = errors.New("[write-behind repo] database is unavailable, deletes wait for journal replay")
+)
+
+// writeBehindRepo keeps accepting updates while backing repository (database) is unavailable
+// Updates are kept in a bounded in-memory journal and replayed in order once the database is back,
+// increments are replayed as deltas, so counter sums stay correct
+// Until the journal is drained, all updates go through it, so that they are never applied out of order
+type writeBehindRepo struct {
+	BackingRepository
+	capacity int
+	backoff  *retry.Policy
+	// journal holds updates that are not written yet, size is the amount of metrics in it
+	journal  []journalEntry
+	size     int
+	degraded bool
+	mutex    *sync.Mutex
+	// replayMutex makes sure journal is not replayed concurrently (e.g. on Close), which would duplicate updates
+	replayMutex *sync.Mutex
+	// wake signals replaying goroutine that the database went away
+	wake chan struct{}
+}
+
+type journalEntry struct {
+	increment bool
+	metrics   []domain.Metric
+}
+
+func NewWriteBehindRepo(ctx context.Context, repo BackingRepository, cfg config.DatabaseConfig) *writeBehindRepo {
+	r := &writeBehindRepo{
+		BackingRepository: repo,
+		capacity:          cfg.JournalCapacity,
+		backoff: retry.NewPolicy(config.RetryConfig{
+			BaseDelay: cfg.ReconnectBaseDelay,
+			MaxDelay:  cfg.ReconnectMaxDelay,
+			Jitter:    0.2,
+		}),
+		mutex:       &sync.Mutex{},
+		replayMutex: &sync.Mutex{},
+		wake:        make(chan struct{}, 1),
+	}
+	go r.startReplaying(ctx)
+	return r
+}
+
+func (r *writeBehindRepo) Store(ctx context.Context, metrics ...domain.Metric) error {
+	if r.isDegraded() {
+		return r.enqueue(ctx, false, metrics)
+	}
+	err := r.BackingRepository.Store(ctx, metrics...)
+	if err != nil && r.isUnavailable(ctx) {
+		return r.enqueue(ctx, false, metrics)
+	}
+	return err
+}
+
+// Increment returns domain.ErrUpdateJournaled if metrics were journaled,
+// resulting values are only known after the journal is replayed
+func (r *writeBehindRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	if r.isDegraded() {
+		return nil, r.enqueueIncrement(ctx, metrics)
+	}
+	updated, err := r.BackingRepository.Increment(ctx, metrics...)
+	if err != nil && r.isUnavailable(ctx) {
+		return nil, r.enqueueIncrement(ctx, metrics)
+	}
+	return updated, err
+}
+
+// Delete is not journaled, as journaled updates would be replayed on top of it, it fails while journal is not empty
+func (r *writeBehindRepo) Delete(ctx context.Context, names ...string) (int, error) {
+	if r.isDegraded() {
+		return 0, ErrJournalPending
+	}
+	return r.BackingRepository.Delete(ctx, names...)
+}
+
+// Expire is skipped while journal is not empty, journaled updates would make expired metrics fresh again
+func (r *writeBehindRepo) Expire(ctx context.Context, before time.Time) (int, error) {
+	if r.isDegraded() {
+		return 0, ErrJournalPending
+	}
+	return r.BackingRepository.Expire(ctx, before)
+}
+
+// Ping reports if updates can be accepted, i.e. either database is available or journal has space left
+func (r *writeBehindRepo) Ping(ctx context.Context) bool {
+	r.mutex.Lock()
+	degraded, full := r.degraded, r.size >= r.capacity
+	r.mutex.Unlock()
+
+	if degraded {
+		return !full
+	}
+	return r.BackingRepository.Ping(ctx)
+}
+
+// Degraded reports if database is unavailable and updates are journaled
+func (r *writeBehindRepo) Degraded(ctx context.Context) (bool, string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.degraded {
+		return false, ""
+	}
+	return true, fmt.Sprintf("database is unavailable, %d/%d metrics journaled", r.size, r.capacity)
+}
+
+// Close tries to write journaled updates one last time, they are lost if database is still unavailable
+func (r *writeBehindRepo) Close() error {
+	ctx := context.Background()
+	if !r.isDegraded() {
+		return nil
+	}
+	if err := r.replay(ctx); err != nil {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		return errors.Wrapf(err, "[write-behind repo] %d journaled metrics are lost", r.size)
+	}
+	return nil
+}
+
+func (r *writeBehindRepo) isDegraded() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.degraded
+}
+
+// isUnavailable checks if write error is caused by unavailable database, rather than by the update itself
+func (r *writeBehindRepo) isUnavailable(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		// Request is cancelled, database may be fine
+		return false
+	}
+	return !r.BackingRepository.Ping(ctx)
+}
+
+func (r *writeBehindRepo) enqueueIncrement(ctx context.Context, metrics []domain.Metric) error {
+	if err := r.enqueue(ctx, true, metrics); err != nil {
+		return err
+	}
+	return domain.ErrUpdateJournaled
+}
+
+func (r *writeBehindRepo) enqueue(ctx context.Context, increment bool, metrics []domain.Metric) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.size+len(metrics) > r.capacity {
+		return ErrJournalFull
+	}
+	r.journal = append(r.journal, journalEntry{
+		increment: increment,
+		metrics:   append([]domain.Metric(nil), metrics...),
+	})
+	r.size += len(metrics)
+
+	if !r.degraded {
+		r.degraded = true
+		logger.New(ctx).Errorf("[write-behind repo] database is unavailable, journaling updates")
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (r *writeBehindRepo) startReplaying(ctx context.Context) {
+	for {
+		select {
+		case <-r.wake:
+		case <-ctx.Done():
+			logger.New(ctx).Debugf("[write-behind repo] context cancelled, stopped replaying")
+			return
+		}
+
+		for attempt := 1; ; attempt++ {
+			timer := time.NewTimer(r.backoff.Delay(attempt))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				logger.New(ctx).Debugf("[write-behind repo] context cancelled, stopped replaying")
+				return
+			}
+			if !r.BackingRepository.Ping(ctx) {
+				continue
+			}
+			if err := r.replay(ctx); err != nil {
+				logger.New(ctx).Errorf("[write-behind repo] replay failed, error: %s", err.Error())
+				continue
+			}
+			break
+		}
+	}
+}
+
+// replay writes journaled updates in order, until journal is empty
+// Updates coming in meanwhile are appended to the journal, so they are written after the older ones
+// Replay stops if database is unavailable again, updates rejected by available database are dropped
+func (r *writeBehindRepo) replay(ctx context.Context) error {
+	r.replayMutex.Lock()
+	defer r.replayMutex.Unlock()
+
+	replayed := 0
+	for {
+		r.mutex.Lock()
+		if len(r.journal) == 0 {
+			r.degraded = false
+			r.mutex.Unlock()
+			logger.New(ctx).Infof("[write-behind repo] database is back, replayed %d metrics", replayed)
+			return nil
+		}
+		entry := r.journal[0]
+		r.mutex.Unlock()
+
+		var err error
+		if entry.increment {
+			_, err = r.BackingRepository.Increment(ctx, entry.metrics...)
+		} else {
+			err = r.BackingRepository.Store(ctx, entry.metrics...)
+		}
+		switch {
+		case err == nil:
+		case entry.increment && errors.Is(err, ErrWriteUnconfirmed):
+			// Increment might have been applied, replaying it again could count its deltas twice
+			logger.New(ctx).Errorf("[write-behind repo] replayed increment of %d metrics was not confirmed, "+
+				"treating it as applied, error: %s", len(entry.metrics), err.Error())
+		case r.BackingRepository.Ping(ctx):
+			// Database is available, so the update itself is rejected (e.g. invalid name), it would never be written
+			logger.New(ctx).Errorf("[write-behind repo] dropped %d journaled metrics rejected by database, error: %s",
+				len(entry.metrics), err.Error())
+		default:
+			return err
+		}
+
+		r.mutex.Lock()
+		r.journal[0] = journalEntry{}
+		r.journal = r.journal[1:]
+		r.size -= len(entry.metrics)
+		r.mutex.Unlock()
+		replayed += len(entry.metrics)
+	}
+}
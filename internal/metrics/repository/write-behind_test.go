@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+var errTestUnavailable = errors.New("connection refused")
+
+// flakyRepo is in-mem repo, which can be made unavailable like a database
+type flakyRepo struct {
+	*inMemRepo
+	down int32
+}
+
+func (r *flakyRepo) setDown(down bool) {
+	if down {
+		atomic.StoreInt32(&r.down, 1)
+	} else {
+		atomic.StoreInt32(&r.down, 0)
+	}
+}
+
+func (r *flakyRepo) isDown() bool {
+	return atomic.LoadInt32(&r.down) == 1
+}
+
+func (r *flakyRepo) Ping(ctx context.Context) bool {
+	return !r.isDown()
+}
+
+func (r *flakyRepo) Store(ctx context.Context, metrics ...domain.Metric) error {
+	if r.isDown() {
+		return errTestUnavailable
+	}
+	return r.inMemRepo.Store(ctx, metrics...)
+}
+
+func (r *flakyRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	if r.isDown() {
+		return nil, errTestUnavailable
+	}
+	return r.inMemRepo.Increment(ctx, metrics...)
+}
+
+// rejectingRepo is flaky repo, which rejects metrics with rejectedName while it is available
+type rejectingRepo struct {
+	*flakyRepo
+}
+
+const rejectedName = "Rejected"
+
+var errTestRejected = errors.New("value too long for type character varying(64)")
+
+func (r rejectingRepo) Store(ctx context.Context, metrics ...domain.Metric) error {
+	for _, metric := range metrics {
+		if metric.Name == rejectedName && !r.isDown() {
+			return errTestRejected
+		}
+	}
+	return r.flakyRepo.Store(ctx, metrics...)
+}
+
+func openTestWriteBehind(t *testing.T, capacity int) (*writeBehindRepo, *flakyRepo) {
+	backing := &flakyRepo{inMemRepo: NewInMemRepo()}
+	return openTestWriteBehindWith(t, backing, capacity), backing
+}
+
+func openTestWriteBehindWith(t *testing.T, backing BackingRepository, capacity int) *writeBehindRepo {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return NewWriteBehindRepo(ctx, backing, config.DatabaseConfig{
+		JournalCapacity:    capacity,
+		ReconnectBaseDelay: time.Millisecond,
+		ReconnectMaxDelay:  10 * time.Millisecond,
+	})
+}
+
+func TestWriteBehindHealthy(t *testing.T) {
+	ctx := context.Background()
+	repo, backing := openTestWriteBehind(t, 10)
+
+	updated, err := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 5))
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Metric{domain.NewCounter(domain.PollCount, 5)}, updated)
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 1)))
+
+	list, err := backing.List(ctx, nil)
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	degraded, _ := repo.Degraded(ctx)
+	assert.False(t, degraded)
+	assert.True(t, repo.Ping(ctx))
+}
+
+func TestWriteBehindReplay(t *testing.T) {
+	ctx := context.Background()
+	repo, backing := openTestWriteBehind(t, 10)
+
+	_, err := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 5))
+	require.NoError(t, err)
+
+	backing.setDown(true)
+	updated, err := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 1))
+	// Resulting value is not known until replay
+	assert.ErrorIs(t, err, domain.ErrUpdateJournaled)
+	assert.Nil(t, updated)
+
+	_, err = repo.Increment(ctx, domain.NewCounter(domain.PollCount, 2), domain.NewGauge(domain.Alloc, 1))
+	assert.ErrorIs(t, err, domain.ErrUpdateJournaled)
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 2)))
+
+	degraded, reason := repo.Degraded(ctx)
+	assert.True(t, degraded)
+	assert.Equal(t, "database is unavailable, 4/10 metrics journaled", reason)
+	assert.True(t, repo.Ping(ctx))
+
+	// Deletes are not journaled, journaled updates would be replayed on top of them
+	_, err = repo.Delete(ctx, domain.Alloc)
+	assert.ErrorIs(t, err, ErrJournalPending)
+
+	backing.setDown(false)
+	assert.Eventually(t, func() bool {
+		degraded, _ = repo.Degraded(ctx)
+		return !degraded
+	}, time.Second, 5*time.Millisecond)
+
+	// Increments are replayed as deltas, updates are applied in order
+	metric, found, err := backing.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(8), metric.Counter)
+
+	metric, found, err = backing.Get(ctx, domain.Alloc, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Gauge(2), metric.Gauge)
+}
+
+func TestWriteBehindDropsRejectedUpdates(t *testing.T) {
+	ctx := context.Background()
+	backing := rejectingRepo{&flakyRepo{inMemRepo: NewInMemRepo()}}
+	repo := openTestWriteBehindWith(t, backing, 10)
+
+	backing.setDown(true)
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(rejectedName, 1)))
+	_, err := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 5))
+	assert.ErrorIs(t, err, domain.ErrUpdateJournaled)
+
+	// Rejected update would never be written, it is dropped so that the journal is drained
+	backing.setDown(false)
+	assert.Eventually(t, func() bool {
+		degraded, _ := repo.Degraded(ctx)
+		return !degraded
+	}, time.Second, 5*time.Millisecond)
+
+	_, found, err := backing.Get(ctx, rejectedName, nil)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	metric, found, err := backing.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(5), metric.Counter)
+
+	_, err = repo.Delete(ctx, domain.PollCount)
+	assert.NoError(t, err)
+}
+
+func TestWriteBehindUnconfirmedIncrementIsNotReplayedTwice(t *testing.T) {
+	ctx := context.Background()
+	backing := unconfirmedRepo{&flakyRepo{inMemRepo: NewInMemRepo()}}
+	repo := openTestWriteBehindWith(t, backing, 10)
+
+	backing.setDown(true)
+	_, err := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 5))
+	assert.ErrorIs(t, err, domain.ErrUpdateJournaled)
+
+	backing.setDown(false)
+	assert.Eventually(t, func() bool {
+		degraded, _ := repo.Degraded(ctx)
+		return !degraded
+	}, time.Second, 5*time.Millisecond)
+
+	metric, found, err := backing.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(5), metric.Counter)
+}
+
+func TestWriteBehindJournalFull(t *testing.T) {
+	ctx := context.Background()
+	repo, backing := openTestWriteBehind(t, 2)
+
+	backing.setDown(true)
+	_, err := repo.Increment(ctx, domain.NewCounter(domain.PollCount, 1), domain.NewGauge(domain.Alloc, 1))
+	assert.ErrorIs(t, err, domain.ErrUpdateJournaled)
+	assert.False(t, repo.Ping(ctx))
+
+	_, err = repo.Increment(ctx, domain.NewCounter(domain.PollCount, 1))
+	assert.ErrorIs(t, err, ErrJournalFull)
+	assert.ErrorIs(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 1)), ErrJournalFull)
+}
+
+func TestWriteBehindClose(t *testing.T) {
+	ctx := context.Background()
+	repo, backing := openTestWriteBehind(t, 10)
+
+	backing.setDown(true)
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 1)))
+	assert.Error(t, repo.Close())
+
+	backing.setDown(false)
+	require.NoError(t, repo.Close())
+	_, found, err := backing.Get(ctx, domain.Alloc, nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+func (s *metricsService) startCompacting(ctx context.Context, interval time.Duration) {
+	defer s.workers.Done()
+
+	compactCycles := 0
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			compactCycles++
+			logger.New(ctx).Debugf("[metrics service] compaction cycle %d begins", compactCycles)
+
+			if err := s.compact(ctx, time.Now()); err != nil {
+				logger.New(ctx).Errorf("[metrics service] compaction cycle %d failed, error: %s",
+					compactCycles, err.Error())
+				continue
+			}
+			logger.New(ctx).Debugf("[metrics service] compaction cycle %d successful", compactCycles)
+
+		case <-ctx.Done():
+			logger.New(ctx).Debugf("[metrics service] context cancelled, stopped compacting")
+			return
+		}
+	}
+}
+
+// compact rolls history of all metrics up into minute and hour aggregates, then drops history past retention
+func (s *metricsService) compact(ctx context.Context, now time.Time) error {
+	repo, ok := s.repo.(MetricsRollupRepository)
+	if !ok {
+		return domain.ErrHistoryNotSupported
+	}
+	metrics, err := s.repo.List(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Minute rollups go first, as hour rollups are made of them
+	if err = rollupMetrics(ctx, repo, metrics, domain.ResolutionMinute, now); err != nil {
+		return errors.Wrap(err, "failed to roll up to minutes")
+	}
+	if err = rollupMetrics(ctx, repo, metrics, domain.ResolutionHour, now); err != nil {
+		return errors.Wrap(err, "failed to roll up to hours")
+	}
+	return s.expire(ctx, repo, metrics, now)
+}
+
+// rollupMetrics aggregates all complete buckets of given resolution, which have not been rolled up yet
+// Minute rollups are made of raw samples, hour rollups are made of minute rollups
+// All metrics are rolled up at once, so that it takes the same number of round trips for any number of series
+func rollupMetrics(
+	ctx context.Context,
+	repo MetricsRollupRepository,
+	metrics []domain.Metric,
+	resolution time.Duration,
+	now time.Time,
+) error {
+	// Buckets before 'until' are complete, no more samples will be added to them
+	until := now.Truncate(resolution)
+	to := until.Add(-time.Nanosecond)
+
+	lastRollups, err := repo.LastRollups(ctx, resolution)
+	if err != nil {
+		return err
+	}
+	pending := make([]domain.Metric, 0, len(metrics))
+	ranges := make([]domain.SeriesRange, 0, len(metrics))
+	for _, metric := range metrics {
+		from := time.Unix(0, 0)
+		if last, found := lastRollups[metric.Key()]; found {
+			from = last.Timestamp.Add(resolution)
+		}
+		if !from.Before(until) {
+			continue
+		}
+		pending = append(pending, metric)
+		ranges = append(ranges, domain.SeriesRange{Name: metric.Name, Labels: metric.Labels, From: from})
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var samples map[string][]domain.Sample
+	var minutes map[string][]domain.Rollup
+	if resolution == domain.ResolutionMinute {
+		samples, err = repo.SeriesHistory(ctx, ranges, to)
+	} else {
+		minutes, err = repo.SeriesRollups(ctx, ranges, domain.ResolutionMinute, to)
+	}
+	if err != nil {
+		return err
+	}
+
+	result := make([]domain.SeriesRollup, 0)
+	for _, metric := range pending {
+		var rollups []domain.Rollup
+		if resolution == domain.ResolutionMinute {
+			var baseline *float64
+			if last, found := lastRollups[metric.Key()]; found {
+				baseline = &last.Last
+			}
+			rollups = rollupSamples(samples[metric.Key()], resolution, metric.IsCumulative(), baseline)
+		} else {
+			rollups = mergeRollups(minutes[metric.Key()], resolution)
+		}
+		for _, rollup := range rollups {
+			result = append(result, domain.SeriesRollup{Name: metric.Name, Labels: metric.Labels, Rollup: rollup})
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return repo.StoreRollups(ctx, resolution, result...)
+}
+
+// expire drops samples and rollups, which are older than retention of the first matching rule
+// Metrics are grouped by rule, so that there is one (batched) delete per rule and resolution
+func (s *metricsService) expire(
+	ctx context.Context,
+	repo MetricsRollupRepository,
+	metrics []domain.Metric,
+	now time.Time,
+) error {
+	rules := make([]config.RetentionRule, 0)
+	names := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, metric := range metrics {
+		if seen[metric.Name] {
+			// Other series of the same metric
+			continue
+		}
+		seen[metric.Name] = true
+
+		rule := s.retention.Rules.Match(metric.Name)
+		if _, ok := names[rule.Pattern]; !ok {
+			rules = append(rules, rule)
+		}
+		names[rule.Pattern] = append(names[rule.Pattern], metric.Name)
+	}
+
+	for _, rule := range rules {
+		if rule.Raw > 0 {
+			if err := repo.DeleteSamples(ctx, names[rule.Pattern], now.Add(-rule.Raw)); err != nil {
+				return errors.Wrapf(err, "failed to drop samples matching '%s'", rule.Pattern)
+			}
+		}
+		if rule.Minute > 0 {
+			err := repo.DeleteRollups(ctx, names[rule.Pattern], domain.ResolutionMinute, now.Add(-rule.Minute))
+			if err != nil {
+				return errors.Wrapf(err, "failed to drop minute rollups matching '%s'", rule.Pattern)
+			}
+		}
+		if rule.Hour > 0 {
+			err := repo.DeleteRollups(ctx, names[rule.Pattern], domain.ResolutionHour, now.Add(-rule.Hour))
+			if err != nil {
+				return errors.Wrapf(err, "failed to drop hour rollups matching '%s'", rule.Pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// rollupSamples aggregates samples (sorted by time) into resolution-long buckets
+// For counters (and histograms), samples hold accumulated values, so deltas are taken between consecutive samples,
+// starting from baseline (last value of the previous rollup), or from zero if there is none.
+// A decrease of counter value means it was reset, then the whole value counts as a delta
+func rollupSamples(
+	samples []domain.Sample,
+	resolution time.Duration,
+	isCumulative bool,
+	baseline *float64,
+) []domain.Rollup {
+	result := make([]domain.Rollup, 0)
+
+	var previous float64
+	if baseline != nil {
+		previous = *baseline
+	}
+	for _, sample := range samples {
+		bucket := sample.Timestamp.Truncate(resolution)
+		if len(result) == 0 || !result[len(result)-1].Timestamp.Equal(bucket) {
+			result = append(result, domain.Rollup{
+				Timestamp: bucket,
+				Min:       sample.Value,
+				Max:       sample.Value,
+			})
+		}
+		rollup := &result[len(result)-1]
+		if sample.Value < rollup.Min {
+			rollup.Min = sample.Value
+		}
+		if sample.Value > rollup.Max {
+			rollup.Max = sample.Value
+		}
+		rollup.Sum += sample.Value
+		rollup.Count++
+		rollup.Last = sample.Value
+
+		if isCumulative {
+			if sample.Value >= previous {
+				rollup.Delta += sample.Value - previous
+			} else {
+				rollup.Delta += sample.Value
+			}
+			previous = sample.Value
+		}
+	}
+	return result
+}
+
+// mergeRollups aggregates rollups (sorted by time) of finer resolution into resolution-long buckets
+func mergeRollups(rollups []domain.Rollup, resolution time.Duration) []domain.Rollup {
+	result := make([]domain.Rollup, 0)
+
+	for _, fine := range rollups {
+		bucket := fine.Timestamp.Truncate(resolution)
+		if len(result) == 0 || !result[len(result)-1].Timestamp.Equal(bucket) {
+			result = append(result, domain.Rollup{
+				Timestamp: bucket,
+				Min:       fine.Min,
+				Max:       fine.Max,
+			})
+		}
+		rollup := &result[len(result)-1]
+		if fine.Min < rollup.Min {
+			rollup.Min = fine.Min
+		}
+		if fine.Max > rollup.Max {
+			rollup.Max = fine.Max
+		}
+		rollup.Sum += fine.Sum
+		rollup.Count += fine.Count
+		rollup.Last = fine.Last
+		rollup.Delta += fine.Delta
+	}
+	return result
+}
+
+// resolutionFor picks the coarsest resolution, which is not coarser than query step,
+// falling back to even coarser resolutions if data of the picked one is already dropped for query range
+func (s *metricsService) resolutionFor(name string, query domain.HistoryQuery, now time.Time) time.Duration {
+	if !s.compacting {
+		return domain.ResolutionRaw
+	}
+	rule := s.retention.Rules.Match(name)
+	levels := []struct {
+		resolution time.Duration
+		retention  time.Duration
+	}{
+		{domain.ResolutionRaw, rule.Raw},
+		{domain.ResolutionMinute, rule.Minute},
+		{domain.ResolutionHour, rule.Hour},
+	}
+
+	picked := 0
+	for i, level := range levels {
+		if level.resolution <= query.Step {
+			picked = i
+		}
+	}
+	for ; picked < len(levels)-1; picked++ {
+		retention := levels[picked].retention
+		if retention <= 0 || !query.From.Before(now.Add(-retention)) {
+			break
+		}
+	}
+	return levels[picked].resolution
+}
+
+// rollupsToSamples turns rollups into samples, average is used for gauges and last value for counters,
+// so that counter samples hold accumulated values, same as raw ones
+func rollupsToSamples(rollups []domain.Rollup, isCumulative bool) []domain.Sample {
+	result := make([]domain.Sample, 0, len(rollups))
+	for _, rollup := range rollups {
+		value := rollup.Avg()
+		if isCumulative {
+			value = rollup.Last
+		}
+		result = append(result, domain.Sample{Timestamp: rollup.Timestamp, Value: value})
+	}
+	return result
+}
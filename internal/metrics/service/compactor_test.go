@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/repository"
+)
+
+func TestRollupSamples(t *testing.T) {
+	start := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration, value float64) domain.Sample {
+		return domain.Sample{Timestamp: start.Add(d), Value: value}
+	}
+	baseline := 5.0
+
+	tests := []struct {
+		name      string
+		samples   []domain.Sample
+		isCounter bool
+		baseline  *float64
+		want      []domain.Rollup
+	}{
+		{
+			name: "gauge",
+			samples: []domain.Sample{
+				at(10*time.Second, 3),
+				at(20*time.Second, 1),
+				at(30*time.Second, 2),
+				at(70*time.Second, 7),
+			},
+			want: []domain.Rollup{
+				{Timestamp: start, Min: 1, Max: 3, Sum: 6, Count: 3, Last: 2},
+				{Timestamp: start.Add(time.Minute), Min: 7, Max: 7, Sum: 7, Count: 1, Last: 7},
+			},
+		},
+		{
+			name: "counter without baseline",
+			samples: []domain.Sample{
+				at(10*time.Second, 2),
+				at(20*time.Second, 4),
+				at(70*time.Second, 10),
+			},
+			isCounter: true,
+			want: []domain.Rollup{
+				{Timestamp: start, Min: 2, Max: 4, Sum: 6, Count: 2, Last: 4, Delta: 4},
+				{Timestamp: start.Add(time.Minute), Min: 10, Max: 10, Sum: 10, Count: 1, Last: 10, Delta: 6},
+			},
+		},
+		{
+			name: "counter with baseline and reset",
+			samples: []domain.Sample{
+				at(10*time.Second, 8),
+				at(20*time.Second, 3),
+			},
+			isCounter: true,
+			baseline:  &baseline,
+			want: []domain.Rollup{
+				{Timestamp: start, Min: 3, Max: 8, Sum: 11, Count: 2, Last: 3, Delta: 6},
+			},
+		},
+		{
+			name:    "empty",
+			samples: []domain.Sample{},
+			want:    []domain.Rollup{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rollupSamples(tt.samples, domain.ResolutionMinute, tt.isCounter, tt.baseline)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMergeRollups(t *testing.T) {
+	start := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	minutes := []domain.Rollup{
+		{Timestamp: start, Min: 1, Max: 3, Sum: 6, Count: 3, Last: 2, Delta: 1},
+		{Timestamp: start.Add(59 * time.Minute), Min: 0, Max: 2, Sum: 2, Count: 2, Last: 2, Delta: 2},
+		{Timestamp: start.Add(60 * time.Minute), Min: 5, Max: 5, Sum: 5, Count: 1, Last: 5, Delta: 3},
+	}
+	want := []domain.Rollup{
+		{Timestamp: start, Min: 0, Max: 3, Sum: 8, Count: 5, Last: 2, Delta: 3},
+		{Timestamp: start.Add(time.Hour), Min: 5, Max: 5, Sum: 5, Count: 1, Last: 5, Delta: 3},
+	}
+	assert.Equal(t, want, mergeRollups(minutes, domain.ResolutionHour))
+}
+
+func TestResolutionFor(t *testing.T) {
+	now := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	var rules config.RetentionRules
+	require.NoError(t, rules.UnmarshalText([]byte("Alloc=raw:1h,1m:24h;*=raw:24h,1m:168h,1h:8760h")))
+
+	tests := []struct {
+		name       string
+		compacting bool
+		metric     string
+		query      domain.HistoryQuery
+		want       time.Duration
+	}{
+		{
+			name:   "compaction disabled",
+			metric: domain.PollCount,
+			query:  domain.HistoryQuery{From: now.Add(-48 * time.Hour), Step: time.Hour},
+			want:   domain.ResolutionRaw,
+		},
+		{
+			name:       "no step",
+			compacting: true,
+			metric:     domain.PollCount,
+			query:      domain.HistoryQuery{From: now.Add(-time.Hour)},
+			want:       domain.ResolutionRaw,
+		},
+		{
+			name:       "minute step",
+			compacting: true,
+			metric:     domain.PollCount,
+			query:      domain.HistoryQuery{From: now.Add(-time.Hour), Step: 5 * time.Minute},
+			want:       domain.ResolutionMinute,
+		},
+		{
+			name:       "hour step",
+			compacting: true,
+			metric:     domain.PollCount,
+			query:      domain.HistoryQuery{From: now.Add(-time.Hour), Step: 2 * time.Hour},
+			want:       domain.ResolutionHour,
+		},
+		{
+			name:       "raw samples dropped",
+			compacting: true,
+			metric:     domain.PollCount,
+			query:      domain.HistoryQuery{From: now.Add(-48 * time.Hour)},
+			want:       domain.ResolutionMinute,
+		},
+		{
+			name:       "raw and minute rollups dropped",
+			compacting: true,
+			metric:     domain.PollCount,
+			query:      domain.HistoryQuery{From: now.Add(-30 * 24 * time.Hour)},
+			want:       domain.ResolutionHour,
+		},
+		{
+			name:       "rule with shorter retention",
+			compacting: true,
+			metric:     domain.Alloc,
+			query:      domain.HistoryQuery{From: now.Add(-2 * time.Hour)},
+			want:       domain.ResolutionMinute,
+		},
+		{
+			name:       "rule keeps hour rollups forever",
+			compacting: true,
+			metric:     domain.Alloc,
+			query:      domain.HistoryQuery{From: now.Add(-48 * time.Hour)},
+			want:       domain.ResolutionHour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &metricsService{
+				retention:  config.RetentionConfig{Rules: rules},
+				compacting: tt.compacting,
+			}
+			assert.Equal(t, tt.want, s.resolutionFor(tt.metric, tt.query, now))
+		})
+	}
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewInMemRepo()
+	var rules config.RetentionRules
+	require.NoError(t, rules.UnmarshalText([]byte("*=raw:1h,1m:2h,1h:720h")))
+
+	s, err := NewMetricsService(ctx, repo, getDummyBackuper(),
+		config.BackupConfig{}, config.RetentionConfig{Rules: rules})
+	require.NoError(t, err)
+	s.compacting = true
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err = s.Update(ctx, domain.NewCounter(domain.PollCount, 2))
+		require.NoError(t, err)
+		_, err = s.Update(ctx, domain.NewGauge(domain.Alloc, domain.Gauge(i)))
+		require.NoError(t, err)
+	}
+
+	from, to := start.Add(-time.Hour), start.Add(time.Hour)
+
+	// Compact as if an hour has passed, all samples are in complete minute buckets now
+	require.NoError(t, s.compact(ctx, start.Add(time.Hour+time.Minute)))
+
+	minutes, err := repo.Rollups(ctx, domain.PollCount, nil, domain.ResolutionMinute, from, to)
+	require.NoError(t, err)
+	var delta float64
+	for _, rollup := range minutes {
+		delta += rollup.Delta
+	}
+	assert.Equal(t, 6.0, delta)
+
+	hours, err := repo.Rollups(ctx, domain.Alloc, nil, domain.ResolutionHour, from, to)
+	require.NoError(t, err)
+	require.Len(t, hours, 1)
+	assert.Equal(t, domain.Rollup{Timestamp: start.Truncate(time.Hour), Min: 0, Max: 2, Sum: 3, Count: 3, Last: 2},
+		hours[0])
+
+	// Raw samples are past retention and dropped, but history is still served from rollups
+	samples, err := repo.History(ctx, domain.Alloc, nil, from, to)
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+	query := domain.HistoryQuery{From: start.Add(-90 * time.Minute), To: time.Now()}
+	samples, err = s.History(ctx, domain.Alloc, nil, query)
+	require.NoError(t, err)
+	assert.NotEmpty(t, samples)
+
+	// Compacting again is a no-op
+	require.NoError(t, s.compact(ctx, start.Add(time.Hour+time.Minute)))
+	again, err := repo.Rollups(ctx, domain.PollCount, nil, domain.ResolutionMinute, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, minutes, again)
+
+	// Minute rollups are dropped after 2 hours, hour rollups are kept
+	require.NoError(t, s.compact(ctx, start.Add(3*time.Hour)))
+	minutes, err = repo.Rollups(ctx, domain.PollCount, nil, domain.ResolutionMinute, from, to)
+	require.NoError(t, err)
+	assert.Empty(t, minutes)
+	hours, err = repo.Rollups(ctx, domain.PollCount, nil, domain.ResolutionHour, from, to)
+	require.NoError(t, err)
+	require.Len(t, hours, 1)
+	assert.Equal(t, 6.0, hours[0].Delta)
+}
+
+// countingRollupRepo counts batched calls made by compaction
+type countingRollupRepo struct {
+	MetricsRepository
+	MetricsRollupRepository
+	calls map[string]int
+}
+
+func (r *countingRollupRepo) LastRollups(ctx context.Context, resolution time.Duration) (map[string]domain.Rollup, error) {
+	r.calls["LastRollups"]++
+	return r.MetricsRollupRepository.LastRollups(ctx, resolution)
+}
+
+func (r *countingRollupRepo) SeriesHistory(
+	ctx context.Context,
+	ranges []domain.SeriesRange,
+	to time.Time,
+) (map[string][]domain.Sample, error) {
+	r.calls["SeriesHistory"]++
+	return r.MetricsRollupRepository.SeriesHistory(ctx, ranges, to)
+}
+
+func (r *countingRollupRepo) StoreRollups(
+	ctx context.Context,
+	resolution time.Duration,
+	rollups ...domain.SeriesRollup,
+) error {
+	r.calls["StoreRollups"]++
+	return r.MetricsRollupRepository.StoreRollups(ctx, resolution, rollups...)
+}
+
+func TestCompactBatchesSeries(t *testing.T) {
+	ctx := context.Background()
+	inMem := repository.NewInMemRepo()
+	repo := &countingRollupRepo{MetricsRepository: inMem, MetricsRollupRepository: inMem, calls: make(map[string]int)}
+	s := &metricsService{repo: repo, compacting: true}
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, repo.Store(ctx, labelled(domain.NewGauge(domain.Alloc, 1), "host", strconv.Itoa(i))))
+	}
+
+	// Minute and hour rollups of all series are made with one read and one write each
+	require.NoError(t, s.compact(ctx, start.Add(time.Hour+time.Minute)))
+	assert.Equal(t, map[string]int{"LastRollups": 2, "SeriesHistory": 1, "StoreRollups": 2}, repo.calls)
+
+	lastRollups, err := repo.LastRollups(ctx, domain.ResolutionHour)
+	require.NoError(t, err)
+	assert.Len(t, lastRollups, 10)
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
 )
@@ -9,10 +10,52 @@ import (
 // These are the interfaces required for the Service to work
 
 // MetricsRepository should store and retrieve metrics using backend storage
+// Series are identified by metric name and labels, filter by names matches series with any labels
+// Increment should store metrics, atomically applying counters and histograms as deltas on top of the stored values
+// (gauges are overwritten, see domain.Accumulate), and return the resulting metrics in the same order,
+// or domain.ErrUpdateJournaled if metrics are accepted, but not written yet
+// Delete should remove all series of given metric names (with their history), Expire should remove series
+// that were not updated since 'before', both return the amount of removed series
 type MetricsRepository interface {
 	Store(ctx context.Context, metrics ...domain.Metric) error
-	Get(ctx context.Context, name string) (m domain.Metric, found bool, err error)
+	Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error)
+	Get(ctx context.Context, name string, labels domain.Labels) (m domain.Metric, found bool, err error)
 	List(ctx context.Context, filter *domain.MetricsFilter) ([]domain.Metric, error)
+	Delete(ctx context.Context, names ...string) (int, error)
+	Expire(ctx context.Context, before time.Time) (int, error)
+}
+
+// MetricsHistoryRepository should retrieve historical samples of metrics, which are recorded on every Store
+type MetricsHistoryRepository interface {
+	History(ctx context.Context, name string, labels domain.Labels, from, to time.Time) ([]domain.Sample, error)
+}
+
+// MetricsRollupRepository should store aggregated history (rollups) and drop history that is past retention
+// Resolution is one of domain.ResolutionMinute / domain.ResolutionHour,
+// deletes are applied to all series of given metric names
+// Series methods work with many series at once (results are keyed by domain.SeriesKey), so that compaction
+// takes a few round trips regardless of the number of series: LastRollups returns the latest rollup of every series,
+// SeriesHistory and SeriesRollups return history of each series within [From, to]
+type MetricsRollupRepository interface {
+	MetricsHistoryRepository
+	Rollups(
+		ctx context.Context,
+		name string,
+		labels domain.Labels,
+		resolution time.Duration,
+		from, to time.Time,
+	) ([]domain.Rollup, error)
+	LastRollups(ctx context.Context, resolution time.Duration) (map[string]domain.Rollup, error)
+	SeriesHistory(ctx context.Context, ranges []domain.SeriesRange, to time.Time) (map[string][]domain.Sample, error)
+	SeriesRollups(
+		ctx context.Context,
+		ranges []domain.SeriesRange,
+		resolution time.Duration,
+		to time.Time,
+	) (map[string][]domain.Rollup, error)
+	StoreRollups(ctx context.Context, resolution time.Duration, rollups ...domain.SeriesRollup) error
+	DeleteSamples(ctx context.Context, names []string, before time.Time) error
+	DeleteRollups(ctx context.Context, names []string, resolution time.Duration, before time.Time) error
 }
 
 // MetricsBackuper should be able to backup and restore metrics using long-term storage
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// metricsCursor is position of the last metric of a page, it holds everything that metrics are sorted by
+type metricsCursor struct {
+	Sort  string  `json:"s"`
+	Name  string  `json:"n"`
+	Key   string  `json:"k"`
+	Type  string  `json:"t"`
+	Value float64 `json:"v"`
+}
+
+func newMetricsCursor(sortOrder string, metric domain.Metric) metricsCursor {
+	return metricsCursor{
+		Sort:  sortOrder,
+		Name:  metric.Name,
+		Key:   metric.Key(),
+		Type:  metric.Type,
+		Value: metric.FloatValue(),
+	}
+}
+
+// Query returns a page of metrics matching the filter, sorted in the requested order
+// Pages are continued by the position of the last returned metric, so that metrics are not skipped or repeated
+// when metrics before the cursor are added or deleted meanwhile
+func (s *metricsService) Query(ctx context.Context, query domain.MetricsQuery) (domain.MetricsPage, error) {
+	page := domain.MetricsPage{Metrics: make([]domain.Metric, 0)}
+
+	if query.Sort == "" {
+		query.Sort = domain.SortByName
+	}
+	compare, err := metricsOrder(query.Sort)
+	if err != nil {
+		return page, err
+	}
+	switch {
+	case query.Limit == 0:
+		query.Limit = DefaultPageLimit
+	case query.Limit < 0 || query.Limit > MaxPageLimit:
+		return page, errors.Wrapf(domain.ErrInvalidQuery, "limit must be between 1 and %d", MaxPageLimit)
+	}
+	if _, err = query.Filter.Matcher(); err != nil {
+		return page, errors.Wrap(domain.ErrInvalidQuery, err.Error())
+	}
+	var after *metricsCursor
+	if query.Cursor != "" {
+		if after, err = decodeMetricsCursor(query.Cursor, query.Sort); err != nil {
+			return page, err
+		}
+	}
+
+	metrics, err := s.repo.List(ctx, &query.Filter)
+	if err != nil {
+		return page, err
+	}
+	cursors := make([]metricsCursor, len(metrics))
+	for i, metric := range metrics {
+		cursors[i] = newMetricsCursor(query.Sort, metric)
+	}
+	sort.Sort(metricsSorter{metrics: metrics, cursors: cursors, compare: compare})
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(cursors), func(i int) bool {
+			return compare(*after, cursors[i]) < 0
+		})
+	}
+	end := start + query.Limit
+	if end >= len(metrics) {
+		end = len(metrics)
+	} else {
+		page.NextCursor = encodeMetricsCursor(cursors[end-1])
+	}
+	page.Metrics = append(page.Metrics, metrics[start:end]...)
+	return page, nil
+}
+
+// metricsOrder returns comparison func of sort order, ties are broken by series key
+func metricsOrder(sortOrder string) (func(a, b metricsCursor) int, error) {
+	field := strings.TrimPrefix(sortOrder, "-")
+	descending := field != sortOrder
+
+	var compareField func(a, b metricsCursor) int
+	switch field {
+	case domain.SortByName:
+		compareField = func(a, b metricsCursor) int {
+			return strings.Compare(a.Name, b.Name)
+		}
+	case domain.SortByType:
+		compareField = func(a, b metricsCursor) int {
+			return strings.Compare(a.Type, b.Type)
+		}
+	case domain.SortByValue:
+		compareField = func(a, b metricsCursor) int {
+			switch {
+			case a.Value < b.Value:
+				return -1
+			case a.Value > b.Value:
+				return 1
+			}
+			return 0
+		}
+	default:
+		return nil, errors.Wrapf(domain.ErrInvalidQuery, "unknown sort order '%s'", sortOrder)
+	}
+
+	return func(a, b metricsCursor) int {
+		result := compareField(a, b)
+		if result == 0 {
+			result = strings.Compare(a.Key, b.Key)
+		}
+		if descending {
+			return -result
+		}
+		return result
+	}, nil
+}
+
+type metricsSorter struct {
+	metrics []domain.Metric
+	cursors []metricsCursor
+	compare func(a, b metricsCursor) int
+}
+
+func (s metricsSorter) Len() int {
+	return len(s.metrics)
+}
+
+func (s metricsSorter) Less(i, j int) bool {
+	return s.compare(s.cursors[i], s.cursors[j]) < 0
+}
+
+func (s metricsSorter) Swap(i, j int) {
+	s.metrics[i], s.metrics[j] = s.metrics[j], s.metrics[i]
+	s.cursors[i], s.cursors[j] = s.cursors[j], s.cursors[i]
+}
+
+func encodeMetricsCursor(cursor metricsCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeMetricsCursor decodes cursor of the previous page, which must have been listed in the same sort order
+func decodeMetricsCursor(value, sortOrder string) (*metricsCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.Wrap(domain.ErrInvalidQuery, "malformed cursor")
+	}
+	cursor := &metricsCursor{}
+	if err = json.Unmarshal(data, cursor); err != nil {
+		return nil, errors.Wrap(domain.ErrInvalidQuery, "malformed cursor")
+	}
+	if cursor.Sort != sortOrder {
+		return nil, errors.Wrapf(domain.ErrInvalidQuery, "cursor was made for sort order '%s'", cursor.Sort)
+	}
+	return cursor, nil
+}
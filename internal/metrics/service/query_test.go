@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/repository"
+)
+
+func names(metrics []domain.Metric) []string {
+	result := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		result = append(result, metric.Key())
+	}
+	return result
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewInMemRepo()
+	require.NoError(t, repo.Store(ctx,
+		domain.NewCounter(domain.PollCount, 10),
+		labelled(domain.NewCounter(domain.PollCount, 3), "host", "a"),
+		domain.NewGauge(domain.Alloc, 10.333),
+		domain.NewGauge(domain.HeapSys, 3),
+		domain.NewGauge(domain.RandomValue, 0.5),
+	))
+	service, err := NewMetricsService(ctx, repo, getDummyBackuper(), config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		query domain.MetricsQuery
+		pages [][]string
+	}{
+		{
+			name:  "sorted by name by default",
+			query: domain.MetricsQuery{Limit: 2},
+			pages: [][]string{
+				{domain.Alloc, domain.HeapSys},
+				{domain.PollCount, `PollCount{host="a"}`},
+				{domain.RandomValue},
+			},
+		},
+		{
+			name:  "sorted by value descending, ties broken by key",
+			query: domain.MetricsQuery{Sort: "-value", Limit: 3},
+			pages: [][]string{
+				{domain.Alloc, domain.PollCount, `PollCount{host="a"}`},
+				{domain.HeapSys, domain.RandomValue},
+			},
+		},
+		{
+			name:  "filtered",
+			query: domain.MetricsQuery{Filter: domain.MetricsFilter{Types: []string{domain.TypeCounter}}, Sort: "type"},
+			pages: [][]string{
+				{domain.PollCount, `PollCount{host="a"}`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := tt.query
+			for i, want := range tt.pages {
+				page, err := service.Query(ctx, query)
+				require.NoError(t, err)
+				assert.Equal(t, want, names(page.Metrics))
+				if i == len(tt.pages)-1 {
+					assert.Empty(t, page.NextCursor)
+				} else {
+					require.NotEmpty(t, page.NextCursor)
+				}
+				query.Cursor = page.NextCursor
+			}
+		})
+	}
+}
+
+func TestQueryContinuesAfterChanges(t *testing.T) {
+	ctx := context.Background()
+	service, err := NewMetricsService(ctx, getDummyRepo(), getDummyBackuper(),
+		config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	page, err := service.Query(ctx, domain.MetricsQuery{Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{domain.Alloc}, names(page.Metrics))
+
+	// Metric added before the cursor is not listed, the rest of metrics is neither skipped nor repeated,
+	// even if the last listed metric is deleted
+	_, err = service.Update(ctx, domain.NewGauge("AAA", 1))
+	require.NoError(t, err)
+	_, err = service.Delete(ctx, domain.Alloc)
+	require.NoError(t, err)
+	page, err = service.Query(ctx, domain.MetricsQuery{Limit: 1, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Equal(t, []string{domain.PollCount}, names(page.Metrics))
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestQueryInvalid(t *testing.T) {
+	ctx := context.Background()
+	service, err := NewMetricsService(ctx, getDummyRepo(), getDummyBackuper(),
+		config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	page, err := service.Query(ctx, domain.MetricsQuery{Limit: 1})
+	require.NoError(t, err)
+
+	for _, query := range []domain.MetricsQuery{
+		{Sort: "size"},
+		{Limit: MaxPageLimit + 1},
+		{Filter: domain.MetricsFilter{Regex: "("}},
+		{Cursor: "abcd"},
+		{Cursor: page.NextCursor, Sort: "-name"},
+	} {
+		_, err = service.Query(ctx, query)
+		assert.ErrorIs(t, err, domain.ErrInvalidQuery)
+	}
+}
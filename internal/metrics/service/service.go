@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"path"
 	"sync"
 	"time"
 
@@ -13,9 +14,19 @@ import (
 )
 
 type metricsService struct {
-	repo        MetricsRepository
-	backuper    MetricsBackuper
-	updateMutex *sync.Mutex
+	repo      MetricsRepository
+	backuper  MetricsBackuper
+	retention config.RetentionConfig
+	// compacting is set when history is being rolled up, so that rollups can be queried
+	compacting bool
+	// syncBackup is set when backup is taken after every update instead of periodically
+	syncBackup  bool
+	backupMutex *sync.Mutex
+	// workers tracks background jobs (backups, compaction, expiry, heartbeats),
+	// which stop when service context is cancelled
+	workers *sync.WaitGroup
+	// hub streams updates to subscribers, it is nil unless streaming is enabled
+	hub *hub
 }
 
 func NewMetricsService(
@@ -23,11 +34,14 @@ func NewMetricsService(
 	repo MetricsRepository,
 	backuper MetricsBackuper,
 	backupCfg config.BackupConfig,
+	retentionCfg config.RetentionConfig,
 ) (*metricsService, error) {
 	s := &metricsService{
 		repo:        repo,
 		backuper:    backuper,
-		updateMutex: &sync.Mutex{},
+		retention:   retentionCfg,
+		backupMutex: &sync.Mutex{},
+		workers:     &sync.WaitGroup{},
 	}
 	if backuper != nil {
 		if backupCfg.DoRestore {
@@ -37,92 +51,199 @@ func NewMetricsService(
 			}
 		}
 		if backupCfg.Interval > 0 {
+			s.workers.Add(1)
 			go s.startDoingBackups(ctx, backupCfg.Interval)
+		} else {
+			s.syncBackup = true
 		}
 	}
+	if retentionCfg.CompactInterval > 0 {
+		if _, ok := repo.(MetricsRollupRepository); ok {
+			s.compacting = true
+			s.workers.Add(1)
+			go s.startCompacting(ctx, retentionCfg.CompactInterval)
+		} else {
+			logger.New(ctx).Infof("[metrics service] repository does not support rollups, compaction disabled")
+		}
+	}
+	if retentionCfg.MetricTTL > 0 {
+		s.workers.Add(1)
+		go s.startExpiring(ctx, retentionCfg.MetricTTL)
+	}
 	return s, nil
 }
 
+// Update stores metric, counters and histograms are accumulated by the repository atomically,
+// so that several service instances can share it
 func (s *metricsService) Update(ctx context.Context, metric domain.Metric) (domain.Metric, error) {
-	if metric.Type == domain.TypeCounter {
-		// Enforce atomicity for counter updates
-		s.updateMutex.Lock()
-		defer s.updateMutex.Unlock()
-	}
-
-	existingMetric, found, err := s.repo.Get(ctx, metric.Name)
+	updated, err := s.repo.Increment(ctx, metric)
 	if err != nil {
 		return metric, err
 	}
-	if found && metric.IsCounter() {
-		// For counters, old value is added on top of new value
-		metric.Counter += existingMetric.Counter
-	}
-	return metric, s.repo.Store(ctx, metric)
+	s.backupAfterUpdate(ctx)
+	s.hub.publish(updated)
+	return updated[0], nil
 }
 
 func (s *metricsService) UpdateMany(ctx context.Context, metrics []domain.Metric) ([]domain.Metric, error) {
-	// Merge metrics with the same name into one
+	// Merge metrics of the same series (name + labels) into one
 	// For counters, their values will be summed up
+	// For histograms, their observations will be merged
 	// For gauges, the last value will be taken
 	metrics = s.mergeIdenticalMetrics(metrics)
 
-	names := make([]string, 0)
-	hasCounters := false
+	updated, err := s.repo.Increment(ctx, metrics...)
+	if err != nil {
+		return metrics, err
+	}
+	s.backupAfterUpdate(ctx)
+	s.hub.publish(updated)
+	return updated, nil
+}
 
-	for _, metric := range metrics {
-		names = append(names, metric.Name)
-		if metric.Type == domain.TypeCounter {
-			hasCounters = true
+func (s *metricsService) Get(ctx context.Context, name string, labels domain.Labels) (domain.Metric, bool, error) {
+	return s.repo.Get(ctx, name, labels)
+}
+
+func (s *metricsService) List(ctx context.Context) ([]domain.Metric, error) {
+	return s.repo.List(ctx, nil)
+}
+
+// Delete removes all series of metrics with names matching glob pattern (e.g. 'Alloc', 'Heap*'),
+// returns the amount of removed series
+func (s *metricsService) Delete(ctx context.Context, pattern string) (int, error) {
+	matched, err := s.match(ctx, pattern)
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, metric := range matched {
+		if !seen[metric.Name] {
+			seen[metric.Name] = true
+			names = append(names, metric.Name)
 		}
 	}
-	if hasCounters {
-		// Enforce atomicity for counter updates
-		s.updateMutex.Lock()
-		defer s.updateMutex.Unlock()
+	if len(names) == 0 {
+		return 0, nil
 	}
-
-	existingMetrics, err := s.repo.List(ctx, &domain.MetricsFilter{Names: names})
+	deleted, err := s.repo.Delete(ctx, names...)
 	if err != nil {
-		return metrics, err
+		return deleted, err
 	}
+	s.backupAfterUpdate(ctx)
+	return deleted, nil
+}
 
-	for _, existingMetric := range existingMetrics {
-		for i, metric := range metrics {
-			if existingMetric.IsCounter() && metric.Name == existingMetric.Name {
-				// For counters, old value is added on top of new value
-				metrics[i].Counter += existingMetric.Counter
-				break
-			}
+// ResetCounters sets all series of counters with names matching glob pattern to 0, returns the amount of reset series
+// Other metric types are left as they are
+func (s *metricsService) ResetCounters(ctx context.Context, pattern string) (int, error) {
+	matched, err := s.match(ctx, pattern)
+	if err != nil {
+		return 0, err
+	}
+	reset := make([]domain.Metric, 0)
+	for _, metric := range matched {
+		if metric.IsCounter() {
+			counter := domain.NewCounter(metric.Name, 0)
+			counter.Labels = metric.Labels.Copy()
+			reset = append(reset, counter)
 		}
 	}
-	return metrics, s.repo.Store(ctx, metrics...)
+	if len(reset) == 0 {
+		return 0, nil
+	}
+	if err = s.repo.Store(ctx, reset...); err != nil {
+		return 0, err
+	}
+	s.backupAfterUpdate(ctx)
+	return len(reset), nil
 }
 
-func (s *metricsService) Get(ctx context.Context, name string) (domain.Metric, bool, error) {
-	return s.repo.Get(ctx, name)
+// match lists metrics with names matching glob pattern
+func (s *metricsService) match(ctx context.Context, pattern string) ([]domain.Metric, error) {
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		return nil, errors.Wrapf(domain.ErrInvalidPattern, "'%s'", pattern)
+	}
+	metrics, err := s.repo.List(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]domain.Metric, 0)
+	for _, metric := range metrics {
+		if ok, _ := path.Match(pattern, metric.Name); ok {
+			matched = append(matched, metric)
+		}
+	}
+	return matched, nil
 }
 
-func (s *metricsService) List(ctx context.Context) ([]domain.Metric, error) {
-	return s.repo.List(ctx, nil)
+// History returns samples of metric within query time range, downsampled to query step (if set)
+// Rollups are used instead of raw samples when the step is coarse enough, or when raw samples are already dropped
+func (s *metricsService) History(
+	ctx context.Context,
+	name string,
+	labels domain.Labels,
+	query domain.HistoryQuery,
+) ([]domain.Sample, error) {
+	historyRepo, ok := s.repo.(MetricsHistoryRepository)
+	if !ok {
+		return nil, domain.ErrHistoryNotSupported
+	}
+	// Raw samples are used if rollups are not needed for the query, or not kept by the repo
+	resolution := s.resolutionFor(name, query, time.Now())
+	rollupRepo, hasRollups := s.repo.(MetricsRollupRepository)
+	if resolution == domain.ResolutionRaw || !hasRollups {
+		samples, err := historyRepo.History(ctx, name, labels, query.From, query.To)
+		if err != nil {
+			return nil, err
+		}
+		return downsample(samples, query.From, query.Step), nil
+	}
+
+	metric, _, err := s.repo.Get(ctx, name, labels)
+	if err != nil {
+		return nil, err
+	}
+	rollups, err := rollupRepo.Rollups(ctx, name, labels, resolution, query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+	samples := rollupsToSamples(rollups, metric.IsCumulative())
+
+	// The latest bucket is not rolled up yet, so raw samples are used for it (if they are kept)
+	tailFrom := query.From
+	if len(rollups) > 0 {
+		tailFrom = rollups[len(rollups)-1].Timestamp.Add(resolution)
+	}
+	if tailFrom.Before(query.To) {
+		tail, err := historyRepo.History(ctx, name, labels, tailFrom, query.To)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, tail...)
+	}
+	return downsample(samples, query.From, query.Step), nil
 }
 
 func (s *metricsService) mergeIdenticalMetrics(metrics []domain.Metric) []domain.Metric {
 	resultMap := make(map[string]domain.Metric, 0)
 
 	for i := 0; i < len(metrics); i++ {
-		if _, ok := resultMap[metrics[i].Name]; ok {
+		key := metrics[i].Key()
+		if _, ok := resultMap[key]; ok {
 			// Metric already set and merged
 			continue
 		}
 		// Set metric
-		resultMap[metrics[i].Name] = metrics[i]
-		// Merge metric with any possible other metrics (with the same Name)
+		resultMap[key] = metrics[i]
+		// Merge metric with any possible other metrics (of the same series)
 		for j := i + 1; j < len(metrics); j++ {
-			if metrics[i].Name == metrics[j].Name {
+			if key == metrics[j].Key() {
 				metrics[i].Counter += metrics[j].Counter
 				metrics[i].Gauge = metrics[j].Gauge
-				resultMap[metrics[i].Name] = metrics[i]
+				metrics[i].Histogram = domain.MergeHistograms(metrics[i].Histogram, metrics[j].Histogram)
+				resultMap[key] = metrics[i]
 			}
 		}
 	}
@@ -134,7 +255,56 @@ func (s *metricsService) mergeIdenticalMetrics(metrics []domain.Metric) []domain
 	return resultSlice
 }
 
+// Shutdown waits for background jobs to finish and takes the final backup, so that updates since the last one are kept
+// Context passed to NewMetricsService must be cancelled first, otherwise background jobs never finish
+func (s *metricsService) Shutdown(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "[metrics service] background jobs did not finish in time")
+	}
+
+	if s.backuper == nil {
+		return nil
+	}
+	count, err := s.backup(ctx)
+	if err != nil {
+		return errors.Wrap(err, "[metrics service] final backup failed")
+	}
+	logger.New(ctx).Infof("[metrics service] final backup successful, metrics count = %d", count)
+	return nil
+}
+
+// backupAfterUpdate takes backup in synchronous mode, update is already stored, so failed backup is only logged
+func (s *metricsService) backupAfterUpdate(ctx context.Context) {
+	if !s.syncBackup {
+		return
+	}
+	if _, err := s.backup(ctx); err != nil {
+		logger.New(ctx).Errorf("[metrics service] synchronous backup failed, error: %s", err.Error())
+	}
+}
+
+// backup writes all metrics to backuper, backups are serialized so that an older snapshot never overwrites a newer one
+func (s *metricsService) backup(ctx context.Context) (int, error) {
+	s.backupMutex.Lock()
+	defer s.backupMutex.Unlock()
+
+	metrics, err := s.repo.List(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(metrics), s.backuper.Backup(metrics)
+}
+
 func (s *metricsService) startDoingBackups(ctx context.Context, interval time.Duration) {
+	defer s.workers.Done()
+
 	backupCycles := 0
 	ticker := time.NewTicker(interval)
 	for {
@@ -143,24 +313,46 @@ func (s *metricsService) startDoingBackups(ctx context.Context, interval time.Du
 			backupCycles++
 			logger.New(ctx).Debugf("[metrics service] backup cycle %d begins", backupCycles)
 
-			metrics, err := s.repo.List(ctx, nil)
+			count, err := s.backup(ctx)
 			if err != nil {
 				logger.New(ctx).Errorf("[metrics service] backup cycle %d failed, error: %s",
 					backupCycles, err.Error())
 				continue
 			}
+			logger.New(ctx).Debugf("[metrics service] backup cycle %d successful, metrics count = %d",
+				backupCycles, count)
 
-			err = s.backuper.Backup(metrics)
+		case <-ctx.Done():
+			logger.New(ctx).Debugf("[metrics service] context cancelled, stopped doing backups")
+			return
+		}
+	}
+}
+
+// startExpiring deletes metrics that were not updated within ttl, they are checked at least once a minute
+func (s *metricsService) startExpiring(ctx context.Context, ttl time.Duration) {
+	defer s.workers.Done()
+
+	interval := ttl
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			expired, err := s.repo.Expire(ctx, time.Now().Add(-ttl))
 			if err != nil {
-				logger.New(ctx).Errorf("[metrics service] backup cycle %d failed, error: %s",
-					backupCycles, err.Error())
+				logger.New(ctx).Errorf("[metrics service] expiring metrics failed, error: %s", err.Error())
 				continue
 			}
-			logger.New(ctx).Debugf("[metrics service] backup cycle %d successful, metrics count = %d",
-				backupCycles, len(metrics))
+			if expired > 0 {
+				logger.New(ctx).Infof("[metrics service] %d metrics expired, not updated within %s", expired, ttl)
+				s.backupAfterUpdate(ctx)
+			}
 
 		case <-ctx.Done():
-			logger.New(ctx).Debugf("[metrics service] context cancelled, stopped doing backups")
+			logger.New(ctx).Debugf("[metrics service] context cancelled, stopped expiring metrics")
 			return
 		}
 	}
@@ -182,3 +374,21 @@ func (s *metricsService) restoreFromLastBackup(ctx context.Context) error {
 	logger.New(ctx).Infof("[metrics service] %d metrics from backup restored to repository", len(metrics))
 	return nil
 }
+
+// downsample leaves only the last sample within each step-long bucket, starting from 'from'
+// Samples must be sorted by time, bucket start is used as a timestamp of the resulting sample
+func downsample(samples []domain.Sample, from time.Time, step time.Duration) []domain.Sample {
+	if step <= 0 {
+		return samples
+	}
+	result := make([]domain.Sample, 0)
+	for _, sample := range samples {
+		bucket := from.Add(sample.Timestamp.Sub(from) / step * step)
+		if len(result) > 0 && result[len(result)-1].Timestamp.Equal(bucket) {
+			result[len(result)-1].Value = sample.Value
+			continue
+		}
+		result = append(result, domain.Sample{Timestamp: bucket, Value: sample.Value})
+	}
+	return result
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -22,6 +24,11 @@ func getDummyRepo() MetricsRepository {
 	return repo
 }
 
+func labelled(metric domain.Metric, key, value string) domain.Metric {
+	metric.Labels = domain.Labels{key: value}
+	return metric
+}
+
 func getDummyBackuper() MetricsBackuper {
 	return &backup.Mock{}
 }
@@ -71,7 +78,7 @@ func TestUpdate(t *testing.T) {
 			repo := repository.NewInMemRepo()
 			backuper := getDummyBackuper()
 
-			service, err := NewMetricsService(ctx, repo, backuper, config.BackupConfig{})
+			service, err := NewMetricsService(ctx, repo, backuper, config.BackupConfig{}, config.RetentionConfig{})
 			require.NoError(t, err)
 
 			var result domain.Metric
@@ -90,7 +97,7 @@ func TestUpdateWithRaceCondition(t *testing.T) {
 	repo := repository.NewInMemRepo()
 	backuper := getDummyBackuper()
 
-	service, err := NewMetricsService(ctx, repo, backuper, config.BackupConfig{})
+	service, err := NewMetricsService(ctx, repo, backuper, config.BackupConfig{}, config.RetentionConfig{})
 	require.NoError(t, err)
 
 	count := 1000
@@ -106,7 +113,7 @@ func TestUpdateWithRaceCondition(t *testing.T) {
 	}
 	wg.Wait()
 
-	metric, found, err := service.Get(ctx, domain.PollCount)
+	metric, found, err := service.Get(ctx, domain.PollCount, nil)
 
 	require.NoError(t, err)
 	assert.True(t, found)
@@ -158,6 +165,19 @@ func TestUpdateMany(t *testing.T) {
 				domain.NewGauge(domain.RandomValue, 3.4),
 			},
 		},
+		{
+			name: "update labelled counters",
+			metrics: []domain.Metric{
+				labelled(domain.NewCounter(domain.PollCount, 5), "host", "a"),
+				labelled(domain.NewCounter(domain.PollCount, 7), "host", "b"),
+				labelled(domain.NewCounter(domain.PollCount, 1), "host", "a"),
+			},
+			want: []domain.Metric{
+				// Unlabelled counter in the repo is a different series, so it is not added
+				labelled(domain.NewCounter(domain.PollCount, 6), "host", "a"),
+				labelled(domain.NewCounter(domain.PollCount, 7), "host", "b"),
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -165,7 +185,7 @@ func TestUpdateMany(t *testing.T) {
 			repo := getDummyRepo()
 			backuper := getDummyBackuper()
 
-			service, err := NewMetricsService(ctx, repo, backuper, config.BackupConfig{})
+			service, err := NewMetricsService(ctx, repo, backuper, config.BackupConfig{}, config.RetentionConfig{})
 			require.NoError(t, err)
 
 			result, err := service.UpdateMany(ctx, tt.metrics)
@@ -208,10 +228,10 @@ func TestGet(t *testing.T) {
 			repo := getDummyRepo()
 			backuper := getDummyBackuper()
 
-			service, err := NewMetricsService(ctx, repo, backuper, config.BackupConfig{})
+			service, err := NewMetricsService(ctx, repo, backuper, config.BackupConfig{}, config.RetentionConfig{})
 			require.NoError(t, err)
 
-			metric, found, err := service.Get(ctx, tt.mName)
+			metric, found, err := service.Get(ctx, tt.mName, nil)
 			require.NoError(t, err)
 			assert.Equal(t, tt.want.metric, metric)
 			assert.Equal(t, tt.want.found, found)
@@ -244,7 +264,7 @@ func TestList(t *testing.T) {
 			ctx := context.Background()
 			backuper := getDummyBackuper()
 
-			service, err := NewMetricsService(ctx, tt.repo, backuper, config.BackupConfig{})
+			service, err := NewMetricsService(ctx, tt.repo, backuper, config.BackupConfig{}, config.RetentionConfig{})
 			require.NoError(t, err)
 
 			list, err := service.List(ctx)
@@ -253,3 +273,326 @@ func TestList(t *testing.T) {
 		})
 	}
 }
+
+func TestDownsample(t *testing.T) {
+	from := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration, value float64) domain.Sample {
+		return domain.Sample{Timestamp: from.Add(d), Value: value}
+	}
+	tests := []struct {
+		name    string
+		samples []domain.Sample
+		step    time.Duration
+		want    []domain.Sample
+	}{
+		{
+			name:    "no step",
+			samples: []domain.Sample{at(time.Second, 1), at(2*time.Second, 2)},
+			step:    0,
+			want:    []domain.Sample{at(time.Second, 1), at(2*time.Second, 2)},
+		},
+		{
+			name: "last sample in bucket wins",
+			samples: []domain.Sample{
+				at(10*time.Second, 1),
+				at(50*time.Second, 2),
+				at(70*time.Second, 3),
+				at(200*time.Second, 4),
+			},
+			step: time.Minute,
+			want: []domain.Sample{
+				at(0, 2),
+				at(time.Minute, 3),
+				at(3*time.Minute, 4),
+			},
+		},
+		{
+			name:    "empty",
+			samples: []domain.Sample{},
+			step:    time.Minute,
+			want:    []domain.Sample{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, downsample(tt.samples, from, tt.step))
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	service, err := NewMetricsService(ctx, repository.NewInMemRepo(), getDummyBackuper(),
+		config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	from := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err = service.Update(ctx, domain.NewCounter(domain.PollCount, 2))
+		require.NoError(t, err)
+	}
+
+	samples, err := service.History(ctx, domain.PollCount, nil, domain.HistoryQuery{From: from, To: time.Now()})
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+	// Counter samples hold accumulated values
+	assert.Equal(t, []float64{2, 4, 6}, []float64{samples[0].Value, samples[1].Value, samples[2].Value})
+}
+
+// historyOnlyRepo keeps raw samples, but no rollups
+type historyOnlyRepo struct {
+	MetricsRepository
+	MetricsHistoryRepository
+}
+
+func TestHistoryWithoutRollups(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewInMemRepo()
+	s := &metricsService{repo: historyOnlyRepo{repo, repo}, compacting: true}
+
+	from := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, repo.Store(ctx, domain.NewGauge(domain.Alloc, 1)))
+
+	// Query would be served from hour rollups, raw samples are used instead
+	samples, err := s.History(ctx, domain.Alloc, nil, domain.HistoryQuery{From: from, To: time.Now(), Step: time.Hour})
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, float64(1), samples[0].Value)
+
+	_, err = (&metricsService{repo: struct{ MetricsRepository }{repo}}).History(ctx, domain.Alloc, nil,
+		domain.HistoryQuery{From: from, To: time.Now()})
+	assert.ErrorIs(t, err, domain.ErrHistoryNotSupported)
+}
+
+func TestUpdateLabelledSeries(t *testing.T) {
+	ctx := context.Background()
+	service, err := NewMetricsService(ctx, getDummyRepo(), getDummyBackuper(),
+		config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = service.Update(ctx, labelled(domain.NewCounter(domain.PollCount, 3), "host", "a"))
+		require.NoError(t, err)
+	}
+
+	metric, found, err := service.Get(ctx, domain.PollCount, domain.Labels{"host": "a"})
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(6), metric.Counter)
+
+	// Unlabelled series is untouched
+	metric, found, err = service.Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(10), metric.Counter)
+
+	_, found, err = service.Get(ctx, domain.PollCount, domain.Labels{"host": "b"})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	samples, err := service.History(ctx, domain.PollCount, domain.Labels{"host": "a"},
+		domain.HistoryQuery{From: time.Now().Add(-time.Minute), To: time.Now()})
+	require.NoError(t, err)
+	assert.Len(t, samples, 2)
+}
+
+func TestUpdateHistograms(t *testing.T) {
+	ctx := context.Background()
+	service, err := NewMetricsService(ctx, getDummyRepo(), getDummyBackuper(),
+		config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	delta := &domain.Histogram{Bounds: []float64{1, 10}, Counts: []uint64{1, 1, 0}, Sum: 5.5, Count: 2}
+	_, err = service.Update(ctx, domain.NewHistogram(domain.PauseNs, delta))
+	require.NoError(t, err)
+	_, err = service.UpdateMany(ctx, []domain.Metric{
+		domain.NewHistogram(domain.PauseNs, delta),
+		domain.NewHistogram(domain.PauseNs, delta),
+	})
+	require.NoError(t, err)
+
+	metric, found, err := service.Get(ctx, domain.PauseNs, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, &domain.Histogram{Bounds: []float64{1, 10}, Counts: []uint64{3, 3, 0}, Sum: 16.5, Count: 6},
+		metric.Histogram)
+
+	// Reconfigured buckets replace stored observations
+	reconfigured := &domain.Histogram{Bounds: []float64{100}, Counts: []uint64{1, 0}, Sum: 50, Count: 1}
+	updated, err := service.Update(ctx, domain.NewHistogram(domain.PauseNs, reconfigured))
+	require.NoError(t, err)
+	assert.Equal(t, reconfigured, updated.Histogram)
+}
+
+func TestSynchronousBackup(t *testing.T) {
+	ctx := context.Background()
+	backuper := &backup.Mock{}
+	service, err := NewMetricsService(ctx, getDummyRepo(), backuper,
+		config.BackupConfig{Interval: 0}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	_, err = service.Update(ctx, domain.NewCounter(domain.PollCount, 5))
+	require.NoError(t, err)
+	assert.Equal(t, 1, backuper.Backups)
+
+	_, err = service.UpdateMany(ctx, []domain.Metric{domain.NewGauge(domain.Alloc, 1)})
+	require.NoError(t, err)
+	assert.Equal(t, 2, backuper.Backups)
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 15),
+		domain.NewGauge(domain.Alloc, 1),
+	}, backuper.Metrics)
+}
+
+func TestShutdownTakesFinalBackup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	backuper := &backup.Mock{}
+	service, err := NewMetricsService(ctx, getDummyRepo(), backuper,
+		config.BackupConfig{Interval: time.Hour}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	_, err = service.Update(ctx, domain.NewCounter(domain.PollCount, 5))
+	require.NoError(t, err)
+	// Periodic backup is not due yet
+	assert.Equal(t, 0, backuper.Backups)
+
+	cancel()
+	require.NoError(t, service.Shutdown(context.Background()))
+	assert.Equal(t, 1, backuper.Backups)
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 15),
+		domain.NewGauge(domain.Alloc, 10.333),
+	}, backuper.Metrics)
+}
+
+func TestShutdownWaitsForBackgroundJobs(t *testing.T) {
+	service, err := NewMetricsService(context.Background(), getDummyRepo(), getDummyBackuper(),
+		config.BackupConfig{Interval: time.Hour}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	// Service context is never cancelled, so background backups never stop
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, service.Shutdown(shutdownCtx), context.DeadlineExceeded)
+}
+
+func TestUpdateAcrossReplicas(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+
+	// Two server replicas share the same repository
+	services := make([]*metricsService, 2)
+	for i := range services {
+		repo, err := repository.NewRedisRepo(ctx, config.RedisConfig{Address: server.Addr(), Timeout: time.Second})
+		require.NoError(t, err)
+		services[i], err = NewMetricsService(ctx, repo, nil, config.BackupConfig{}, config.RetentionConfig{})
+		require.NoError(t, err)
+	}
+
+	updated, err := services[0].Update(ctx, domain.NewCounter(domain.PollCount, 5))
+	require.NoError(t, err)
+	assert.Equal(t, domain.NewCounter(domain.PollCount, 5), updated)
+
+	list, err := services[1].UpdateMany(ctx, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 1),
+		domain.NewCounter(domain.PollCount, 2),
+		domain.NewGauge(domain.Alloc, 1),
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 8),
+		domain.NewGauge(domain.Alloc, 1),
+	}, list)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(service *metricsService) {
+			defer wg.Done()
+			_, updateErr := service.Update(ctx, domain.NewCounter(domain.PollCount, 1))
+			assert.NoError(t, updateErr)
+		}(services[i%2])
+	}
+	wg.Wait()
+
+	metric, found, err := services[0].Get(ctx, domain.PollCount, nil)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, domain.Counter(108), metric.Counter)
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.Background()
+	service, err := NewMetricsService(ctx, getDummyRepo(), getDummyBackuper(),
+		config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	_, err = service.UpdateMany(ctx, []domain.Metric{
+		labelled(domain.NewCounter(domain.PollCount, 3), "host", "a"),
+		domain.NewGauge(domain.HeapSys, 1),
+	})
+	require.NoError(t, err)
+
+	_, err = service.Delete(ctx, "[")
+	assert.ErrorIs(t, err, domain.ErrInvalidPattern)
+
+	deleted, err := service.Delete(ctx, "Poll*")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	deleted, err = service.Delete(ctx, "Poll*")
+	require.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	list, err := service.List(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewGauge(domain.Alloc, 10.333),
+		domain.NewGauge(domain.HeapSys, 1),
+	}, list)
+}
+
+func TestResetCounters(t *testing.T) {
+	ctx := context.Background()
+	service, err := NewMetricsService(ctx, getDummyRepo(), getDummyBackuper(),
+		config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	_, err = service.Update(ctx, labelled(domain.NewCounter(domain.PollCount, 3), "host", "a"))
+	require.NoError(t, err)
+
+	// Gauges are not reset
+	reset, err := service.ResetCounters(ctx, "*")
+	require.NoError(t, err)
+	assert.Equal(t, 2, reset)
+
+	list, err := service.List(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 0),
+		labelled(domain.NewCounter(domain.PollCount, 0), "host", "a"),
+		domain.NewGauge(domain.Alloc, 10.333),
+	}, list)
+
+	// Counters keep counting from 0
+	updated, err := service.Update(ctx, domain.NewCounter(domain.PollCount, 2))
+	require.NoError(t, err)
+	assert.Equal(t, domain.Counter(2), updated.Counter)
+}
+
+func TestExpire(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service, err := NewMetricsService(ctx, getDummyRepo(), getDummyBackuper(),
+		config.BackupConfig{}, config.RetentionConfig{MetricTTL: 50 * time.Millisecond})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		list, listErr := service.List(ctx)
+		return listErr == nil && len(list) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, service.Shutdown(context.Background()))
+}
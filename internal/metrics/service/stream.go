@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+)
+
+// hub fans out updated metrics to stream subscribers
+// Publishing never blocks updates: every subscriber has a bounded queue, and subscribers that fall behind
+// are disconnected rather than silently skipping updates, so that they reconnect and read current values again
+type hub struct {
+	cfg         config.StreamConfig
+	subscribers map[*Subscription]bool
+	closed      bool
+	mutex       *sync.Mutex
+}
+
+// Subscription receives batches of metrics updated by Update/UpdateMany that match its filter,
+// as well as periodic heartbeats, until it is closed (Done), Err tells why it was closed by the hub
+type Subscription struct {
+	hub        *hub
+	matches    func(domain.Metric) bool
+	updates    chan []domain.Metric
+	heartbeats chan struct{}
+	done       chan struct{}
+	// err is guarded by hub mutex
+	err error
+}
+
+func newHub(cfg config.StreamConfig) *hub {
+	return &hub{
+		cfg:         cfg,
+		subscribers: make(map[*Subscription]bool),
+		mutex:       &sync.Mutex{},
+	}
+}
+
+// EnableStreaming lets clients subscribe to updates, heartbeats are sent to subscribers until ctx is cancelled,
+// then all subscribers are disconnected
+func (s *metricsService) EnableStreaming(ctx context.Context, cfg config.StreamConfig) {
+	s.hub = newHub(cfg)
+	s.workers.Add(1)
+	go s.startHeartbeats(ctx)
+}
+
+// Stream is what subscribers see of Subscription
+// It is an alias of interface literal rather than a named type, so that consumers can declare identical interface
+// of their own, which Subscribe satisfies without them importing this package
+type Stream = interface {
+	Updates() <-chan []domain.Metric
+	Heartbeats() <-chan struct{}
+	Done() <-chan struct{}
+	Err() error
+	Close()
+}
+
+// Subscribe starts receiving updates of metrics matching the filter, subscription must be closed when not needed
+func (s *metricsService) Subscribe(filter domain.MetricsFilter) (Stream, error) {
+	if s.hub == nil {
+		return nil, domain.ErrStreamingDisabled
+	}
+	matches, err := filter.Matcher()
+	if err != nil {
+		return nil, errors.Wrap(domain.ErrInvalidQuery, err.Error())
+	}
+	sub, err := s.hub.subscribe(matches)
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// CloseStreams disconnects all subscribers, e.g. on server shutdown, so that streaming requests finish
+func (s *metricsService) CloseStreams() {
+	if s.hub != nil {
+		s.hub.close()
+	}
+}
+
+func (s *metricsService) startHeartbeats(ctx context.Context) {
+	defer s.workers.Done()
+
+	// Heartbeats are disabled if interval is not set, subscribers are still disconnected on cancel
+	var tick <-chan time.Time
+	if s.hub.cfg.Heartbeat > 0 {
+		ticker := time.NewTicker(s.hub.cfg.Heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			s.hub.heartbeat()
+		case <-ctx.Done():
+			s.hub.close()
+			logger.New(ctx).Debugf("[metrics service] context cancelled, stopped streaming")
+			return
+		}
+	}
+}
+
+// publish sends updated metrics to subscribers, each subscriber gets only metrics matching its filter
+func (h *hub) publish(metrics []domain.Metric) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for sub := range h.subscribers {
+		matched := make([]domain.Metric, 0)
+		for _, metric := range metrics {
+			if sub.matches(metric) {
+				matched = append(matched, metric)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		select {
+		case sub.updates <- matched:
+		default:
+			h.unsubscribe(sub, domain.ErrSlowSubscriber)
+		}
+	}
+}
+
+func (h *hub) subscribe(matches func(domain.Metric) bool) (*Subscription, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		return nil, domain.ErrStreamClosed
+	}
+	if h.cfg.MaxSubscribers > 0 && len(h.subscribers) >= h.cfg.MaxSubscribers {
+		return nil, domain.ErrTooManySubscribers
+	}
+	sub := &Subscription{
+		hub:        h,
+		matches:    matches,
+		updates:    make(chan []domain.Metric, h.cfg.BufferSize),
+		heartbeats: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	h.subscribers[sub] = true
+	return sub, nil
+}
+
+// heartbeat is skipped for subscribers that have not consumed the previous one yet
+func (h *hub) heartbeat() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for sub := range h.subscribers {
+		select {
+		case sub.heartbeats <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (h *hub) close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		h.unsubscribe(sub, domain.ErrStreamClosed)
+	}
+}
+
+// unsubscribe must be called with hub mutex held
+func (h *hub) unsubscribe(sub *Subscription, err error) {
+	if !h.subscribers[sub] {
+		return
+	}
+	delete(h.subscribers, sub)
+	sub.err = err
+	close(sub.done)
+}
+
+// Updates delivers batches of updated metrics, a batch holds metrics of a single Update/UpdateMany call
+func (s *Subscription) Updates() <-chan []domain.Metric {
+	return s.updates
+}
+
+func (s *Subscription) Heartbeats() <-chan struct{} {
+	return s.heartbeats
+}
+
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason subscription was closed by the hub (domain.ErrSlowSubscriber or domain.ErrStreamClosed),
+// it is nil while subscription is open or if it was closed by the subscriber
+func (s *Subscription) Err() error {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+	return s.err
+}
+
+func (s *Subscription) Close() {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+	s.hub.unsubscribe(s, nil)
+}
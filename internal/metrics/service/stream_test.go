@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/domain"
+	"eridiumdev/yandex-praktikum-go-devops/internal/metrics/repository"
+)
+
+func TestStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service, err := NewMetricsService(ctx, repository.NewInMemRepo(), nil, config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+
+	_, err = service.Subscribe(domain.MetricsFilter{})
+	assert.ErrorIs(t, err, domain.ErrStreamingDisabled)
+
+	service.EnableStreaming(ctx, config.StreamConfig{BufferSize: 10, MaxSubscribers: 2})
+
+	counters, err := service.Subscribe(domain.MetricsFilter{Types: []string{domain.TypeCounter}})
+	require.NoError(t, err)
+	defer counters.Close()
+	alloc, err := service.Subscribe(domain.MetricsFilter{Names: []string{domain.Alloc}})
+	require.NoError(t, err)
+
+	_, err = service.Subscribe(domain.MetricsFilter{})
+	assert.ErrorIs(t, err, domain.ErrTooManySubscribers)
+	_, err = service.Subscribe(domain.MetricsFilter{Regex: "("})
+	assert.ErrorIs(t, err, domain.ErrInvalidQuery)
+
+	_, err = service.Update(ctx, domain.NewCounter(domain.PollCount, 5))
+	require.NoError(t, err)
+	_, err = service.UpdateMany(ctx, []domain.Metric{
+		domain.NewCounter(domain.PollCount, 2),
+		domain.NewGauge(domain.Alloc, 1),
+	})
+	require.NoError(t, err)
+
+	// Subscribers get resulting values of matching metrics only, batched per update
+	assert.Equal(t, []domain.Metric{domain.NewCounter(domain.PollCount, 5)}, <-counters.Updates())
+	assert.Equal(t, []domain.Metric{domain.NewCounter(domain.PollCount, 7)}, <-counters.Updates())
+	assert.Equal(t, []domain.Metric{domain.NewGauge(domain.Alloc, 1)}, <-alloc.Updates())
+
+	// Closed subscription frees its slot
+	alloc.Close()
+	assert.Nil(t, alloc.Err())
+	all, err := service.Subscribe(domain.MetricsFilter{})
+	require.NoError(t, err)
+
+	// All subscribers are disconnected when service context is cancelled
+	cancel()
+	require.NoError(t, service.Shutdown(context.Background()))
+	for _, sub := range []Stream{counters, all} {
+		<-sub.Done()
+		assert.ErrorIs(t, sub.Err(), domain.ErrStreamClosed)
+	}
+	_, err = service.Subscribe(domain.MetricsFilter{})
+	assert.ErrorIs(t, err, domain.ErrStreamClosed)
+}
+
+func TestStreamSlowSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service, err := NewMetricsService(ctx, repository.NewInMemRepo(), nil, config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+	service.EnableStreaming(ctx, config.StreamConfig{BufferSize: 2})
+
+	slow, err := service.Subscribe(domain.MetricsFilter{})
+	require.NoError(t, err)
+	fast, err := service.Subscribe(domain.MetricsFilter{})
+	require.NoError(t, err)
+	defer fast.Close()
+
+	// Updates are not blocked by subscribers, the one that falls behind is disconnected
+	for i := 0; i < 5; i++ {
+		_, err = service.Update(ctx, domain.NewCounter(domain.PollCount, 1))
+		require.NoError(t, err)
+		<-fast.Updates()
+	}
+	<-slow.Done()
+	assert.ErrorIs(t, slow.Err(), domain.ErrSlowSubscriber)
+
+	select {
+	case <-fast.Done():
+		t.Fatal("subscriber that keeps up should not be disconnected")
+	default:
+	}
+}
+
+func TestStreamHeartbeats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	service, err := NewMetricsService(ctx, repository.NewInMemRepo(), nil, config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+	service.EnableStreaming(ctx, config.StreamConfig{BufferSize: 1, Heartbeat: 5 * time.Millisecond})
+
+	sub, err := service.Subscribe(domain.MetricsFilter{})
+	require.NoError(t, err)
+	defer sub.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sub.Heartbeats():
+		case <-time.After(time.Second):
+			t.Fatal("heartbeat was not sent")
+		}
+	}
+}
+
+// journalingRepo accepts increments without writing them, like write-behind repo while database is unavailable
+type journalingRepo struct {
+	MetricsRepository
+}
+
+func (r journalingRepo) Increment(ctx context.Context, metrics ...domain.Metric) ([]domain.Metric, error) {
+	return nil, domain.ErrUpdateJournaled
+}
+
+func TestStreamSkipsJournaledUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := journalingRepo{repository.NewInMemRepo()}
+	service, err := NewMetricsService(ctx, repo, nil, config.BackupConfig{}, config.RetentionConfig{})
+	require.NoError(t, err)
+	service.EnableStreaming(ctx, config.StreamConfig{BufferSize: 1})
+
+	sub, err := service.Subscribe(domain.MetricsFilter{})
+	require.NoError(t, err)
+	defer sub.Close()
+
+	// Resulting values of journaled updates are not known, so there is nothing to publish
+	_, err = service.Update(ctx, domain.NewCounter(domain.PollCount, 5))
+	assert.ErrorIs(t, err, domain.ErrUpdateJournaled)
+	_, err = service.UpdateMany(ctx, []domain.Metric{domain.NewGauge(domain.Alloc, 1)})
+	assert.ErrorIs(t, err, domain.ErrUpdateJournaled)
+
+	select {
+	case update := <-sub.Updates():
+		t.Fatalf("journaled update was published: %v", update)
+	default:
+	}
+}
@@ -2,6 +2,7 @@ package http
 
 import (
 	"net/http"
+	"strings"
 
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/handlers"
 	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
@@ -23,13 +24,24 @@ func (h *MonitoringHandler) Ping(w http.ResponseWriter, r *http.Request) {
 	ctx := logger.ContextFromRequest(r)
 
 	statusOk := true
+	reasons := make([]string, 0)
 
 	for _, component := range h.components {
 		statusOk = statusOk && component.Ping(ctx)
+		// Degraded components are still OK, but it is reported in response body
+		if degradable, ok := component.(Degradable); ok {
+			if degraded, reason := degradable.Degraded(ctx); degraded {
+				reasons = append(reasons, reason)
+			}
+		}
 	}
 
 	if !statusOk {
-		h.PlainText(ctx, w, http.StatusInternalServerError, "")
+		h.PlainText(ctx, w, http.StatusInternalServerError, strings.Join(reasons, "\n"))
+		return
+	}
+	if len(reasons) > 0 {
+		h.PlainText(ctx, w, http.StatusOK, "degraded: "+strings.Join(reasons, "\n"))
 		return
 	}
 
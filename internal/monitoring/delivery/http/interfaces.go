@@ -8,3 +8,8 @@ import "context"
 type Pingable interface {
 	Ping(ctx context.Context) bool
 }
+
+// Degradable can keep working in degraded mode, e.g. journaling updates while database is unavailable
+type Degradable interface {
+	Degraded(ctx context.Context) (degraded bool, reason string)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+
+	"eridiumdev/yandex-praktikum-go-devops/config"
+	"eridiumdev/yandex-praktikum-go-devops/internal/common/logger"
+)
+
+type GRPCServer struct {
+	Server  *grpc.Server
+	address string
+}
+
+func NewGRPCServer(cfg *config.ServerConfig, opts ...grpc.ServerOption) *GRPCServer {
+	return &GRPCServer{
+		Server:  grpc.NewServer(opts...),
+		address: cfg.GRPCAddress,
+	}
+}
+
+func (s *GRPCServer) Start(ctx context.Context) {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		logger.New(ctx).Fatalf("Failed to listen on %s for gRPC server: %s", s.address, err.Error())
+	}
+	err = s.Server.Serve(listener)
+	if err != nil {
+		logger.New(ctx).Fatalf("Failed to start gRPC server: %s", err.Error())
+	}
+}
+
+func (s *GRPCServer) Stop(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		s.Server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.New(ctx).Errorf("Context cancelled when stopping gRPC server, forcing stop")
+		s.Server.Stop()
+	}
+}
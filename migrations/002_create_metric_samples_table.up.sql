@@ -0,0 +1,13 @@
+-- Generated with `migrate create -ext sql -dir migrations -seq -digits 3 create_metric_samples_table`
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS metric_samples (
+    name  varchar(64)      NOT NULL,
+    ts    timestamptz      NOT NULL DEFAULT now(),
+    value double precision NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS metric_samples_name_ts_idx ON metric_samples (name, ts);
+
+COMMIT;
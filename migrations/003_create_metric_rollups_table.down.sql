@@ -0,0 +1,5 @@
+-- Generated with `migrate create -ext sql -dir migrations -seq -digits 3 create_metric_rollups_table`
+
+BEGIN;
+DROP TABLE IF EXISTS metric_rollups;
+COMMIT;
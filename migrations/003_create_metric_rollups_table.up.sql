@@ -0,0 +1,18 @@
+-- Generated with `migrate create -ext sql -dir migrations -seq -digits 3 create_metric_rollups_table`
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS metric_rollups (
+    name       varchar(64)      NOT NULL,
+    resolution integer          NOT NULL,
+    ts         timestamptz      NOT NULL,
+    min        double precision NOT NULL,
+    max        double precision NOT NULL,
+    sum        double precision NOT NULL,
+    count      bigint           NOT NULL,
+    last       double precision NOT NULL,
+    delta      double precision NOT NULL,
+    PRIMARY KEY (name, resolution, ts)
+);
+
+COMMIT;
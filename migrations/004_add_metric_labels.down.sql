@@ -0,0 +1,21 @@
+-- Generated with `migrate create -ext sql -dir migrations -seq -digits 3 add_metric_labels`
+
+BEGIN;
+
+-- Labelled series cannot be kept without labels, as they would clash with each other
+DELETE FROM metric_rollups WHERE labels <> '{}';
+ALTER TABLE metric_rollups DROP CONSTRAINT IF EXISTS metric_rollups_pkey;
+ALTER TABLE metric_rollups DROP COLUMN IF EXISTS labels;
+ALTER TABLE metric_rollups ADD PRIMARY KEY (name, resolution, ts);
+
+DELETE FROM metric_samples WHERE labels <> '{}';
+DROP INDEX IF EXISTS metric_samples_name_labels_ts_idx;
+ALTER TABLE metric_samples DROP COLUMN IF EXISTS labels;
+CREATE INDEX IF NOT EXISTS metric_samples_name_ts_idx ON metric_samples (name, ts);
+
+DELETE FROM metrics WHERE labels <> '{}';
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_name_labels_key;
+ALTER TABLE metrics DROP COLUMN IF EXISTS labels;
+ALTER TABLE metrics ADD CONSTRAINT metrics_name_key UNIQUE (name);
+
+COMMIT;
@@ -0,0 +1,18 @@
+-- Generated with `migrate create -ext sql -dir migrations -seq -digits 3 add_metric_labels`
+
+BEGIN;
+
+-- Series are identified by name and labels, metrics without labels have empty labels object
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_name_key;
+ALTER TABLE metrics ADD CONSTRAINT metrics_name_labels_key UNIQUE (name, labels);
+
+ALTER TABLE metric_samples ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+DROP INDEX IF EXISTS metric_samples_name_ts_idx;
+CREATE INDEX IF NOT EXISTS metric_samples_name_labels_ts_idx ON metric_samples (name, labels, ts);
+
+ALTER TABLE metric_rollups ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE metric_rollups DROP CONSTRAINT IF EXISTS metric_rollups_pkey;
+ALTER TABLE metric_rollups ADD PRIMARY KEY (name, labels, resolution, ts);
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Generated with `migrate create -ext sql -dir migrations -seq -digits 3 add_histogram_metric_type`
+
+BEGIN;
+
+-- Enum values cannot be dropped, so the type is recreated without 'histogram'
+DELETE FROM metrics WHERE type = 'histogram';
+ALTER TABLE metrics DROP COLUMN IF EXISTS histogram;
+
+ALTER TYPE metric_type RENAME TO metric_type_old;
+CREATE TYPE metric_type AS ENUM ('counter', 'gauge');
+ALTER TABLE metrics ALTER COLUMN type TYPE metric_type USING type::text::metric_type;
+DROP TYPE metric_type_old;
+
+COMMIT;
@@ -0,0 +1,7 @@
+-- Generated with `migrate create -ext sql -dir migrations -seq -digits 3 add_histogram_metric_type`
+
+-- No transaction: 'ALTER TYPE ... ADD VALUE' cannot run inside a transaction block before Postgres 12
+ALTER TYPE metric_type ADD VALUE IF NOT EXISTS 'histogram';
+
+-- Histogram bounds, bucket counts, sum and count are stored as a single JSON object
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS histogram jsonb NULL;
@@ -0,0 +1,8 @@
+-- Generated with `migrate create -ext sql -dir migrations -seq -digits 3 add_metric_updated_at`
+
+BEGIN;
+
+DROP INDEX IF EXISTS metrics_updated_at_idx;
+ALTER TABLE metrics DROP COLUMN IF EXISTS updated_at;
+
+COMMIT;
@@ -0,0 +1,9 @@
+-- Generated with `migrate create -ext sql -dir migrations -seq -digits 3 add_metric_updated_at`
+
+BEGIN;
+
+-- Series that are not updated for a while are expired (see RETENTION_METRIC_TTL)
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS metrics_updated_at_idx ON metrics (updated_at);
+
+COMMIT;
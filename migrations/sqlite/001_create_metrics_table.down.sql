@@ -0,0 +1,3 @@
+-- Generated with `migrate create -ext sql -dir migrations/sqlite -seq -digits 3 create_metrics_table`
+
+DROP TABLE IF EXISTS metrics;
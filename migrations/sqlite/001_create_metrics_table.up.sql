@@ -0,0 +1,16 @@
+-- Generated with `migrate create -ext sql -dir migrations/sqlite -seq -digits 3 create_metrics_table`
+
+-- No explicit transaction: sqlite driver wraps every migration in a transaction by itself
+
+-- Series are identified by name and labels, labels are JSON object with sorted keys ('{}' if there are none)
+-- Histogram bounds, bucket counts, sum and count are stored as a single JSON object
+CREATE TABLE IF NOT EXISTS metrics (
+    id        integer PRIMARY KEY AUTOINCREMENT,
+    name      text    NOT NULL,
+    type      text    NOT NULL CHECK (type IN ('counter', 'gauge', 'histogram')),
+    counter   integer NULL,
+    gauge     real    NULL,
+    labels    text    NOT NULL DEFAULT '{}',
+    histogram text    NULL,
+    UNIQUE (name, labels)
+);
@@ -0,0 +1,4 @@
+-- Generated with `migrate create -ext sql -dir migrations/sqlite -seq -digits 3 add_metric_updated_at`
+
+DROP INDEX IF EXISTS metrics_updated_at_idx;
+ALTER TABLE metrics DROP COLUMN updated_at;
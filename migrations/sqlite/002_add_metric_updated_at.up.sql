@@ -0,0 +1,7 @@
+-- Generated with `migrate create -ext sql -dir migrations/sqlite -seq -digits 3 add_metric_updated_at`
+
+-- Series that are not updated for a while are expired (see RETENTION_METRIC_TTL)
+-- Time is kept as unix milliseconds, existing series are considered updated now
+ALTER TABLE metrics ADD COLUMN updated_at integer NOT NULL DEFAULT 0;
+UPDATE metrics SET updated_at = CAST((julianday('now') - 2440587.5) * 86400000 AS integer);
+CREATE INDEX IF NOT EXISTS metrics_updated_at_idx ON metrics (updated_at);
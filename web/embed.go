@@ -0,0 +1,20 @@
+// Package web holds web assets, which are embedded into the server binary
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed templates
+var files embed.FS
+
+// Templates returns embedded HTML templates, paths are relative to 'templates' directory (e.g. 'dashboard.html')
+func Templates() fs.FS {
+	templates, err := fs.Sub(files, "templates")
+	if err != nil {
+		// Path is constant and valid, so this never happens
+		panic(err)
+	}
+	return templates
+}